@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+)
+
+type AggregateCmd struct {
+	Files       []string `arg:"" type:"existingfile" help:"Input files containing CIDRs in CSV, TSV, JSON or text format, a text file has a CIDR per line"`
+	CidrKey     string   `help:"Key/Colum of the CIDRs in the file" default:"cidr"`
+	InputFormat string   `enum:"auto,json,csv,tsv,txt" default:"auto" help:"Format of the input files, auto uses the extension, without .gz or .bz2, or the content of the files without extension, like stdin"`
+	Keys        []string `help:"Attribute keys grouping the CIDRs, the CIDRs of all the records are aggregated together if none" default:""`
+	Format      string   `enum:"text,csv,json" default:"text" help:"Output format, text prints a CIDR per line, after a # comment with the values of the keys of each group"`
+}
+
+// Run aggregates the CIDRs of the files by group, and prints the minimal list of CIDRs of each group.
+func (cmd *AggregateCmd) Run(ctx *Context) error {
+	flags := &ParseFlags{CidrKey: cmd.CidrKey, InputFormat: cmd.InputFormat}
+	records := []supernet.CidrRecord{}
+	for _, file := range cmd.Files {
+		in, parser, err := openParser(file, flags)
+		if err != nil {
+			return err
+		}
+		err = parser.Parse(flags, in, in.name(), func(cidr *CIDR) error {
+			records = append(records, supernet.CidrRecord{CIDR: cidr.cidr, Metadata: cidr.Metadata})
+			return ctx.Err()
+		})
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	groups := supernet.Aggregate(records, cmd.Keys)
+
+	output := bufio.NewWriterSize(os.Stdout, writeBufferSize)
+	var err error
+	switch cmd.Format {
+	case "csv":
+		err = cmd.encode(output, &CsvEncoder{}, groups)
+	case "json":
+		err = cmd.encode(output, &JsonEncoder{}, groups)
+	default:
+		err = cmd.printText(output, groups)
+	}
+	if err != nil {
+		return err
+	}
+	return output.Flush()
+}
+
+// writes a record per CIDR, with the values of the keys of its group
+func (cmd *AggregateCmd) encode(w io.Writer, encoder RecordEncoder, groups []*supernet.AggregateGroup) error {
+	if err := encoder.Begin(w); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		record := map[string]string{}
+		for key, value := range group.Attributes {
+			record[key] = value
+		}
+		for _, cidr := range group.CIDRs {
+			record[cmd.CidrKey] = cidr.String()
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+	}
+	return encoder.End()
+}
+
+// prints the CIDRs of each group after a comment with the values of its keys, the output can be read as a text input file
+func (cmd *AggregateCmd) printText(w io.Writer, groups []*supernet.AggregateGroup) error {
+	for _, group := range groups {
+		if len(cmd.Keys) > 0 {
+			values := []string{}
+			for key, value := range group.Attributes {
+				values = append(values, key+"="+value)
+			}
+			sort.Strings(values)
+			if _, err := fmt.Fprintf(w, "# %s\n", strings.Join(values, " ")); err != nil {
+				return err
+			}
+		}
+		for _, cidr := range group.CIDRs {
+			if _, err := fmt.Fprintln(w, cidr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var aggregateFiles = map[string]string{
+	"feed.csv": "cidr,name,asn\n" +
+		"10.0.0.0/24,a,1\n" +
+		"10.0.1.0/24,a,1\n" +
+		"10.0.2.0/23,a,2\n" +
+		"10.0.0.128/25,a,1\n" +
+		"10.1.0.0/16,b,1\n" +
+		"2001:db8::/33,b,1\n" +
+		"2001:db8:8000::/33,b,1\n",
+	"more.txt": "# a CIDR per line\n10.2.0.0/16\n\n10.3.0.0/16\n",
+}
+
+func TestAggregate(t *testing.T) {
+	dir := writeFiles(t, aggregateFiles)
+	for name, args := range map[string][]string{
+		"aggregate_all.txt":   {filepath.Join(dir, "feed.csv"), filepath.Join(dir, "more.txt")},
+		"aggregate_name.txt":  {filepath.Join(dir, "feed.csv"), "--keys", "name"},
+		"aggregate_keys.csv":  {filepath.Join(dir, "feed.csv"), "--keys", "name,asn", "--format", "csv"},
+		"aggregate_name.json": {filepath.Join(dir, "feed.csv"), "--keys", "name", "--format", "json"},
+	} {
+		output, err := runCli(t, append([]string{"aggregate"}, args...)...)
+		assert.NoError(t, err, name)
+		assertGolden(t, name, output)
+	}
+}
@@ -1,30 +1,88 @@
 package cli
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
 
 	"github.com/alecthomas/kong"
 	"github.com/khalid-nowaf/supernet/pkg/supernet"
 )
 
-// ResolveCmd represents the command to resolve CIDR conflicts.
+// Context is passed to the commands, it is canceled by an interrupt signal.
 type Context struct {
-	super *supernet.Supernet
+	context.Context
+	super  *supernet.Supernet
+	logged bool         // the insertion results are logged with --log
+	logger *slog.Logger // the messages of the long running commands, on stderr with the --log-format and --log-level
 }
 
-var cli struct {
-	Log     bool       `help:"Print the details about the inserted CIDR and the conflicts if any"`
-	Resolve ResolveCmd `cmd:"" help:"Resolve CIDR conflicts"`
+// CLI has the global flags and the commands.
+type CLI struct {
+	Log       bool         `help:"Print the details about the inserted CIDR and the conflicts if any to stderr"`
+	LogLevel  string       `enum:"debug,info,warn,error" default:"debug" help:"Minimum level of the --log output and of the serve messages, insertions without conflict are logged at debug, and conflicts at info"`
+	LogFormat string       `enum:"text,json,results" default:"text" help:"Format of the --log output and of the serve messages, text and json are log records, results prints each insertion result as a JSON line"`
+	Resolve   ResolveCmd   `cmd:"" help:"Resolve CIDR conflicts"`
+	Serve     ServeCmd     `cmd:"" help:"Serve the lookups of a resolved db file over HTTP"`
+	Lookup    LookupCmd    `cmd:"" help:"Look up IPs or CIDRs in input files or in a resolved db file"`
+	Diff      DiffCmd      `cmd:"" help:"Compare the resolved CIDRs of two input files or resolved db files"`
+	Validate  ValidateCmd  `cmd:"" help:"Check the records of input files, and optionally write fixed copies"`
+	Aggregate AggregateCmd `cmd:"" help:"Merge the CIDRs of input files into the minimal list of CIDRs covering them, by group of attributes"`
+	Convert   ConvertCmd   `cmd:"" help:"Convert a file to another format, resolving its CIDRs or not"`
 }
 
+var cli CLI
+
 func NewCLI(super *supernet.Supernet) {
 	ctx := kong.Parse(&cli, kong.UsageOnError())
 
-	if cli.Log {
-		// configure supernet to use simple logger
-		super = supernet.WithSimpleLogger()(super)
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := cli.run(ctx, &Context{Context: signalCtx, super: super}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+// runs the parsed command, with the logger of the --log flags
+func (c *CLI) run(command *kong.Context, ctx *Context) error {
+	ctx.logger = newSlogLogger(os.Stderr, c.LogFormat, c.LogLevel)
+	var jsonLogger *supernet.JsonLogger
+	if c.Log {
+		ctx.logged = true
+		// the log is written to stderr, so it is never mixed with the data that the commands write to stdout
+		logOutput := os.Stderr
+		if c.LogFormat == "results" {
+			jsonLogger = supernet.NewJsonLogger(logOutput)
+			ctx.super = supernet.WithJsonLogger(jsonLogger)(ctx.super)
+		} else {
+			ctx.super = supernet.WithSlog(newSlogLogger(logOutput, c.LogFormat, c.LogLevel))(ctx.super)
+		}
+	}
+	if err := command.Run(ctx); err != nil {
+		return err
+	}
+	if jsonLogger != nil && jsonLogger.Err() != nil {
+		return fmt.Errorf("the --log output failed, the insertion results after the error are not logged: %w", jsonLogger.Err())
+	}
+	return nil
+}
+
+// returns a slog logger writing to w with the --log-format and --log-level flags, the results format logs as text
+func newSlogLogger(w io.Writer, format string, level string) *slog.Logger {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		panic("[BUG] newSlogLogger: --log-level must be validated by the parser: " + err.Error())
 	}
-	if err := ctx.Run(&Context{super: super}); err != nil {
-		fmt.Printf("Error: %v\n", err)
+	handlerOptions := &slog.HandlerOptions{Level: slogLevel}
+
+	var handler slog.Handler = slog.NewTextHandler(w, handlerOptions)
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, handlerOptions)
 	}
+	return slog.New(handler)
 }
@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// runs the command line args with a new supernet, and returns what the command printed to stdout
+func runCli(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	stdout, _, err := runCliStderr(t, args...)
+	return stdout, err
+}
+
+// runs the command line args like runCli, and also returns what the command printed to stderr
+func runCliStderr(t *testing.T, args ...string) (string, string, error) {
+	t.Helper()
+	parsed := CLI{}
+	parser, err := kong.New(&parsed, kong.Exit(func(int) { t.Fatalf("exit while parsing %v", args) }))
+	assert.NoError(t, err)
+	ctx, err := parser.Parse(args)
+	if err != nil {
+		return "", "", err
+	}
+
+	stdout, stderr := os.Stdout, os.Stderr
+	stdoutOutput, stdoutWrite := capture(t)
+	stderrOutput, stderrWrite := capture(t)
+	os.Stdout, os.Stderr = stdoutWrite, stderrWrite
+	err = parsed.run(ctx, &Context{Context: context.Background(), super: supernet.NewSupernet()})
+	stdoutWrite.Close()
+	stderrWrite.Close()
+	os.Stdout, os.Stderr = stdout, stderr
+	return <-stdoutOutput, <-stderrOutput, err
+}
+
+// returns a pipe to write to, and the channel of what was written to it once it is closed
+func capture(t *testing.T) (<-chan string, *os.File) {
+	t.Helper()
+	read, write, err := os.Pipe()
+	assert.NoError(t, err)
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(read)
+		output <- string(data)
+	}()
+	return output, write
+}
+
+// writes the files in a temporary directory, and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	return dir
+}
+
+// compares got with the golden file testdata/name, or updates it with -update
+func assertGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		assert.NoError(t, os.WriteFile(path, []byte(got), 0o644))
+	}
+	expected, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), got, name)
+}
+
+// returns the content of a file written by a command
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+// removes the directory of the test files from the output of a command, so it can be compared to a golden file
+func trimDir(output string, dir string) string {
+	return strings.ReplaceAll(output, dir+string(filepath.Separator), "")
+}
+
+var logFiles = map[string]string{"in.csv": "cidr,p\n10.0.0.0/8,1\n10.1.0.0/16,2\n"}
+
+func TestLogFormats(t *testing.T) {
+	dir := writeFiles(t, logFiles)
+	output := filepath.Join(dir, "out.csv")
+	for _, format := range []string{"results", "json", "text"} {
+		printed, logged, err := runCliStderr(t, "--log", "--log-format", format, "--log-level", "info", "resolve", filepath.Join(dir, "in.csv"), "--priority-keys", "p", "-o", output)
+		assert.NoError(t, err, format)
+		assert.Empty(t, logLines(printed), format)
+		entries := logLines(logged)
+
+		switch format {
+		case "results":
+			// every insertion result, as returned by InsertCidr, whatever the level
+			assert.Len(t, entries, 2)
+			result := map[string]any{}
+			assert.NoError(t, json.Unmarshal([]byte(entries[1]), &result))
+			assert.Equal(t, "10.1.0.0/16", result["cidr"])
+			assert.Equal(t, "sub_cidr", result["conflict_type"])
+			assert.Equal(t, []any{"10.0.0.0/8"}, result["conflicted_with"])
+			assert.Len(t, result["actions"], 3)
+		case "json":
+			assert.Len(t, entries, 1)
+			record := map[string]any{}
+			assert.NoError(t, json.Unmarshal([]byte(entries[0]), &record))
+			assert.Equal(t, "INFO", record["level"])
+			assert.Equal(t, "10.1.0.0/16", record["cidr"])
+			assert.Equal(t, "sub_cidr", record["conflict_type"])
+		case "text":
+			assert.Len(t, entries, 1)
+			assert.Contains(t, entries[0], `level=INFO msg="cidr inserted" cidr=10.1.0.0/16 conflict_type=sub_cidr conflicted_with=[10.0.0.0/8]`)
+		}
+	}
+
+	_, err := runCli(t, "--log", "--log-format", "jsonl", "resolve", filepath.Join(dir, "in.csv"), "-o", output)
+	assert.EqualError(t, err, `--log-format must be one of "text","json","results" but got "jsonl"`)
+}
+
+func TestLogLevel(t *testing.T) {
+	dir := writeFiles(t, logFiles)
+	output := filepath.Join(dir, "out.csv")
+	// the insertion without conflict is logged at debug, and the split at info
+	expected := map[string][]string{
+		"":      {"level=DEBUG msg=\"cidr inserted\" cidr=10.0.0.0/8 conflict_type=no_conflict", "level=INFO msg=\"cidr inserted\" cidr=10.1.0.0/16 conflict_type=sub_cidr"},
+		"debug": {"level=DEBUG msg=\"cidr inserted\" cidr=10.0.0.0/8 conflict_type=no_conflict", "level=INFO msg=\"cidr inserted\" cidr=10.1.0.0/16 conflict_type=sub_cidr"},
+		"info":  {"level=INFO msg=\"cidr inserted\" cidr=10.1.0.0/16 conflict_type=sub_cidr"},
+		"warn":  {},
+		"error": {},
+	}
+	for level, entries := range expected {
+		args := []string{"--log"}
+		if level != "" {
+			// without --log-level, every insertion is logged
+			args = append(args, "--log-level", level)
+		}
+		args = append(args, "resolve", filepath.Join(dir, "in.csv"), "--priority-keys", "p", "-o", output)
+		_, stderr, err := runCliStderr(t, args...)
+		assert.NoError(t, err, level)
+		logged := logLines(stderr)
+		assert.Len(t, logged, len(entries), level)
+		for i := range min(len(logged), len(entries)) {
+			assert.Contains(t, logged[i], entries[i], level)
+		}
+	}
+
+	_, err := runCli(t, "--log", "--log-level", "trace", "resolve", filepath.Join(dir, "in.csv"), "-o", output)
+	assert.EqualError(t, err, `--log-level must be one of "debug","info","warn","error" but got "trace"`)
+}
+
+func TestLogToStderr(t *testing.T) {
+	dir := writeFiles(t, logFiles)
+	in := filepath.Join(dir, "in.csv")
+	// the commands writing their data to stdout
+	for _, args := range [][]string{
+		{"resolve", in, "--priority-keys", "p", "-o", "-", "--output-format", "txt"},
+		{"resolve", in, "--priority-keys", "p", "-o", filepath.Join(dir, "out.csv"), "--report", "-"},
+		{"convert", in, "-", "--output-format", "json"},
+		{"lookup", "-f", in, "--priority-keys", "p", "10.1.2.3"},
+	} {
+		printed, logged, err := runCliStderr(t, append([]string{"--log", "--log-format", "results"}, args...)...)
+		assert.NoError(t, err, args)
+		assert.Empty(t, logLines(printed), args)
+		assert.Len(t, logLines(logged), 2, args)
+		assert.Contains(t, printed, "10.1.", args)
+	}
+}
+
+// returns the lines of the log in the output of a command
+func logLines(output string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "{") || strings.HasPrefix(line, "time=") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
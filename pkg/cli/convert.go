@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+type ConvertCmd struct {
+	Input        string `arg:"" type:"existingfile" help:"Input file in CSV, TSV, JSON or text format, or a file written by resolve --output-format db"`
+	Output       string `arg:"" help:"Output file, - for stdout, a name ending in .gz is compressed"`
+	ParseFlags   `embed:""`
+	OutputFormat string            `enum:"auto,json,csv,tsv,txt,db" default:"auto" help:"Output file format, auto uses the extension of the output, e.g. json for out.json.gz"`
+	Resolve      bool              `negatable:"" default:"true" help:"Resolve the CIDR conflicts before writing, with --no-resolve the records are written one by one as they are read, without building a supernet"`
+	DropKeys     []string          `help:"Keys/Columns to be dropped" default:""`
+	Rename       map[string]string `help:"Keys/Columns to be renamed, e.g. --rename name=country;p=priority"`
+}
+
+// Run writes the records, or the resolved CIDRs, of the input file to the output file, the CIDRs are written in their canonical form.
+func (cmd *ConvertCmd) Run(ctx *Context) error {
+	format := outputFormat(cmd.OutputFormat, cmd.Output)
+	var encoder RecordEncoder
+	if format != "db" {
+		var err error
+		if encoder, err = newEncoder(format, cmd.CidrKey); err != nil {
+			return err
+		}
+	}
+	in, err := openInput(cmd.Input, cmd.InputFormat)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// a db file has resolved CIDRs, so it is written the same with --no-resolve
+	if !cmd.Resolve && in.format != "db" {
+		if encoder == nil {
+			return errors.New("the db output format needs the CIDRs resolved, it can not be written with --no-resolve")
+		}
+		return cmd.stream(ctx, in, encoder)
+	}
+
+	super, err := resolveInput(ctx.super, in, &cmd.ParseFlags)
+	if err != nil {
+		return err
+	}
+	stats := &Stats{}
+	var writer Writer = &DbWriter{DropKeys: cmd.DropKeys, Renames: cmd.Rename, Stats: stats}
+	if encoder != nil {
+		writer = &StreamWriter{
+			NewEncoder: func() RecordEncoder { return encoder },
+			CidrCol:    cmd.CidrKey,
+			DropKeys:   cmd.DropKeys,
+			Renames:    cmd.Rename,
+			Stats:      stats,
+		}
+	}
+	if err := writer.Write(ctx, super, cmd.Output); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.messages(), "%d resolved CIDRs written to %s\n", stats.Output, cmd.Output)
+	return nil
+}
+
+// writes each record of the input file as it is read, so the memory used does not depend on the number of records
+func (cmd *ConvertCmd) stream(ctx *Context, in *input, encoder RecordEncoder) (err error) {
+	parser, err := newParser(in.format, cmd.CidrKey)
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput(cmd.Output)
+	if err != nil {
+		return err
+	}
+	written := 0
+	defer func() {
+		if err = out.close(err, encoder.End); err == nil {
+			fmt.Fprintf(cmd.messages(), "%d records written to %s\n", written, cmd.Output)
+		}
+	}()
+	if err = encoder.Begin(out); err != nil {
+		return err
+	}
+
+	output := map[string]string{}
+	err = parser.ReadRecords(in, in.name(), func(record Record, line int) error {
+		prefix, err := parsePrefix(record[cmd.CidrKey])
+		if err != nil {
+			return &recordError{file: in.name(), line: line, err: fmt.Errorf("Can not parse CIDR on Key: %s CIDR: %s", cmd.CidrKey, record[cmd.CidrKey])}
+		}
+		fillRecord(output, record, cmd.CidrKey, prefix.Masked().String(), cmd.DropKeys, cmd.Rename)
+		if err := encoder.Encode(output); err != nil {
+			return err
+		}
+		written++
+		if written%cancelCheckInterval == 0 {
+			return ctx.Err()
+		}
+		return nil
+	})
+	return err
+}
+
+// returns where the messages are printed, stderr when the output is stdout
+func (cmd *ConvertCmd) messages() io.Writer {
+	if cmd.Output == "-" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
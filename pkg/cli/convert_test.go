@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var convertFiles = map[string]string{
+	"feed.csv": "cidr,name,p\n" +
+		"10.0.0.0/8,a,1\n" +
+		"10.1.0.1/16,b,2\n" +
+		"2001:db8::/32,c,1\n",
+	"feed.tsv": "cidr\tname\tp\n" +
+		"10.0.0.0/8\ta\t1\n" +
+		"10.1.0.1/16\tb\t2\n",
+	"feed.json": "[{\"cidr\": \"10.0.0.0/8\", \"name\": \"a\", \"p\": \"1\"}, {\"cidr\": \"10.1.0.1/16\", \"name\": \"b\", \"p\": \"2\"}]\n",
+}
+
+func TestConvert(t *testing.T) {
+	dir := writeFiles(t, convertFiles)
+	for _, test := range []struct {
+		golden string
+		args   []string
+	}{
+		{"convert_resolved.json", []string{"feed.csv", "out.json", "--priority-keys", "p"}},
+		{"convert_records.csv", []string{"feed.tsv", "out.csv", "--no-resolve", "--drop-keys", "p", "--rename", "name=country"}},
+		{"convert_records.tsv", []string{"feed.json", "out.tsv", "--no-resolve"}},
+		{"convert_resolved.txt", []string{"feed.json", "out.txt", "--priority-keys", "p"}},
+	} {
+		input, output := filepath.Join(dir, test.args[0]), filepath.Join(dir, test.args[1])
+		printed, err := runCli(t, append([]string{"convert", input, output}, test.args[2:]...)...)
+		assert.NoError(t, err, test.golden)
+		assert.Contains(t, printed, "written to "+output)
+		assertGolden(t, test.golden, readFile(t, output))
+	}
+}
+
+func TestConvertDbRoundTrip(t *testing.T) {
+	dir := writeFiles(t, convertFiles)
+	db := filepath.Join(dir, "out.db")
+	_, err := runCli(t, "convert", filepath.Join(dir, "feed.csv"), db, "--priority-keys", "p", "--rename", "name=country")
+	assert.NoError(t, err)
+
+	// the resolved CIDRs are written to stdout
+	output, err := runCli(t, "convert", db, "-", "--output-format", "csv")
+	assert.NoError(t, err)
+	assertGolden(t, "convert_db.csv", output)
+
+	_, err = runCli(t, "convert", db, filepath.Join(dir, "out.csv"), "--no-resolve")
+	assert.NoError(t, err, "a db file is always resolved")
+	_, err = runCli(t, "convert", filepath.Join(dir, "feed.csv"), filepath.Join(dir, "other.db"), "--no-resolve")
+	assert.EqualError(t, err, "the db output format needs the CIDRs resolved, it can not be written with --no-resolve")
+
+	// a db file is found from its header, whatever its name
+	renamed := filepath.Join(dir, "resolved.bin")
+	assert.NoError(t, os.Rename(db, renamed))
+	for _, args := range [][]string{{renamed, "-"}, {renamed, "-", "--no-resolve"}, {"-", "-"}} {
+		withStdin(t, readFile(t, renamed))
+		output, err := runCli(t, append([]string{"convert"}, append(args, "--output-format", "csv")...)...)
+		assert.NoError(t, err, args)
+		assertGolden(t, "convert_db.csv", output)
+	}
+}
+
+func TestConvertInvalidCidr(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"feed.csv": "cidr,name\n10.0.0.0/8,a\nbad,b\n"})
+	_, err := runCli(t, "convert", filepath.Join(dir, "feed.csv"), filepath.Join(dir, "out.json"), "--no-resolve")
+	assert.ErrorContains(t, err, "feed.csv:3:")
+}
@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+)
+
+type DiffCmd struct {
+	Old          string `arg:"" type:"existingfile" help:"Old input file in CSV, TSV or JSON format, or a file written by resolve --output-format db"`
+	New          string `arg:"" type:"existingfile" help:"New input file in CSV, TSV or JSON format, or a file written by resolve --output-format db"`
+	ParseFlags   `embed:""`
+	Format       string `enum:"text,csv,json" default:"text" help:"Output format"`
+	FailOnChange bool   `help:"Exit with an error if the inputs differ"`
+}
+
+// the number of changes and of affected addresses of a change kind
+type diffTotal struct {
+	Changes   int      `json:"changes"`
+	Addresses *big.Int `json:"addresses"`
+}
+
+// Run resolves both inputs, and prints the CIDRs added, removed and reassigned between them.
+func (cmd *DiffCmd) Run(ctx *Context) error {
+	oldSuper, err := cmd.load(cmd.Old)
+	if err != nil {
+		return err
+	}
+	newSuper, err := cmd.load(cmd.New)
+	if err != nil {
+		return err
+	}
+
+	var output diffOutput = &textDiffOutput{w: os.Stdout}
+	switch cmd.Format {
+	case "csv":
+		output = &csvDiffOutput{writer: csv.NewWriter(os.Stdout)}
+	case "json":
+		output = &jsonDiffOutput{w: os.Stdout}
+	}
+
+	totals := map[supernet.ChangeKind]*diffTotal{}
+	for _, kind := range []supernet.ChangeKind{supernet.Added, supernet.Removed, supernet.Reassigned} {
+		totals[kind] = &diffTotal{Addresses: new(big.Int)}
+	}
+	// the CIDR column holds the CIDR of the input record, which differs whenever a CIDR is split
+	supernet.Diff(oldSuper, newSuper, []string{cmd.CidrKey}, func(change *supernet.Change) bool {
+		totals[change.Kind].Changes++
+		totals[change.Kind].Addresses.Add(totals[change.Kind].Addresses, change.Addresses())
+		err = output.add(change, cmd.CidrKey)
+		return err == nil && ctx.Err() == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := output.flush(totals); err != nil {
+		return err
+	}
+
+	changes := totals[supernet.Added].Changes + totals[supernet.Removed].Changes + totals[supernet.Reassigned].Changes
+	if cmd.FailOnChange && changes > 0 {
+		return fmt.Errorf("%d CIDRs changed", changes)
+	}
+	return nil
+}
+
+// returns the resolved CIDRs of a db file, or of an input file
+func (cmd *DiffCmd) load(file string) (*supernet.Supernet, error) {
+	in, err := openInput(file, cmd.InputFormat)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return resolveInput(supernet.NewSupernet(), in, &cmd.ParseFlags)
+}
+
+// prints the changes in an output format, followed by the totals of each change kind
+type diffOutput interface {
+	add(change *supernet.Change, cidrKey string) error
+	flush(totals map[supernet.ChangeKind]*diffTotal) error
+}
+
+// returns the attributes without the CIDR column, or nil without metadata
+func diffAttributes(metadata *supernet.Metadata, cidrKey string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	attributes := make(map[string]string, len(metadata.Attributes))
+	for key, value := range metadata.Attributes {
+		if key != cidrKey {
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
+
+var diffSymbols = map[supernet.ChangeKind]string{supernet.Added: "+", supernet.Removed: "-", supernet.Reassigned: "~"}
+
+// prints a line per change, e.g. "~ 10.0.0.0/8 addresses=16777216 changed=name"
+type textDiffOutput struct {
+	w io.Writer
+}
+
+func (o *textDiffOutput) add(change *supernet.Change, cidrKey string) error {
+	line := fmt.Sprintf("%s %s addresses=%s", diffSymbols[change.Kind], change.CIDR, change.Addresses())
+	if len(change.ChangedKeys) > 0 {
+		line += " changed=" + strings.Join(change.ChangedKeys, ",")
+	}
+	_, err := fmt.Fprintln(o.w, line)
+	return err
+}
+
+func (o *textDiffOutput) flush(totals map[supernet.ChangeKind]*diffTotal) error {
+	for _, kind := range []supernet.ChangeKind{supernet.Added, supernet.Removed, supernet.Reassigned} {
+		if _, err := fmt.Fprintf(o.w, "%s: %d CIDRs, %s addresses\n", kind, totals[kind].Changes, totals[kind].Addresses); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var csvDiffHeader = []string{"change", "cidr", "addresses", "changed_keys", "old_attributes", "new_attributes"}
+
+// prints a row per change, the attributes are JSON objects since both sides may have different keys
+type csvDiffOutput struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+func (o *csvDiffOutput) add(change *supernet.Change, cidrKey string) error {
+	if !o.wroteHeader {
+		o.writer.Write(csvDiffHeader)
+		o.wroteHeader = true
+	}
+	row := []string{change.Kind.String(), change.CIDR.String(), change.Addresses().String(), strings.Join(change.ChangedKeys, ",")}
+	for _, metadata := range []*supernet.Metadata{change.Old, change.New} {
+		attributes := ""
+		if metadata != nil {
+			encoded, err := json.Marshal(diffAttributes(metadata, cidrKey))
+			if err != nil {
+				return err
+			}
+			attributes = string(encoded)
+		}
+		row = append(row, attributes)
+	}
+	return o.writer.Write(row)
+}
+
+func (o *csvDiffOutput) flush(totals map[supernet.ChangeKind]*diffTotal) error {
+	if !o.wroteHeader {
+		o.writer.Write(csvDiffHeader)
+	}
+	o.writer.Flush()
+	return o.writer.Error()
+}
+
+// prints a single document with the changes and the totals
+type jsonDiffOutput struct {
+	w       io.Writer
+	changes []jsonChange
+}
+
+type jsonChange struct {
+	Change      string            `json:"change"`
+	CIDR        string            `json:"cidr"`
+	Addresses   *big.Int          `json:"addresses"`
+	ChangedKeys []string          `json:"changed_keys,omitempty"`
+	Old         map[string]string `json:"old,omitempty"`
+	New         map[string]string `json:"new,omitempty"`
+}
+
+func (o *jsonDiffOutput) add(change *supernet.Change, cidrKey string) error {
+	o.changes = append(o.changes, jsonChange{
+		Change:      change.Kind.String(),
+		CIDR:        change.CIDR.String(),
+		Addresses:   change.Addresses(),
+		ChangedKeys: change.ChangedKeys,
+		Old:         diffAttributes(change.Old, cidrKey),
+		New:         diffAttributes(change.New, cidrKey),
+	})
+	return nil
+}
+
+func (o *jsonDiffOutput) flush(totals map[supernet.ChangeKind]*diffTotal) error {
+	summary := map[string]*diffTotal{}
+	for kind, total := range totals {
+		summary[kind.String()] = total
+	}
+	if o.changes == nil {
+		o.changes = []jsonChange{}
+	}
+	encoder := json.NewEncoder(o.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]any{"changes": o.changes, "summary": summary})
+}
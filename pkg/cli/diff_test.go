@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var diffFiles = map[string]string{
+	"old.csv": "cidr,name,p\n" +
+		"10.0.0.0/8,a,1\n" +
+		"10.1.0.0/16,b,2\n" +
+		"192.168.0.0/24,c,1\n",
+	// 10.1.0.0/16 is reassigned, 192.168.0.0/24 is removed, and 172.16.0.0/12 is added
+	"new.csv": "cidr,name,p\n" +
+		"10.0.0.0/8,a,1\n" +
+		"10.1.0.0/16,d,2\n" +
+		"172.16.0.0/12,e,1\n",
+}
+
+// the golden file of each output format
+var diffGolden = map[string]string{"text": "diff.txt", "csv": "diff.csv", "json": "diff.json"}
+
+func TestDiffFormats(t *testing.T) {
+	dir := writeFiles(t, diffFiles)
+	for format, golden := range diffGolden {
+		output, err := runCli(t, "diff", filepath.Join(dir, "old.csv"), filepath.Join(dir, "new.csv"), "--priority-keys", "p", "--format", format)
+		assert.NoError(t, err, format)
+		assertGolden(t, golden, output)
+	}
+}
+
+func TestDiffNoChange(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"old.csv": "cidr,name\n10.0.0.0/8,a\n",
+		// the same address space, split differently
+		"new.csv": "cidr,name\n10.0.0.0/9,a\n10.128.0.0/9,a\n",
+	})
+	output, err := runCli(t, "diff", filepath.Join(dir, "old.csv"), filepath.Join(dir, "new.csv"), "--fail-on-change")
+	assert.NoError(t, err)
+	assert.Equal(t, "added: 0 CIDRs, 0 addresses\nremoved: 0 CIDRs, 0 addresses\nreassigned: 0 CIDRs, 0 addresses\n", output)
+
+	output, err = runCli(t, "diff", filepath.Join(dir, "old.csv"), filepath.Join(dir, "new.csv"), "--format", "csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "change,cidr,addresses,changed_keys,old_attributes,new_attributes\n", output)
+}
+
+func TestDiffFailOnChange(t *testing.T) {
+	dir := writeFiles(t, diffFiles)
+	output, err := runCli(t, "diff", filepath.Join(dir, "old.csv"), filepath.Join(dir, "new.csv"), "--priority-keys", "p", "--fail-on-change")
+	assert.EqualError(t, err, "3 CIDRs changed")
+	// the changes are printed before failing
+	assertGolden(t, "diff.txt", output)
+}
+
+func TestDiffDb(t *testing.T) {
+	dir := writeFiles(t, diffFiles)
+	// the db files are found from their header, whatever their name
+	for _, name := range []string{"old.db", "old.resolved", "old"} {
+		_, err := runCli(t, "convert", filepath.Join(dir, "old.csv"), filepath.Join(dir, name), "--output-format", "db", "--priority-keys", "p")
+		assert.NoError(t, err, name)
+		output, err := runCli(t, "diff", filepath.Join(dir, name), filepath.Join(dir, "new.csv"), "--priority-keys", "p")
+		assert.NoError(t, err, name)
+		assertGolden(t, "diff.txt", output)
+	}
+
+	withStdin(t, readFile(t, filepath.Join(dir, "old.db")))
+	output, err := runCli(t, "diff", "-", filepath.Join(dir, "new.csv"), "--priority-keys", "p")
+	assert.NoError(t, err)
+	assertGolden(t, "diff.txt", output)
+}
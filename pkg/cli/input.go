@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+)
+
+// number of bytes read ahead to find the compression and the format of an input
+const sniffSize = 4096
+
+// input is an opened input file, or stdin for the path -, decompressed if it is compressed with gzip or bzip2.
+type input struct {
+	io.Reader
+	path       string
+	format     string // json, csv, tsv, txt, or db for a file written by Save
+	compressed string // the extension of the compression, .gz or .bz2, or empty
+	file       *os.File
+	gzip       *gzip.Reader
+}
+
+// opens the input at path, its format is formatFlag if it is not auto, the extension of the path without the compression,
+// e.g. csv for feed.csv.gz, or the format sniffed from its content if the path has no extension.
+// A db file is found from its header, whatever its name and formatFlag.
+func openInput(path string, formatFlag string) (*input, error) {
+	in := &input{path: path, file: os.Stdin}
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		in.file = file
+	}
+
+	// the compression is found from the content, so a compressed file without the extension is read too
+	buffer := bufio.NewReaderSize(in.file, sniffSize)
+	head, _ := buffer.Peek(3)
+	in.Reader = buffer
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gzipReader, err := gzip.NewReader(buffer)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in.gzip, in.compressed = gzipReader, ".gz"
+		in.Reader = bufio.NewReaderSize(gzipReader, sniffSize)
+	case bytes.HasPrefix(head, []byte("BZh")):
+		in.compressed = ".bz2"
+		in.Reader = bufio.NewReaderSize(bzip2.NewReader(buffer), sniffSize)
+	}
+
+	in.format = formatFlag
+	if head, _ := in.Reader.(*bufio.Reader).Peek(4); supernet.HasFileMagic(head) {
+		in.format = "db"
+	}
+	if in.format == "auto" {
+		in.format = strings.TrimPrefix(filepath.Ext(in.base()), ".")
+	}
+	if in.format == "" {
+		in.format = sniffFormat(in.Reader.(*bufio.Reader))
+	}
+	return in, nil
+}
+
+// returns the path without the extension of the compression
+func (in *input) base() string {
+	if path := strings.TrimSuffix(in.path, ".gz"); path != in.path {
+		return path
+	}
+	return strings.TrimSuffix(in.path, ".bz2")
+}
+
+// returns the name of the input in the errors
+func (in *input) name() string {
+	if in.path == "-" {
+		return "stdin"
+	}
+	return in.path
+}
+
+func (in *input) Close() error {
+	if in.gzip != nil {
+		in.gzip.Close()
+	}
+	if in.file == os.Stdin {
+		return nil
+	}
+	return in.file.Close()
+}
+
+// returns json if the content starts with an array, otherwise the format of the separator in the first line,
+// tsv for a tab, csv for a comma, or txt for none
+func sniffFormat(r *bufio.Reader) string {
+	head, _ := r.Peek(sniffSize)
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if bytes.HasPrefix(head, []byte("[")) {
+		return "json"
+	}
+	firstLine, _, _ := bytes.Cut(head, []byte("\n"))
+	switch {
+	case bytes.Contains(firstLine, []byte("\t")):
+		return "tsv"
+	case bytes.Contains(firstLine, []byte(",")):
+		return "csv"
+	default:
+		return "txt"
+	}
+}
+
+// opens the input at path, and returns its parser, the input must be closed
+func openParser(path string, flags *ParseFlags) (*input, CidrParser, error) {
+	in, err := openInput(path, flags.InputFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+	if in.format == "db" {
+		in.Close()
+		return nil, nil, fmt.Errorf("%s is a db file, it can only be read by convert, diff and lookup", in.name())
+	}
+	parser, err := newParser(in.format, flags.CidrKey)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, parser, nil
+}
+
+// returns the supernet loaded from the input if it is a db file, which has resolved CIDRs,
+// otherwise inserts the records of the input into super, and returns super
+func resolveInput(super *supernet.Supernet, in *input, flags *ParseFlags) (*supernet.Supernet, error) {
+	if in.format == "db" {
+		loaded, err := supernet.Load(in)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", in.name(), err)
+		}
+		return loaded, nil
+	}
+	parser, err := newParser(in.format, flags.CidrKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertRecords(super, &ResolveCmd{ParseFlags: *flags, Workers: 1}, in, parser); err != nil {
+		return nil, err
+	}
+	return super, nil
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const inputRecords = "cidr,name\n10.0.0.0/8,a\n10.1.0.0/16,b\n"
+
+func TestSniffFormat(t *testing.T) {
+	for content, expected := range map[string]string{
+		"[{\"cidr\": \"10.0.0.0/8\"}]":       "json",
+		"\xef\xbb\xbf\n  [\n]":               "json",
+		"cidr\tname\n10.0.0.0/8\ta\n":        "tsv",
+		"cidr,name\n10.0.0.0/8,a\n":          "csv",
+		"cidr\n10.0.0.0/8,a\n":               "txt",
+		"# CIDRs\n10.0.0.0/8\n10.1.0.0/16\n": "txt",
+		"":                                   "txt",
+	} {
+		assert.Equal(t, expected, sniffFormat(bufio.NewReader(strings.NewReader(content))), content)
+	}
+}
+
+func TestOpenInput(t *testing.T) {
+	compressed := bytes.Buffer{}
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(inputRecords))
+	writer.Close()
+	bzip2ed, err := os.ReadFile(filepath.Join("testdata", "feed.csv.bz2"))
+	assert.NoError(t, err)
+	dir := writeFiles(t, map[string]string{
+		"feed.csv":     inputRecords,
+		"feed.csv.gz":  compressed.String(),
+		"feed.csv.bz2": string(bzip2ed),
+		"feed":         compressed.String(), // compressed without extension, its format is sniffed
+		"feed.json.gz": inputRecords,        // not compressed, and not in the format of its extension
+		"broken.gz":    "\x1f\x8b not gzip",
+	})
+
+	for _, test := range []struct {
+		name, formatFlag, format, compressed string
+	}{
+		{"feed.csv", "auto", "csv", ""},
+		{"feed.csv.gz", "auto", "csv", ".gz"},
+		{"feed.csv.bz2", "auto", "csv", ".bz2"},
+		{"feed", "auto", "csv", ".gz"},
+		{"feed.json.gz", "auto", "json", ""},
+		{"feed.json.gz", "csv", "csv", ""},
+	} {
+		in, err := openInput(filepath.Join(dir, test.name), test.formatFlag)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.format, in.format, test.name)
+		assert.Equal(t, test.compressed, in.compressed, test.name)
+		content, err := io.ReadAll(in)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, inputRecords, string(content), test.name)
+		assert.NoError(t, in.Close())
+	}
+
+	_, err = openInput(filepath.Join(dir, "missing.csv"), "auto")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = openInput(filepath.Join(dir, "broken.gz"), "auto")
+	assert.Error(t, err)
+
+	// a db file is found from its header, whatever its extension and the format flag
+	dir = writeFiles(t, map[string]string{"saved.csv": "SPNT\x01\x00"})
+	in, err := openInput(filepath.Join(dir, "saved.csv"), "csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "db", in.format)
+	assert.NoError(t, in.Close())
+	_, err = runCli(t, "resolve", filepath.Join(dir, "saved.csv"), "-o", "-")
+	assert.EqualError(t, err, filepath.Join(dir, "saved.csv")+" is a db file, it can only be read by convert, diff and lookup")
+}
+
+func TestStdinInput(t *testing.T) {
+	compressed := bytes.Buffer{}
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte("[{\"cidr\": \"10.0.0.0/8\", \"name\": \"a\"}, {\"cidr\": \"10.1.0.0/16\", \"name\": \"b\"}]"))
+	writer.Close()
+
+	for name, content := range map[string]string{"csv": inputRecords, "gzip json": compressed.String(), "text": "10.0.0.0/8\n10.1.0.0/16\n"} {
+		withStdin(t, content)
+		output, err := runCli(t, "aggregate", "-", "--keys", "name")
+		assert.NoError(t, err, name)
+		if name == "text" {
+			assert.Equal(t, "# name=\n10.0.0.0/8\n", output, name)
+		} else {
+			assert.Equal(t, "# name=a\n10.0.0.0/8\n# name=b\n10.1.0.0/16\n", output, name)
+		}
+	}
+
+	// the errors are reported in stdin
+	withStdin(t, "cidr,name\nbad,a\n")
+	_, err := runCli(t, "resolve", "-", "-o", "-")
+	assert.ErrorContains(t, err, "stdin:2:")
+
+	withStdin(t, inputRecords)
+	_, err = runCli(t, "validate", "-", "--fix")
+	assert.ErrorContains(t, err, "--fix can not be used with stdin")
+}
+
+// replaces stdin with a file holding content until the end of the test
+func withStdin(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	stdin := os.Stdin
+	os.Stdin = file
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		file.Close()
+	})
+}
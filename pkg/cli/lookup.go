@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+)
+
+type LookupCmd struct {
+	Queries    []string `arg:"" optional:"" help:"IPs or CIDRs to look up, read line by line from stdin if none is given"`
+	File       []string `short:"f" type:"existingfile" help:"Input files containing CIDRs in CSV, TSV or JSON format, resolved before the lookups, or a single file written by resolve --output-format db"`
+	Db         string   `type:"existingfile" help:"File written by resolve --output-format db, used instead of input files"`
+	ParseFlags `embed:""`
+	Format     string `enum:"table,csv,json" default:"table" help:"Output format, json prints one object per line as soon as each query is answered"`
+}
+
+// the dataset answering the lookups, a Supernet built from the input files, or a Reader of a db file
+type lookupDataset interface {
+	supernet.Lookuper
+	supernet.PrefixLookuper
+}
+
+// a resolved CIDR matching a query, the CIDR is invalid if nothing matched
+type lookupMatch struct {
+	query      string
+	cidr       netip.Prefix
+	attributes map[string]string
+}
+
+// Run looks up the queries in the dataset, and prints the matching resolved CIDRs.
+func (cmd *LookupCmd) Run(ctx *Context) error {
+	dataset, err := cmd.openDataset(ctx.super)
+	if err != nil {
+		return err
+	}
+	if reader, isReader := dataset.(*supernet.Reader); isReader {
+		defer reader.Close()
+	}
+
+	var output lookupOutput = &tableOutput{}
+	switch cmd.Format {
+	case "csv":
+		output = &csvOutput{}
+	case "json":
+		output = &jsonOutput{encoder: json.NewEncoder(os.Stdout)}
+	}
+
+	invalid := 0
+	err = cmd.forEachQuery(os.Stdin, func(query string) error {
+		matches, err := cmd.lookup(dataset, query)
+		if err != nil {
+			invalid++
+			fmt.Fprintln(os.Stderr, err)
+			return nil
+		}
+		for _, match := range matches {
+			if err := output.add(match); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return err
+	}
+	if err := output.flush(os.Stdout); err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d queries are not valid IPs or CIDRs", invalid)
+	}
+	return nil
+}
+
+// returns a Reader of the db file, or a supernet with the resolved CIDRs of the input files
+func (cmd *LookupCmd) openDataset(super *supernet.Supernet) (lookupDataset, error) {
+	switch {
+	case cmd.Db != "" && len(cmd.File) > 0:
+		return nil, errors.New("--db and --file can not be used together")
+	case cmd.Db != "":
+		return supernet.OpenReader(cmd.Db)
+	case len(cmd.Queries) == 0 && contains(cmd.File, "-"):
+		return nil, errors.New("the input file can not be read from stdin when the queries are read from stdin, give the queries as arguments")
+	case len(cmd.File) > 0:
+		return cmd.resolveFiles(super)
+	default:
+		return nil, errors.New("one of --db or --file is required")
+	}
+}
+
+// inserts the records of the input files into super, and returns it, a single db file can be given instead and is loaded
+func (cmd *LookupCmd) resolveFiles(super *supernet.Supernet) (*supernet.Supernet, error) {
+	for _, file := range cmd.File {
+		in, err := openInput(file, cmd.InputFormat)
+		if err != nil {
+			return nil, err
+		}
+		if in.format == "db" && len(cmd.File) > 1 {
+			in.Close()
+			return nil, fmt.Errorf("%s is a db file, it can not be looked up with other files", in.name())
+		}
+		super, err = resolveInput(super, in, &cmd.ParseFlags)
+		in.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return super, nil
+}
+
+// calls f with each query argument, or with each line of stdin if there is none
+func (cmd *LookupCmd) forEachQuery(stdin io.Reader, f func(query string) error) error {
+	if len(cmd.Queries) > 0 {
+		for _, query := range cmd.Queries {
+			if err := f(query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		if query := strings.TrimSpace(scanner.Text()); query != "" {
+			if err := f(query); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// returns the resolved CIDR containing the IP or the CIDR of the query, otherwise the resolved CIDRs within the CIDR,
+// or a single match without CIDR if there is none
+func (cmd *LookupCmd) lookup(dataset lookupDataset, query string) ([]lookupMatch, error) {
+	noMatch := []lookupMatch{{query: query}}
+	if !strings.Contains(query, "/") {
+		addr, err := parseAddr(query)
+		if err != nil {
+			return nil, err
+		}
+		cidr, metadata, found := dataset.LookupAddr(addr)
+		if !found {
+			return noMatch, nil
+		}
+		return []lookupMatch{{query: query, cidr: cidr, attributes: cmd.attributes(metadata)}}, nil
+	}
+
+	prefix, err := parsePrefix(query)
+	if err != nil {
+		return nil, err
+	}
+	matches := []lookupMatch{}
+	dataset.LookupPrefix(prefix, func(cidr netip.Prefix, metadata *supernet.Metadata) bool {
+		matches = append(matches, lookupMatch{query: query, cidr: cidr, attributes: cmd.attributes(metadata)})
+		return true
+	})
+	if len(matches) == 0 {
+		return noMatch, nil
+	}
+	return matches, nil
+}
+
+// returns the attributes without the CIDR column, which holds the CIDR of the input record, not the resolved one
+func (cmd *LookupCmd) attributes(metadata *supernet.Metadata) map[string]string {
+	attributes := make(map[string]string, len(metadata.Attributes))
+	for key, value := range metadata.Attributes {
+		if key != cmd.CidrKey {
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
+
+// prints the matches in an output format
+type lookupOutput interface {
+	add(match lookupMatch) error
+	flush(w io.Writer) error
+}
+
+// the table and CSV outputs have a column for each attribute key, so the matches are printed once all are known
+type bufferedOutput struct {
+	matches []lookupMatch
+	keys    []string
+}
+
+func (o *bufferedOutput) add(match lookupMatch) error {
+	o.matches = append(o.matches, match)
+	for key := range match.attributes {
+		if !contains(o.keys, key) {
+			o.keys = append(o.keys, key)
+		}
+	}
+	return nil
+}
+
+// returns the rows of the matches, a match without CIDR has the noMatch value in the CIDR column
+func (o *bufferedOutput) rows(noMatch string) [][]string {
+	sort.Strings(o.keys)
+	rows := make([][]string, 0, len(o.matches))
+	for _, match := range o.matches {
+		row := []string{match.query, noMatch}
+		if match.cidr.IsValid() {
+			row[1] = match.cidr.String()
+		}
+		for _, key := range o.keys {
+			row = append(row, match.attributes[key])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+type tableOutput struct{ bufferedOutput }
+
+func (o *tableOutput) flush(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	rows := o.rows("no match")
+	headers := append([]string{"QUERY", "CIDR"}, o.keys...)
+	fmt.Fprintln(table, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	return table.Flush()
+}
+
+type csvOutput struct{ bufferedOutput }
+
+func (o *csvOutput) flush(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	rows := o.rows("")
+	writer.Write(append([]string{"query", "cidr", "matched"}, o.keys...))
+	for i, row := range rows {
+		matched := strconv.FormatBool(o.matches[i].cidr.IsValid())
+		writer.Write(append(row[:2:2], append([]string{matched}, row[2:]...)...))
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// prints each match as soon as it is added, so a stream of queries from stdin is answered line by line
+type jsonOutput struct {
+	encoder *json.Encoder
+}
+
+type jsonMatch struct {
+	Query      string            `json:"query"`
+	Matched    bool              `json:"matched"`
+	CIDR       string            `json:"cidr,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func (o *jsonOutput) add(match lookupMatch) error {
+	result := jsonMatch{Query: match.query, Matched: match.cidr.IsValid(), Attributes: match.attributes}
+	if result.Matched {
+		result.CIDR = match.cidr.String()
+	}
+	return o.encoder.Encode(result)
+}
+
+func (o *jsonOutput) flush(w io.Writer) error {
+	return nil
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var lookupFiles = map[string]string{
+	"feed.csv": "cidr,name,p\n" +
+		"10.0.0.0/8,a,1\n" +
+		"10.1.0.0/16,b,2\n" +
+		"2001:db8::/32,c,1\n",
+}
+
+// the queries of the lookup tests, an IP, a CIDR with several resolved CIDRs, and a query without match
+var lookupQueries = []string{"10.1.2.3", "10.0.0.0/8", "192.168.0.1"}
+
+// the golden file of each output format
+var lookupGolden = map[string]string{"table": "lookup_table.txt", "csv": "lookup.csv", "json": "lookup.json"}
+
+func TestLookupFormats(t *testing.T) {
+	dir := writeFiles(t, lookupFiles)
+	feed := filepath.Join(dir, "feed.csv")
+	for format, golden := range lookupGolden {
+		output, err := runCli(t, append([]string{"lookup", "-f", feed, "--priority-keys", "p", "--format", format}, lookupQueries...)...)
+		assert.NoError(t, err, format)
+		assertGolden(t, golden, output)
+	}
+}
+
+func TestLookupDb(t *testing.T) {
+	dir := writeFiles(t, lookupFiles)
+	feed, db := filepath.Join(dir, "feed.csv"), filepath.Join(dir, "feed.db")
+	_, err := runCli(t, "convert", feed, db, "--priority-keys", "p")
+	assert.NoError(t, err)
+
+	// the db file answers like the input file it was written from
+	for format, golden := range lookupGolden {
+		output, err := runCli(t, append([]string{"lookup", "--db", db, "--format", format}, lookupQueries...)...)
+		assert.NoError(t, err, format)
+		assertGolden(t, golden, output)
+	}
+
+	// a db file can also be given as the only input file
+	output, err := runCli(t, append([]string{"lookup", "-f", db, "--format", "csv"}, lookupQueries...)...)
+	assert.NoError(t, err)
+	assertGolden(t, "lookup.csv", output)
+	_, err = runCli(t, "lookup", "-f", db, "-f", feed, "10.1.2.3")
+	assert.EqualError(t, err, db+" is a db file, it can not be looked up with other files")
+
+	_, err = runCli(t, "lookup", "--db", db, "-f", feed, "10.1.2.3")
+	assert.EqualError(t, err, "--db and --file can not be used together")
+	_, err = runCli(t, "lookup", "10.1.2.3")
+	assert.EqualError(t, err, "one of --db or --file is required")
+}
+
+func TestLookupStdin(t *testing.T) {
+	dir := writeFiles(t, lookupFiles)
+	feed := filepath.Join(dir, "feed.csv")
+	// the queries are read line by line, the blank lines are skipped
+	withStdin(t, "10.1.2.3\n\n 10.0.0.0/8 \n192.168.0.1\n")
+	output, err := runCli(t, "lookup", "-f", feed, "--priority-keys", "p", "--format", "json")
+	assert.NoError(t, err)
+	assertGolden(t, "lookup.json", output)
+}
+
+func TestLookupStdinFile(t *testing.T) {
+	dir := writeFiles(t, lookupFiles)
+	withStdin(t, lookupFiles["feed.csv"])
+	// the input file is read from stdin when the queries are arguments
+	output, err := runCli(t, append([]string{"lookup", "-f", "-", "--priority-keys", "p", "--format", "json"}, lookupQueries...)...)
+	assert.NoError(t, err)
+	assertGolden(t, "lookup.json", output)
+
+	_, err = runCli(t, "lookup", "-f", "-", "-f", filepath.Join(dir, "feed.csv"))
+	assert.EqualError(t, err, "the input file can not be read from stdin when the queries are read from stdin, give the queries as arguments")
+}
+
+func TestLookupInvalidQueries(t *testing.T) {
+	dir := writeFiles(t, lookupFiles)
+	output, stderr, err := runCliStderr(t, "lookup", "-f", filepath.Join(dir, "feed.csv"), "--format", "csv", "bad", "10.1.2.3", "10.0.0.0/33")
+	assert.EqualError(t, err, "2 queries are not valid IPs or CIDRs")
+	// the valid queries are still answered
+	assert.Equal(t, "query,cidr,matched,name,p\n10.1.2.3,10.1.0.0/16,true,b,2\n", output)
+	assert.Contains(t, stderr, `ParseAddr("bad")`)
+	assert.Contains(t, stderr, `netip.ParsePrefix("10.0.0.0/33")`)
+}
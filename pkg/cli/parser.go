@@ -1,12 +1,16 @@
 package cli
 
 import (
+	"bufio"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"os"
-	"strconv"
+	"net/netip"
+	"sort"
+	"strings"
 
 	"github.com/khalid-nowaf/supernet/pkg/supernet"
 )
@@ -18,40 +22,108 @@ type CIDR struct {
 
 type Record map[string]string
 
+// ParseFlags configures how the CIDR and the priorities of the input records are parsed, they are shared by the commands reading input files.
+type ParseFlags struct {
+	CidrKey           string         `help:"Key/Colum of the CIDRs in the file" default:"cidr"`
+	PriorityKeys      []string       `help:"Keys/Columns to be used as CIDRs priorities" default:""`
+	FillEmptyPriority bool           `help:"Replace empty/null priority with zero value" default:"true"`
+	FlipRankPriority  bool           `help:"Make low value priority mean higher priority" default:"false"`
+	Priority          []PrioritySpec `help:"Typed priorities compared after --priority-keys, as key[:type[:direction]], the type is int, date or enum(highest>...>lowest), the direction desc gives the highest priority to the greatest value, asc to the smallest, e.g. updated_at:date:desc,source:enum(manual>vendorA>vendorB),confidence:int:asc"`
+	InputFormat       string         `enum:"auto,json,csv,tsv,txt" default:"auto" help:"Format of the input files, auto uses the extension, without .gz or .bz2, or the content of the files without extension, like stdin"`
+
+	sources bool // set the source file and line of the metadata of the CIDRs
+}
+
+// CidrParser reads the records of an input, name is the input file used in the errors.
 type CidrParser interface {
-	Parse(cmd *ResolveCmd, filepath string, onEachCidr func(cidr *CIDR) error) error
+	Parse(cmd *ParseFlags, r io.Reader, name string, onEachCidr func(cidr *CIDR) error) error
+	// ReadRecords calls onEachRecord with each record of the input, and the line where it starts, without parsing it
+	ReadRecords(r io.Reader, name string, onEachRecord func(record Record, line int) error) error
 }
 
-type JsonParser struct{}
+// recordError is an error in a record of an input file, or in the syntax of the file.
+type recordError struct {
+	file string
+	line int
+	err  error
+}
 
-func (_ JsonParser) Parse(cmd *ResolveCmd, filepath string, onEachCidr func(cidr *CIDR) error) error {
-	file, err := os.Open(filepath)
-	if err != nil {
-		return err
+func (e *recordError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.file, e.line, e.err)
+}
+
+func (e *recordError) Unwrap() error {
+	return e.err
+}
+
+// returns the parser of an input format, the records of a text file have only the cidrKey attribute
+func newParser(format string, cidrKey string) (CidrParser, error) {
+	switch format {
+	case "json":
+		return &JsonParser{}, nil
+	case "csv":
+		return &CsvCidrParser{}, nil
+	case "tsv":
+		return &CsvCidrParser{isTSV: true}, nil
+	case "txt":
+		return &TextParser{cidrKey: cidrKey}, nil
+	default:
+		return nil, fmt.Errorf("File type %s is not supported, please use one of the following [json,csv,tsv,txt]", format)
 	}
-	defer file.Close()
+}
 
+// parses the records read by the parser, the errors are prefixed with the file and the line of the record
+func parseRecords(parser CidrParser, cmd *ParseFlags, r io.Reader, name string, onEachCidr func(cidr *CIDR) error) error {
+	return parser.ReadRecords(r, name, func(record Record, line int) error {
+		cidr, err := parseCIDR(record, cmd)
+		if err != nil {
+			return &recordError{file: name, line: line, err: err}
+		}
+		if cmd.sources {
+			cidr.Source = &supernet.Source{File: name, Line: line}
+		}
+		return onEachCidr(cidr)
+	})
+}
+
+type JsonParser struct{}
+
+func (p JsonParser) Parse(cmd *ParseFlags, r io.Reader, name string, onEachCidr func(cidr *CIDR) error) error {
+	return parseRecords(p, cmd, r, name, onEachCidr)
+}
+
+func (_ JsonParser) ReadRecords(r io.Reader, name string, onEachRecord func(record Record, line int) error) error {
 	// Create a JSON Decoder
-	decoder := json.NewDecoder(file)
+	lines := &lineCounter{r: r}
+	decoder := json.NewDecoder(lines)
 
 	// Read opening bracket of the array
-	_, err = decoder.Token()
+	_, err := decoder.Token()
 	if err != nil {
 		return err
 	}
 
 	// Decode each element of the array
 	for decoder.More() {
+		// the raw element is decoded first, to find the offset where it starts
+		raw := json.RawMessage{}
+		if err := decoder.Decode(&raw); err != nil {
+			// the decoder does not move past an invalid element, the syntax error has the offset after the invalid byte
+			offset := decoder.InputOffset()
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				offset = syntaxErr.Offset - 1
+			}
+			return &recordError{file: name, line: lines.line(offset), err: err}
+		}
+		line := lines.line(decoder.InputOffset() - int64(len(raw)))
 		data := Record{}
-		err := decoder.Decode(&data)
-		if err != nil {
-			return err
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return &recordError{file: name, line: line, err: err}
 		}
-		cidr, err := parseCIDR(data, cmd)
-		if err != nil {
+		if err := onEachRecord(data, line); err != nil {
 			return err
 		}
-		onEachCidr(cidr)
 	}
 
 	// Read closing bracket of the array
@@ -63,22 +135,42 @@ func (_ JsonParser) Parse(cmd *ResolveCmd, filepath string, onEachCidr func(cidr
 	return nil
 }
 
-type CsvCidrParser struct{ isTSV bool }
-
-func (p CsvCidrParser) Parse(cmd *ResolveCmd, filePath string, onEachCidr func(cidr *CIDR) error) error {
-	// extension := filepath.Ext(filePath)
-	// if extension != "csv" && extension != "tsv" {
-	// 	return fmt.Errorf("File type %s is not supported, please use one of the following [json,csv,tsv]", extension)
-	// }
+// lineCounter records the offsets of the new lines read, to find the line of an offset.
+type lineCounter struct {
+	r        io.Reader
+	read     int64
+	dropped  int     // new lines before the last offset looked up
+	newLines []int64 // offsets of the new lines after the last offset looked up
+}
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			c.newLines = append(c.newLines, c.read+int64(i))
+		}
 	}
-	defer file.Close()
+	c.read += int64(n)
+	return n, err
+}
+
+// returns the line of the offset, the offsets must be looked up in increasing order
+func (c *lineCounter) line(offset int64) int {
+	before := sort.Search(len(c.newLines), func(i int) bool { return c.newLines[i] >= offset })
+	c.dropped += before
+	c.newLines = c.newLines[before:]
+	return c.dropped + 1
+}
 
+type CsvCidrParser struct{ isTSV bool }
+
+func (p CsvCidrParser) Parse(cmd *ParseFlags, r io.Reader, name string, onEachCidr func(cidr *CIDR) error) error {
+	return parseRecords(p, cmd, r, name, onEachCidr)
+}
+
+func (p CsvCidrParser) ReadRecords(r io.Reader, name string, onEachRecord func(record Record, line int) error) error {
 	// Create a CSV Reader
-	reader := csv.NewReader(file)
+	reader := csv.NewReader(r)
 
 	if p.isTSV {
 		reader.Comma = '\t'
@@ -87,14 +179,20 @@ func (p CsvCidrParser) Parse(cmd *ResolveCmd, filePath string, onEachCidr func(c
 	// Read the header to build the key mapping (assuming first line is the header)
 	headers, err := reader.Read()
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: %w", name, err)
 	}
 
 	// Read each record from the CSV
 	for {
 		recordData, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if parseErr, isParseErr := err.(*csv.ParseError); isParseErr {
+			return &recordError{file: name, line: parseErr.Line, err: parseErr.Err}
+		}
 		if err != nil {
-			break // End of file or an error
+			return err
 		}
 
 		record := make(Record)
@@ -102,12 +200,8 @@ func (p CsvCidrParser) Parse(cmd *ResolveCmd, filePath string, onEachCidr func(c
 			record[headers[i]] = value
 		}
 
-		cidr, err := parseCIDR(record, cmd)
-		if err != nil {
-			return err
-		}
-		err = onEachCidr(cidr)
-		if err != nil {
+		line, _ := reader.FieldPos(0)
+		if err := onEachRecord(record, line); err != nil {
 			return err
 		}
 	}
@@ -115,33 +209,52 @@ func (p CsvCidrParser) Parse(cmd *ResolveCmd, filePath string, onEachCidr func(c
 	return nil
 }
 
-func parseCIDR(record Record, cmd *ResolveCmd) (*CIDR, error) {
+// TextParser reads a CIDR per line, the empty lines and the lines starting with # are skipped.
+type TextParser struct{ cidrKey string }
+
+func (p TextParser) Parse(cmd *ParseFlags, r io.Reader, name string, onEachCidr func(cidr *CIDR) error) error {
+	return parseRecords(p, cmd, r, name, onEachCidr)
+}
+
+func (p TextParser) ReadRecords(r io.Reader, name string, onEachRecord func(record Record, line int) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := onEachRecord(Record{p.cidrKey: text}, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func parseCIDR(record Record, cmd *ParseFlags) (*CIDR, error) {
 	isV6 := false
 
 	var priorities []uint8
 
-	_, cidr, err := net.ParseCIDR(record[cmd.CidrKey])
+	prefix, err := parsePrefix(record[cmd.CidrKey])
 	if err != nil {
 		return nil, fmt.Errorf("Can not parse CIDR on Key: %s CIDR: %s \nRecord: %v", cmd.CidrKey, record[cmd.CidrKey], record)
 	}
+	prefix = prefix.Masked()
+	cidr := &net.IPNet{IP: prefix.Addr().AsSlice(), Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen())}
 
 	for _, priorityKey := range cmd.PriorityKeys {
-		var value int
-		// parse priority value
-		value, err = strconv.Atoi(record[priorityKey])
+		priority, err := keyPriority(priorityKey, record[priorityKey], cmd.FillEmptyPriority, cmd.FlipRankPriority)
 		if err != nil {
-			if cmd.FillEmptyPriority {
-				value = 0
-			} else {
-				panic(fmt.Sprintf("Can not parse priority %s in record:%v", priorityKey, record))
-			}
+			return nil, err
 		}
-		// flip priority
-		if cmd.FlipRankPriority {
-			value = value * -1
+		priorities = append(priorities, priority)
+	}
+	for i := range cmd.Priority {
+		priority, err := cmd.Priority[i].priority(record[cmd.Priority[i].Key], cmd.FillEmptyPriority)
+		if err != nil {
+			return nil, err
 		}
-
-		priorities = append(priorities, uint8(value))
+		priorities = append(priorities, priority...)
 	}
 
 	if cidr.IP.To4() == nil {
@@ -155,3 +268,29 @@ func parseCIDR(record Record, cmd *ResolveCmd) (*CIDR, error) {
 			Attributes: record,
 		}}, nil
 }
+
+// parses a CIDR of an input record, or of a query, the same way in all the commands: the IPv6 zones are rejected,
+// and an IPv4-mapped IPv6 CIDR covering only IPv4 addresses, e.g. ::ffff:10.0.0.0/104, is read as the IPv4 CIDR.
+// The host bits are kept, so they can be reported, Masked clears them.
+func parsePrefix(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix, nil
+}
+
+// parses the IP of a query like parsePrefix, an IPv4-mapped IPv6 address is read as the IPv4 address
+func parseAddr(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("ParseAddr(%q): IPv6 zones are not supported", s)
+	}
+	return addr.Unmap(), nil
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrefix(t *testing.T) {
+	for s, expected := range map[string]string{
+		"10.0.0.0/8":           "10.0.0.0/8",
+		"10.1.2.3/8":           "10.1.2.3/8",
+		"::ffff:10.0.0.0/104":  "10.0.0.0/8",
+		"::ffff:10.1.2.3/128":  "10.1.2.3/32",
+		"::ffff:0.0.0.0/95":    "::ffff:0.0.0.0/95",
+		"2001:db8::/32":        "2001:db8::/32",
+		"fe80::1%eth0/64":      "",
+		"10.0.0.0/33":          "",
+		"::ffff:10.0.0.0/129":  "",
+		"not a cidr":           "",
+		"10.0.0.0":             "",
+		"2001:db8::1%eth0/128": "",
+	} {
+		prefix, err := parsePrefix(s)
+		if expected == "" {
+			assert.Error(t, err, s)
+			continue
+		}
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, prefix.String(), s)
+	}
+
+	for s, expected := range map[string]string{"10.1.2.3": "10.1.2.3", "::ffff:10.1.2.3": "10.1.2.3", "2001:db8::1": "2001:db8::1", "fe80::1%eth0": ""} {
+		addr, err := parseAddr(s)
+		if expected == "" {
+			assert.Error(t, err, s)
+			continue
+		}
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, addr.String(), s)
+	}
+}
+
+func TestCommandsParseMappedCidrs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"feed.csv": "cidr,name\n::ffff:10.0.0.0/104,a\n"})
+	feed := filepath.Join(dir, "feed.csv")
+
+	// the IPv4-mapped CIDR is the IPv4 CIDR for all the commands
+	output, err := runCli(t, "resolve", feed, "-o", "-", "--output-format", "txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/8\n", output)
+	output, err = runCli(t, "convert", feed, "-", "--output-format", "txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/8\n", output)
+	output, err = runCli(t, "lookup", "-f", feed, "--format", "csv", "10.1.2.3", "::ffff:10.1.2.3", "::ffff:10.0.0.0/104")
+	assert.NoError(t, err)
+	assert.Equal(t, "query,cidr,matched,name\n10.1.2.3,10.0.0.0/8,true,a\n::ffff:10.1.2.3,10.0.0.0/8,true,a\n::ffff:10.0.0.0/104,10.0.0.0/8,true,a\n", output)
+	_, err = runCli(t, "validate", feed)
+	assert.NoError(t, err)
+
+	_, stderr, err := runCliStderr(t, "lookup", "-f", feed, "fe80::1%eth0")
+	assert.Error(t, err)
+	assert.Contains(t, stderr, "IPv6 zones are not supported")
+}
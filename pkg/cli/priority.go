@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrioritySpec is a --priority key, with the type of its values and the direction of the ranking,
+// written as key[:type[:direction]], e.g. updated_at:date:desc or source:enum(manual>vendorA>vendorB).
+//
+// The values are encoded as priority bytes, compared in order by the supernet, so a spec takes 8 bytes
+// for int and date, and 1 byte for enum.
+type PrioritySpec struct {
+	Key       string
+	Type      string   // int, date or enum
+	Values    []string // the enum values, from the highest priority to the lowest
+	Ascending bool     // the smallest value has the highest priority, or with enum, the last value
+}
+
+// the layouts of the date values, after RFC 3339
+var priorityDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// UnmarshalText parses the spec of a --priority flag.
+func (spec *PrioritySpec) UnmarshalText(text []byte) error {
+	key, rest, _ := strings.Cut(string(text), ":")
+	*spec = PrioritySpec{Key: key, Type: "int"}
+	if key == "" {
+		return fmt.Errorf("priority %q has no key", text)
+	}
+
+	typ, direction := rest, ""
+	if strings.HasPrefix(rest, "enum(") {
+		end := strings.Index(rest, ")")
+		if end == -1 {
+			return fmt.Errorf("priority %q: enum values are not closed by )", text)
+		}
+		typ, direction = "enum", strings.TrimPrefix(rest[end+1:], ":")
+		for _, value := range strings.Split(rest[len("enum("):end], ">") {
+			spec.Values = append(spec.Values, strings.TrimSpace(value))
+		}
+		if len(spec.Values) > 255 {
+			return fmt.Errorf("priority %q: enum can not have more than 255 values", text)
+		}
+	} else if before, after, found := strings.Cut(rest, ":"); found {
+		typ, direction = before, after
+	}
+
+	switch typ {
+	case "", "int", "date":
+		if typ != "" {
+			spec.Type = typ
+		}
+	case "enum":
+		spec.Type = typ
+	default:
+		return fmt.Errorf("priority %q: type %s is not one of int, date or enum(...)", text, typ)
+	}
+	switch direction {
+	case "", "desc":
+	case "asc":
+		spec.Ascending = true
+	default:
+		return fmt.Errorf("priority %q: direction %s is not asc or desc", text, direction)
+	}
+	return nil
+}
+
+// returns the priority bytes of a value, an empty value has the lowest priority if fillEmpty, otherwise it is an error
+func (spec *PrioritySpec) priority(value string, fillEmpty bool) ([]uint8, error) {
+	if value == "" {
+		if !fillEmpty {
+			return nil, fmt.Errorf("empty priority %s", spec.Key)
+		}
+		return make([]uint8, spec.size()), nil
+	}
+
+	if spec.Type == "enum" {
+		for i, enumValue := range spec.Values {
+			if enumValue != value {
+				continue
+			}
+			// 0 is left to the empty values
+			if spec.Ascending {
+				return []uint8{uint8(i + 1)}, nil
+			}
+			return []uint8{uint8(len(spec.Values) - i)}, nil
+		}
+		return nil, fmt.Errorf("priority %s %q is not one of %s", spec.Key, value, strings.Join(spec.Values, ", "))
+	}
+
+	var number int64
+	if spec.Type == "date" {
+		date, err := parsePriorityDate(value)
+		if err != nil {
+			return nil, fmt.Errorf("priority %s %q is not a date", spec.Key, value)
+		}
+		number = date.UnixMicro()
+	} else {
+		var err error
+		if number, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("priority %s %q is not a number", spec.Key, value)
+		}
+	}
+
+	// flipping the sign bit orders the negative numbers before the positive ones, byte by byte
+	priority := binary.BigEndian.AppendUint64(nil, uint64(number)^(1<<63))
+	if spec.Ascending {
+		for i := range priority {
+			priority[i] = ^priority[i]
+		}
+	}
+	// the zero bytes are left to the empty values, which are only reached by the lowest ranked int64
+	if binary.BigEndian.Uint64(priority) == 0 {
+		return nil, fmt.Errorf("priority %s %q is out of range", spec.Key, value)
+	}
+	return priority, nil
+}
+
+// returns the priority byte of a --priority-keys value, a number between 0 and 255, with flip the smallest number
+// has the highest priority, an empty value has the lowest priority if fillEmpty, otherwise it is an error
+func keyPriority(key string, value string, fillEmpty bool, flip bool) (uint8, error) {
+	if value == "" {
+		if !fillEmpty {
+			return 0, fmt.Errorf("empty priority %s", key)
+		}
+		return 0, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("priority %s %q is not a number", key, value)
+	}
+	if number < 0 || number > 255 {
+		return 0, fmt.Errorf("priority %s %d is not between 0 and 255", key, number)
+	}
+	if flip {
+		return uint8(255 - number), nil
+	}
+	return uint8(number), nil
+}
+
+// returns the number of priority bytes of the spec
+func (spec *PrioritySpec) size() int {
+	if spec.Type == "enum" {
+		return 1
+	}
+	return 8
+}
+
+func parsePriorityDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range priorityDateLayouts {
+		var date time.Time
+		if date, err = time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, err
+}
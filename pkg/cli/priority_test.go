@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrioritySpecUnmarshalText(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected PrioritySpec
+		err      string
+	}{
+		{"confidence", PrioritySpec{Key: "confidence", Type: "int"}, ""},
+		{"confidence:int:asc", PrioritySpec{Key: "confidence", Type: "int", Ascending: true}, ""},
+		{"updated_at:date:desc", PrioritySpec{Key: "updated_at", Type: "date"}, ""},
+		{"source:enum(manual > vendorA>vendorB)", PrioritySpec{Key: "source", Type: "enum", Values: []string{"manual", "vendorA", "vendorB"}}, ""},
+		{"source:enum(a>b):asc", PrioritySpec{Key: "source", Type: "enum", Values: []string{"a", "b"}, Ascending: true}, ""},
+		{":int", PrioritySpec{}, `priority ":int" has no key`},
+		{"source:enum(a>b", PrioritySpec{}, `priority "source:enum(a>b": enum values are not closed by )`},
+		{"confidence:float", PrioritySpec{}, `priority "confidence:float": type float is not one of int, date or enum(...)`},
+		{"confidence:int:up", PrioritySpec{}, `priority "confidence:int:up": direction up is not asc or desc`},
+	}
+
+	for _, tc := range testCases {
+		spec := PrioritySpec{}
+		err := spec.UnmarshalText([]byte(tc.text))
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.text)
+			continue
+		}
+		assert.NoError(t, err, tc.text)
+		assert.Equal(t, tc.expected, spec, tc.text)
+	}
+}
+
+func TestPriorityByteOrder(t *testing.T) {
+	// the values are sorted from the highest priority to the lowest, "" is an empty value
+	testCases := []struct {
+		spec   string
+		values []string
+	}{
+		{"n:int", []string{"9223372036854775807", "1", "0", "-1", "-9223372036854775807", ""}},
+		{"n:int:asc", []string{"-9223372036854775808", "-1", "0", "1", "9223372036854775806", ""}},
+		{"source:enum(manual>vendorA>vendorB)", []string{"manual", "vendorA", "vendorB", ""}},
+		{"source:enum(manual>vendorA>vendorB):asc", []string{"vendorB", "vendorA", "manual", ""}},
+		{"d:date", []string{"2024-01-02T00:00:00.5Z", "2024-01-02T00:00:00Z", "2024-01-01 23:59:59", "2024-01-01T12:00:00", "2024-01-01", "1969-12-31", ""}},
+		{"d:date:asc", []string{"1969-12-31", "2024-01-01", "2024-01-01T12:00:00", "2024-01-02T00:00:00+01:00", "2024-01-01 23:59:59", "2024-01-02T00:00:00Z", ""}},
+	}
+
+	for _, tc := range testCases {
+		spec := PrioritySpec{}
+		assert.NoError(t, spec.UnmarshalText([]byte(tc.spec)))
+		priorities := [][]uint8{}
+		for _, value := range tc.values {
+			priority, err := spec.priority(value, true)
+			assert.NoError(t, err, tc.spec, value)
+			assert.Len(t, priority, spec.size(), tc.spec, value)
+			priorities = append(priorities, priority)
+		}
+		for i := 1; i < len(priorities); i++ {
+			assert.Equal(t, 1, bytes.Compare(priorities[i-1], priorities[i]), "%s: %q should rank above %q", tc.spec, tc.values[i-1], tc.values[i])
+		}
+	}
+}
+
+func TestPriorityDateLayouts(t *testing.T) {
+	spec := PrioritySpec{Key: "d", Type: "date"}
+	expected, _ := spec.priority("2024-03-04T05:06:07Z", false)
+	for _, value := range []string{"2024-03-04T05:06:07Z", "2024-03-04T05:06:07.000Z", "2024-03-04T07:06:07+02:00", "2024-03-04T05:06:07", "2024-03-04 05:06:07"} {
+		priority, err := spec.priority(value, false)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, priority, value)
+	}
+
+	day, _ := spec.priority("2024-03-04", false)
+	midnight, _ := spec.priority("2024-03-04T00:00:00Z", false)
+	assert.Equal(t, midnight, day)
+}
+
+func TestPriorityErrors(t *testing.T) {
+	testCases := []struct {
+		spec  string
+		value string
+		err   string
+	}{
+		{"n:int", "", "empty priority n"},
+		{"n:int", "1.5", `priority n "1.5" is not a number`},
+		{"d:date", "04/03/2024", `priority d "04/03/2024" is not a date`},
+		{"s:enum(a>b)", "c", `priority s "c" is not one of a, b`},
+		// their bytes would be the same as an empty value
+		{"n:int", "-9223372036854775808", `priority n "-9223372036854775808" is out of range`},
+		{"n:int:asc", "9223372036854775807", `priority n "9223372036854775807" is out of range`},
+	}
+
+	for _, tc := range testCases {
+		spec := PrioritySpec{}
+		assert.NoError(t, spec.UnmarshalText([]byte(tc.spec)))
+		_, err := spec.priority(tc.value, false)
+		assert.EqualError(t, err, tc.err, tc.spec)
+	}
+}
+
+func TestKeyPriority(t *testing.T) {
+	testCases := []struct {
+		value    string
+		flip     bool
+		expected uint8
+		err      string
+	}{
+		{"0", false, 0, ""},
+		{"255", false, 255, ""},
+		{"1", true, 254, ""},
+		{"255", true, 0, ""},
+		// the empty values have the lowest priority, flipped or not
+		{"", false, 0, ""},
+		{"", true, 0, ""},
+		{"300", false, 0, "priority p 300 is not between 0 and 255"},
+		{"-1", true, 0, "priority p -1 is not between 0 and 255"},
+		{"x", false, 0, `priority p "x" is not a number`},
+	}
+
+	for _, tc := range testCases {
+		priority, err := keyPriority("p", tc.value, true, tc.flip)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.value)
+			continue
+		}
+		assert.NoError(t, err, tc.value)
+		assert.Equal(t, tc.expected, priority, tc.value)
+	}
+
+	_, err := keyPriority("p", "", false, false)
+	assert.EqualError(t, err, "empty priority p")
+}
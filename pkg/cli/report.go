@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+)
+
+// conflictReport writes a row per conflicted insertion to the --report file, as CSV or as a JSON array.
+type conflictReport struct {
+	out   *outputFile
+	csv   *csv.Writer // nil for json
+	json  *json.Encoder
+	count int
+}
+
+// a conflicted insertion in the report
+type conflictRow struct {
+	CIDR           netip.Prefix          `json:"cidr"`
+	Source         string                `json:"source"`
+	ConflictType   supernet.ConflictKind `json:"conflict_type"`
+	ConflictedWith []conflictedCidr      `json:"conflicted_with"`
+	Winner         string                `json:"winner"`
+	Actions        []supernet.ActionKind `json:"actions"`
+	Fragments      []netip.Prefix        `json:"fragments"` // the CIDRs added by the actions
+	Removed        []netip.Prefix        `json:"removed"`
+}
+
+// an existing CIDR conflicting with an inserted CIDR, it can be a fragment of the CIDR read at its source
+type conflictedCidr struct {
+	CIDR   netip.Prefix `json:"cidr"`
+	Source string       `json:"source"`
+}
+
+var conflictReportHeaders = []string{"cidr", "source", "conflict_type", "conflicted_with", "conflicted_sources", "winner", "actions", "fragments", "removed"}
+
+// creates the report file, the format is csv or json, a report to - is written to stdout
+func newConflictReport(path string, format string) (*conflictReport, error) {
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		return nil, fmt.Errorf("the report format %s is not supported, please use csv or json", format)
+	}
+	out, err := createOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &conflictReport{out: out}
+	if format == "json" {
+		report.json = json.NewEncoder(out)
+		_, err = io.WriteString(out, "[")
+	} else {
+		report.csv = csv.NewWriter(out)
+		err = report.csv.Write(conflictReportHeaders)
+	}
+	if err != nil {
+		out.close(err, nil)
+		return nil, err
+	}
+	return report, nil
+}
+
+// adds a row if the insertion had a conflict, the write errors are kept by the output, and returned by close
+func (report *conflictReport) add(result *supernet.InsertionResult, source *supernet.Source) {
+	if _, noConflict := result.ConflictType.(supernet.NoConflict); noConflict {
+		return
+	}
+	row := &conflictRow{
+		CIDR:           result.CIDR,
+		Source:         sourceString(source),
+		ConflictType:   result.ConflictType.Kind(),
+		ConflictedWith: []conflictedCidr{},
+		Winner:         conflictWinner(result),
+		Actions:        []supernet.ActionKind{},
+		Fragments:      []netip.Prefix{},
+		Removed:        []netip.Prefix{},
+	}
+	for i, cidr := range result.ConflictedWith {
+		conflicted := conflictedCidr{CIDR: cidr}
+		if i < len(result.ConflictedMetadata) && result.ConflictedMetadata[i] != nil {
+			conflicted.Source = sourceString(result.ConflictedMetadata[i].Source)
+		}
+		row.ConflictedWith = append(row.ConflictedWith, conflicted)
+	}
+	for _, action := range result.Actions {
+		row.Actions = append(row.Actions, action.Action.Kind())
+		row.Fragments = append(row.Fragments, action.AddedCidrs...)
+		row.Removed = append(row.Removed, action.RemoveCidrs...)
+	}
+	report.count++
+
+	if report.json != nil {
+		if report.count > 1 {
+			io.WriteString(report.out, ",")
+		}
+		report.json.Encode(row)
+		return
+	}
+	cidrs, sources := []string{}, []string{}
+	for _, conflicted := range row.ConflictedWith {
+		cidrs = append(cidrs, conflicted.CIDR.String())
+		sources = append(sources, conflicted.Source)
+	}
+	actions := []string{}
+	for _, action := range row.Actions {
+		actions = append(actions, action.String())
+	}
+	report.csv.Write([]string{
+		row.CIDR.String(),
+		row.Source,
+		row.ConflictType.String(),
+		strings.Join(cidrs, " "),
+		strings.Join(sources, " "),
+		row.Winner,
+		strings.Join(actions, " "),
+		joinPrefixes(row.Fragments),
+		joinPrefixes(row.Removed),
+	})
+}
+
+// ends and closes the report, the report file is removed if err is not nil
+func (report *conflictReport) close(err error) error {
+	return report.out.close(err, func() error {
+		if report.json != nil {
+			_, err := io.WriteString(report.out, "]")
+			return err
+		}
+		report.csv.Flush()
+		return report.csv.Error()
+	})
+}
+
+// returns which side kept the address space of the conflict: new, existing when the actions added no CIDR,
+// or shared when the new CIDR was split around the existing CIDRs with a higher priority, and some fragments were added
+func conflictWinner(result *supernet.InsertionResult) string {
+	added, split := false, false
+	for _, action := range result.Actions {
+		if _, ignored := action.Action.(supernet.IgnoreInsertion); ignored {
+			return "existing"
+		}
+		if _, isSplit := action.Action.(supernet.SplitInsertedCIDR); isSplit {
+			split = true
+		}
+		added = added || len(action.AddedCidrs) > 0
+	}
+	switch {
+	case !added:
+		return "existing"
+	case split:
+		return "shared"
+	default:
+		return "new"
+	}
+}
+
+func sourceString(source *supernet.Source) string {
+	if source == nil {
+		return ""
+	}
+	return source.String()
+}
+
+func joinPrefixes(prefixes []netip.Prefix) string {
+	strs := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		strs = append(strs, prefix.String())
+	}
+	return strings.Join(strs, " ")
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+	"github.com/stretchr/testify/assert"
+)
+
+var reportFiles = map[string]string{
+	"feed.csv": "cidr,name,p\n" +
+		"10.0.0.0/8,a,1\n" +
+		"10.1.0.0/16,b,2\n" +
+		"10.2.0.0/16,c,0\n" +
+		"11.0.0.0/8,d,1\n",
+	"more.json": "[\n" +
+		"  {\"cidr\": \"10.0.0.0/7\", \"name\": \"e\", \"p\": \"1\"},\n" +
+		"  {\"cidr\": \"11.0.0.0/8\", \"name\": \"f\", \"p\": \"0\"},\n" +
+		"  {\"cidr\": \"12.0.0.0/8\", \"name\": \"g\", \"p\": \"0\"}\n" +
+		"]\n",
+}
+
+func TestResolveReport(t *testing.T) {
+	dir := writeFiles(t, reportFiles)
+	inputs := []string{filepath.Join(dir, "feed.csv"), filepath.Join(dir, "more.json")}
+	for _, format := range []string{"csv", "json"} {
+		report := filepath.Join(dir, "report."+format)
+		for _, bulk := range []bool{false, true} {
+			args := append([]string{"resolve"}, inputs...)
+			args = append(args, "--priority-keys", "p", "-o", filepath.Join(dir, "out.csv"), "--report", report)
+			if bulk {
+				// the bulk load reports the same conflicts
+				args = append(args, "--bulk")
+			}
+			_, err := runCli(t, args...)
+			assert.NoError(t, err)
+			assertGolden(t, "report."+format, trimDir(readFile(t, report), dir))
+		}
+	}
+}
+
+func TestResolveReportToStdout(t *testing.T) {
+	dir := writeFiles(t, reportFiles)
+	output, err := runCli(t, "resolve", filepath.Join(dir, "feed.csv"), "--priority-keys", "p", "-o", filepath.Join(dir, "out.csv"), "--report", "-", "--report-format", "json")
+	assert.NoError(t, err)
+	assertGolden(t, "report_stdout.json", trimDir(output, dir))
+
+	_, err = runCli(t, "resolve", filepath.Join(dir, "feed.csv"), "-o", "-", "--report", "-")
+	assert.Error(t, err, "the output and the report can not both be written to stdout")
+}
+
+func TestConflictWinner(t *testing.T) {
+	fragment := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")}
+	for _, test := range []struct {
+		winner  string
+		actions []*supernet.ActionResult
+	}{
+		{"new", []*supernet.ActionResult{{Action: supernet.InsertNewCIDR{}, AddedCidrs: fragment}, {Action: supernet.RemoveExistingCIDR{}}}},
+		{"existing", []*supernet.ActionResult{{Action: supernet.IgnoreInsertion{}}}},
+		{"shared", []*supernet.ActionResult{{Action: supernet.SplitInsertedCIDR{}, AddedCidrs: fragment}, {Action: supernet.SplitInsertedCIDR{}}}},
+		// every fragment of the new CIDR lost to the existing CIDRs
+		{"existing", []*supernet.ActionResult{{Action: supernet.SplitInsertedCIDR{}}, {Action: supernet.SplitInsertedCIDR{}}}},
+	} {
+		assert.Equal(t, test.winner, conflictWinner(&supernet.InsertionResult{Actions: test.actions}))
+	}
+}
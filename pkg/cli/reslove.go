@@ -1,8 +1,13 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/khalid-nowaf/supernet/pkg/supernet"
@@ -11,7 +16,7 @@ import (
 type Stats struct {
 	Input           int
 	Output          int
-	Conflicted      int
+	Conflicted      int // -1 if the conflicts are not counted
 	StartInsertTime time.Time
 	EndInsertTime   time.Time
 	StartOutputTime time.Time
@@ -19,92 +24,245 @@ type Stats struct {
 }
 
 type ResolveCmd struct {
-	Files             []string `arg:"" type:"existingfile" help:"Input file containing CIDRs in CSV or JSON format"`
-	CidrKey           string   `help:"Key/Colum of the CIDRs in the file" default:"cidr"`
-	PriorityKeys      []string `help:"Keys/Columns to be used as CIDRs priorities" default:""`
-	FillEmptyPriority bool     `help:"Replace empty/null priority with zero value" default:"true"`
-	FlipRankPriority  bool     `help:"Make low value priority mean higher priority" default:"false"`
-	Report            bool     `help:"Report only conflicted CIDRs"`
-
-	OutputFormat    string   `enum:"json,csv,tsv" default:"csv" help:"Output file format" default:"csv"`
-	DropKeys        []string `help:"Keys/Columns to be dropped" default:""`
-	SplitIpVersions bool     `help:"Split the results in to separate files based on the CIDR IP version" default:"false"`
-	Stats           Stats    `kong:"-"`
+	Files            []string `arg:"" type:"existingfile" help:"Input file containing CIDRs in CSV or JSON format"`
+	ParseFlags       `embed:""`
+	Report           string `help:"Write a report of the conflicts to this file, a row per conflicted CIDR with the conflicting CIDRs, the winner, the actions and the resulting fragments, and the file and line of the records, - for stdout"`
+	ReportFormat     string `enum:"auto,csv,json" default:"auto" help:"Format of the report, auto uses the extension of --report, or csv"`
+	Workers          int    `help:"Number of goroutines inserting the CIDRs, the IP versions and the CIDRs under different /8 are inserted concurrently" default:"1"`
+	InternAttributes bool   `help:"Share the attributes of the CIDRs with equal attributes to reduce the memory usage, the CIDR column is restored when writing"`
+	Bulk             bool   `help:"Read all the files, then build the resolved CIDRs in one pass, faster with many conflicts but uses more memory, --workers is ignored, the conflicts are only counted with --report or --log"`
+
+	SourcePriority map[string]int `mapsep:"," help:"Rank of the records of each file, between 0 and 255, compared before the other priorities, the files are given as in the arguments or by their name if it is unique, the files not listed have the rank 0, e.g. --source-priority overrides.csv=10,vendor.csv=1"`
+	SourceOrder    bool           `help:"Rank the records of each file by the position of the file in the arguments, compared before the other priorities, the records of a file beat the records of the files before it"`
+	SourceKey      string         `help:"Key/Column recording the file of each record in the output, with --source-priority or --source-order, empty to not record it" default:"source_file"`
+
+	Output          string            `short:"o" help:"Output file, - for stdout, {family} is replaced by v4 or v6 with --split-ip-versions, {key} by the value of --split-key, and a name ending in .gz is compressed, resolved.<format> by default"`
+	OutputFormat    string            `enum:"auto,json,csv,tsv,txt,db" default:"auto" help:"Output file format, txt has only the CIDRs, db is a binary file that can be loaded with supernet.Load, auto uses the extension of --output, or csv"`
+	DropKeys        []string          `help:"Keys/Columns to be dropped" default:""`
+	Rename          map[string]string `help:"Keys/Columns to be renamed, e.g. --rename name=country;p=priority"`
+	SplitIpVersions bool              `help:"Split the results in to separate files based on the CIDR IP version" default:"false"`
+	SplitKey        string            `help:"Split the results in to separate files based on the value of this key/column"`
+	Stats           Stats             `kong:"-"`
+
+	bulkRecords []supernet.CidrRecord
+	messages    io.Writer // the progress and the stats, stderr when the output or the report is stdout
+	report      *conflictReport
+	sourceRanks map[string]uint8 // the rank of each file, with --source-priority or --source-order
 }
 
 // Run executes the resolve command.
 func (cmd *ResolveCmd) Run(ctx *Context) error {
+	cmd.messages = os.Stdout
+	if cmd.Output == "-" || cmd.Report == "-" {
+		cmd.messages = os.Stderr
+	}
+	if cmd.Output == "-" && cmd.Report == "-" {
+		return errors.New("the output and the report can not be both written to stdout")
+	}
+	writer, output, err := newWriter(cmd)
+	if err != nil {
+		return err
+	}
+	if cmd.sourceRanks, err = cmd.rankSources(); err != nil {
+		return err
+	}
+	if cmd.Report != "" {
+		if cmd.report, err = newConflictReport(cmd.Report, outputFormat(cmd.ReportFormat, cmd.Report)); err != nil {
+			return err
+		}
+		cmd.sources = true
+	}
+
 	cmd.Stats.StartInsertTime = time.Now()
+	if cmd.InternAttributes {
+		ctx.super = supernet.WithAttributeStore(supernet.NewAttributeStore())(ctx.super)
+	}
 
 	// we read each record and insert it in supernet
-	for _, file := range cmd.Files {
-		if err := parseAndInsertCidrs(ctx.super, cmd, file); err != nil {
-			return err
-		}
+	if err := cmd.insertFiles(ctx); err != nil {
+		return err
 	}
 	cmd.Stats.EndInsertTime = time.Now()
 
 	// write back the resolved cidrs to file
-	var writer Writer
-	switch cmd.OutputFormat {
-	case "csv":
-		writer = &CsvWriter{splitIpVersions: cmd.SplitIpVersions, Stats: &cmd.Stats}
-	case "tsv":
-		writer = &CsvWriter{splitIpVersions: cmd.SplitIpVersions, isTSV: true, Stats: &cmd.Stats}
-	case "json":
-		writer = &JsonWriter{splitIpVersions: cmd.SplitIpVersions, Stats: &cmd.Stats}
-	default:
-		return fmt.Errorf("--output-format %s is not supported, please uses one of the following: [json,csv,tsv]", cmd.OutputFormat)
-	}
-
 	cmd.Stats.StartOutputTime = time.Now()
-
-	var err error
-	if cmd.SplitIpVersions {
-		err = writer.IsIpV6(false).Write(ctx.super, ".", cmd.CidrKey, cmd.DropKeys)
-		err = writer.IsIpV6(true).Write(ctx.super, ".", cmd.CidrKey, cmd.DropKeys)
-	} else {
-
-		err = writer.Write(ctx.super, ".", cmd.CidrKey, cmd.DropKeys)
-	}
-	if err != nil {
+	fmt.Fprintln(cmd.messages, "Starting to write resolved CIDRs...")
+	if err := writer.Write(ctx, ctx.super, output); err != nil {
 		return err
 	}
+	fmt.Fprintln(cmd.messages, "Writing complete.")
 	cmd.Stats.EndOutputTime = time.Now()
-	printStats(cmd.Stats)
+	printStats(cmd.messages, cmd.Stats)
+	return nil
+}
+
+// inserts the records of the files, and writes the report of their conflicts
+func (cmd *ResolveCmd) insertFiles(ctx *Context) (err error) {
+	if cmd.report != nil {
+		defer func() {
+			if err = cmd.report.close(err); err == nil {
+				fmt.Fprintf(cmd.messages, "%d conflicts reported to %s\n", cmd.report.count, cmd.Report)
+			}
+		}()
+	}
+	for _, file := range cmd.Files {
+		if err := parseAndInsertCidrs(ctx.super, cmd, file); err != nil {
+			return err
+		}
+	}
+	if cmd.Bulk {
+		// the results are as costly as inserting the records one by one, so they are only computed when they are reported
+		var onResult func(i int, result *supernet.InsertionResult)
+		if cmd.report != nil || ctx.logged {
+			onResult = func(i int, result *supernet.InsertionResult) {
+				cmd.recordResult(result, cmd.bulkRecords[i].Metadata)
+			}
+		} else {
+			cmd.Stats.Input += len(cmd.bulkRecords)
+			cmd.Stats.Conflicted = -1
+		}
+		ctx.super.BulkLoad(cmd.bulkRecords, onResult)
+		cmd.bulkRecords = nil
+	}
 	return nil
 }
 
 // parseAndInsertCidrs parses a file and inserts CIDRs into the supernet.
 func parseAndInsertCidrs(super *supernet.Supernet, cmd *ResolveCmd, file string) error {
-	var parser CidrParser
-	extension := filepath.Ext(file)
-	switch extension {
-	case ".json":
-		parser = &JsonParser{}
-	case ".csv":
-		parser = &CsvCidrParser{}
-	case ".tsv":
-		parser = &CsvCidrParser{isTSV: true}
-	default:
-		{
-			return fmt.Errorf("File type %s is not supported, please use one of the following [json,csv,tsv]", extension)
+	in, parser, err := openParser(file, &cmd.ParseFlags)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return insertRecords(super, cmd, in, parser)
+}
+
+// inserts the records of the opened input into the supernet
+func insertRecords(super *supernet.Supernet, cmd *ResolveCmd, in *input, parser CidrParser) error {
+	// with several workers, the records are inserted by batches, in their order
+	batch := []supernet.CidrRecord{}
+	insertBatch := func() {
+		for i, result := range super.InsertCidrs(batch, cmd.Workers) {
+			cmd.recordResult(result, batch[i].Metadata)
 		}
+		batch = batch[:0]
 	}
 
-	return parser.Parse(cmd, file, func(cidr *CIDR) error {
-		result := super.InsertCidr(cidr.cidr, cidr.Metadata)
-		if _, noConflict := result.ConflictType.(supernet.NoConflict); noConflict {
-			cmd.Stats.Conflicted++
+	rank, ranked := cmd.sourceRanks[in.path]
+	err := parser.Parse(&cmd.ParseFlags, in, in.name(), func(cidr *CIDR) error {
+		if ranked {
+			// the rank of the file is compared first
+			cidr.Priority = append([]uint8{rank}, cidr.Priority...)
+			if cmd.SourceKey != "" {
+				if _, exists := cidr.Attributes[cmd.SourceKey]; exists {
+					return fmt.Errorf("%s: the records already have the key %s, set another --source-key, or an empty one to not record the files", in.name(), cmd.SourceKey)
+				}
+				cidr.Attributes[cmd.SourceKey] = in.name()
+			}
+		}
+		if cmd.InternAttributes {
+			// each record has its own CIDR, so the attributes can only be shared without it
+			delete(cidr.Metadata.Attributes, cmd.CidrKey)
+		}
+		if cmd.Bulk {
+			cmd.bulkRecords = append(cmd.bulkRecords, supernet.CidrRecord{CIDR: cidr.cidr, Metadata: cidr.Metadata})
+			return nil
+		}
+		if cmd.Workers <= 1 {
+			cmd.recordResult(super.InsertCidr(cidr.cidr, cidr.Metadata), cidr.Metadata)
+			return nil
+		}
+		batch = append(batch, supernet.CidrRecord{CIDR: cidr.cidr, Metadata: cidr.Metadata})
+		if len(batch) == insertBatchSize {
+			insertBatch()
 		}
-		cmd.Stats.Input++
 		return nil
 	})
+	if len(batch) > 0 {
+		insertBatch()
+	}
+	return err
+}
+
+// returns the rank of each file with --source-priority or --source-order, or nil
+func (cmd *ResolveCmd) rankSources() (map[string]uint8, error) {
+	switch {
+	case cmd.SourceOrder && len(cmd.SourcePriority) > 0:
+		return nil, errors.New("--source-priority and --source-order can not be used together")
+	case cmd.SourceOrder:
+		if len(cmd.Files) > 256 {
+			return nil, errors.New("--source-order can rank up to 256 files")
+		}
+		ranks := map[string]uint8{}
+		for i, file := range cmd.Files {
+			ranks[file] = uint8(i)
+		}
+		return ranks, nil
+	case len(cmd.SourcePriority) > 0:
+		ranks := map[string]uint8{}
+		for _, file := range cmd.Files {
+			ranks[file] = 0
+		}
+		for source, rank := range cmd.SourcePriority {
+			if rank < 0 || rank > 255 {
+				return nil, fmt.Errorf("the source priority of %s %d is not between 0 and 255", source, rank)
+			}
+			file, err := cmd.sourceFile(source)
+			if err != nil {
+				return nil, err
+			}
+			ranks[file] = uint8(rank)
+		}
+		return ranks, nil
+	}
+	return nil, nil
 }
 
-func printStats(stats Stats) {
-	fmt.Printf("CIDRs Inserted:\t\t\t\t%d\nCIDRs With Conflicts:\t\t\t%d\nTotal CIDRs After Conflict Resolution:\t%d\n", stats.Input, stats.Conflicted, stats.Output)
-	fmt.Printf("Conflict Resolution Duration:\t\t%f Sec\n", stats.EndInsertTime.Sub(stats.StartInsertTime).Seconds())
-	fmt.Printf("Writing Results Duration:\t\t%f Sec\n", stats.EndOutputTime.Sub(stats.StartOutputTime).Seconds())
-	fmt.Printf("Total Time:\t\t\t\t%f Sec\n", stats.EndOutputTime.Sub(stats.StartInsertTime).Seconds())
+// returns the input file of a --source-priority source, given as in the arguments or by its name, which must be unique
+func (cmd *ResolveCmd) sourceFile(source string) (string, error) {
+	// the arguments are absolute, as they are expanded by kong
+	path, err := filepath.Abs(source)
+	if err != nil {
+		return "", err
+	}
+	matches := []string{}
+	for _, file := range cmd.Files {
+		if file == source || file == path {
+			return file, nil
+		}
+		if filepath.Base(file) == source {
+			matches = append(matches, file)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("the source priority %s is not one of the input files", source)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("the source priority %s matches several input files, %s, give its path instead", source, strings.Join(matches, ", "))
+}
+
+// number of records inserted at once with --workers
+const insertBatchSize = 100_000
+
+func (cmd *ResolveCmd) recordResult(result *supernet.InsertionResult, metadata *supernet.Metadata) {
+	if cmd.report != nil {
+		cmd.report.add(result, metadata.Source)
+	}
+	if _, noConflict := result.ConflictType.(supernet.NoConflict); noConflict {
+		cmd.Stats.Conflicted++
+	}
+	cmd.Stats.Input++
+}
+
+func printStats(w io.Writer, stats Stats) {
+	conflicted := strconv.Itoa(stats.Conflicted)
+	if stats.Conflicted < 0 {
+		conflicted = "not counted with --bulk, without --report or --log"
+	}
+	fmt.Fprintf(w, "CIDRs Inserted:\t\t\t\t%d\nCIDRs With Conflicts:\t\t\t%s\nTotal CIDRs After Conflict Resolution:\t%d\n", stats.Input, conflicted, stats.Output)
+	fmt.Fprintf(w, "Conflict Resolution Duration:\t\t%f Sec\n", stats.EndInsertTime.Sub(stats.StartInsertTime).Seconds())
+	fmt.Fprintf(w, "Writing Results Duration:\t\t%f Sec\n", stats.EndOutputTime.Sub(stats.StartOutputTime).Seconds())
+	fmt.Fprintf(w, "Total Time:\t\t\t\t%f Sec\n", stats.EndOutputTime.Sub(stats.StartInsertTime).Seconds())
 }
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var sourceFiles = map[string]string{
+	"vendor.csv":    "cidr,name,p\n10.0.0.0/8,vendor,9\n11.0.0.0/8,vendor,9\n",
+	"overrides.csv": "cidr,name,p\n10.0.0.0/8,manual,1\n",
+}
+
+func TestResolveSourceRanks(t *testing.T) {
+	testCases := []struct {
+		files    []string
+		flags    []string
+		expected string
+	}{
+		// without ranks, the highest p wins
+		{[]string{"vendor.csv", "overrides.csv"}, nil, "cidr,name,p\n10.0.0.0/8,vendor,9\n11.0.0.0/8,vendor,9\n"},
+		{[]string{"vendor.csv", "overrides.csv"}, []string{"--source-priority", "overrides.csv=10"},
+			"cidr,name,p,source_file\n10.0.0.0/8,manual,1,overrides.csv\n11.0.0.0/8,vendor,9,vendor.csv\n"},
+		{[]string{"overrides.csv", "vendor.csv"}, []string{"--source-priority", "overrides.csv=10,vendor.csv=1", "--source-key", "from"},
+			"cidr,from,name,p\n10.0.0.0/8,overrides.csv,manual,1\n11.0.0.0/8,vendor.csv,vendor,9\n"},
+		// the last file wins
+		{[]string{"vendor.csv", "overrides.csv"}, []string{"--source-order", "--source-key", ""}, "cidr,name,p\n10.0.0.0/8,manual,1\n11.0.0.0/8,vendor,9\n"},
+		{[]string{"overrides.csv", "vendor.csv"}, []string{"--source-order", "--source-key", ""}, "cidr,name,p\n10.0.0.0/8,vendor,9\n11.0.0.0/8,vendor,9\n"},
+	}
+
+	for _, tc := range testCases {
+		for _, bulk := range []bool{false, true} {
+			dir := writeFiles(t, sourceFiles)
+			output := filepath.Join(dir, "out.csv")
+			args := []string{"resolve"}
+			for _, file := range tc.files {
+				args = append(args, filepath.Join(dir, file))
+			}
+			args = append(args, "--priority-keys", "p", "-o", output)
+			args = append(args, tc.flags...)
+			if bulk {
+				args = append(args, "--bulk")
+			}
+			_, err := runCli(t, args...)
+			assert.NoError(t, err, args)
+			assert.Equal(t, tc.expected, trimDir(readFile(t, output), dir), args)
+		}
+	}
+}
+
+func TestResolveSourceRankErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a/vendor.csv": sourceFiles["vendor.csv"],
+		"b/vendor.csv": sourceFiles["overrides.csv"],
+		"tagged.csv":   "cidr,name,source_file\n12.0.0.0/8,tagged,feed.csv\n",
+	})
+	a, b, tagged := filepath.Join(dir, "a", "vendor.csv"), filepath.Join(dir, "b", "vendor.csv"), filepath.Join(dir, "tagged.csv")
+	output := filepath.Join(dir, "out.csv")
+
+	_, err := runCli(t, "resolve", a, b, "--source-priority", "vendor.csv=1", "-o", output)
+	assert.EqualError(t, err, "the source priority vendor.csv matches several input files, "+a+", "+b+", give its path instead")
+
+	_, err = runCli(t, "resolve", a, b, "--source-priority", "missing.csv=1", "-o", output)
+	assert.EqualError(t, err, "the source priority missing.csv is not one of the input files")
+
+	// the path of an ambiguous name ranks only that file
+	_, err = runCli(t, "resolve", a, b, "--source-priority", b+"=1", "-o", output)
+	assert.NoError(t, err)
+	assert.Equal(t, "cidr,name,p,source_file\n10.0.0.0/8,manual,1,b/vendor.csv\n11.0.0.0/8,vendor,9,a/vendor.csv\n", trimDir(readFile(t, output), dir))
+
+	_, err = runCli(t, "resolve", tagged, "--source-order", "-o", output)
+	assert.EqualError(t, err, tagged+": the records already have the key source_file, set another --source-key, or an empty one to not record the files")
+	_, err = runCli(t, "resolve", tagged, "--source-order", "--source-key", "from", "-o", output)
+	assert.NoError(t, err)
+	assert.Equal(t, "cidr,from,name,source_file\n12.0.0.0/8,tagged.csv,tagged,feed.csv\n", trimDir(readFile(t, output), dir))
+}
+
+func TestResolvePriorityKeys(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"in.csv":    "cidr,p\n10.0.0.0/8,1\n10.0.0.0/8,2\n",
+		"range.csv": "cidr,p\n10.0.0.0/8,300\n",
+	})
+	output := filepath.Join(dir, "out.csv")
+
+	_, err := runCli(t, "resolve", filepath.Join(dir, "in.csv"), "--priority-keys", "p", "-o", output)
+	assert.NoError(t, err)
+	assert.Equal(t, "cidr,p\n10.0.0.0/8,2\n", readFile(t, output))
+
+	// the lowest value wins
+	_, err = runCli(t, "resolve", filepath.Join(dir, "in.csv"), "--priority-keys", "p", "--flip-rank-priority", "-o", output)
+	assert.NoError(t, err)
+	assert.Equal(t, "cidr,p\n10.0.0.0/8,1\n", readFile(t, output))
+
+	_, err = runCli(t, "resolve", filepath.Join(dir, "range.csv"), "--priority-keys", "p", "-o", output)
+	assert.ErrorContains(t, err, "priority p 300 is not between 0 and 255")
+}
+
+func TestResolveBulkStats(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"in.csv": "cidr,p\n10.0.0.0/8,1\n10.1.0.0/16,2\n11.0.0.0/8,1\n"})
+	output := filepath.Join(dir, "out.csv")
+	printed, err := runCli(t, "resolve", filepath.Join(dir, "in.csv"), "--priority-keys", "p", "--bulk", "-o", output)
+	assert.NoError(t, err)
+	assert.Contains(t, printed, "CIDRs Inserted:\t\t\t\t3\n")
+	assert.Contains(t, printed, "CIDRs With Conflicts:\t\t\tnot counted with --bulk, without --report or --log\n")
+
+	// the results are computed for the log
+	printed, err = runCli(t, "--log", "--log-level", "error", "resolve", filepath.Join(dir, "in.csv"), "--priority-keys", "p", "--bulk", "-o", output)
+	assert.NoError(t, err)
+	assert.Contains(t, printed, "CIDRs Inserted:\t\t\t\t3\n")
+	assert.NotContains(t, printed, "not counted")
+}
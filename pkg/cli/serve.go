@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/khalid-nowaf/supernet/pkg/server"
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+)
+
+type ServeCmd struct {
+	Db             string        `type:"existingfile" required:"" help:"File written by resolve --output-format db"`
+	CidrKey        string        `help:"Key/Colum of the CIDRs of the input records, it is not returned, since a resolved CIDR can be a fragment of it" default:"cidr"`
+	Listen         string        `help:"Address to listen on" default:":8080"`
+	ReloadInterval time.Duration `help:"Reload the db file when it changes, checked at this interval, 0 disables it, the file is also reloaded on SIGHUP" default:"0"`
+	MaxBatchSize   int           `help:"Maximum number of IPs in a POST /lookup request" default:"10000"`
+	ShutdownDelay  time.Duration `help:"Time given to the in-flight requests to finish on shutdown" default:"5s"`
+}
+
+// Run serves the lookups of the db file until the command is interrupted.
+func (cmd *ServeCmd) Run(ctx *Context) error {
+	load := supernet.ReaderLoader(cmd.Db)
+	lookuper, release, err := load()
+	if err != nil {
+		return err
+	}
+	handle := supernet.NewHandle(lookuper, release)
+	defer handle.Close()
+
+	onReloadError := reloadErrorLogger(ctx.logger, cmd.Db)
+	go handle.ReloadOnSignal(ctx, load, onReloadError, syscall.SIGHUP)
+	if cmd.ReloadInterval > 0 {
+		go handle.ReloadOnChange(ctx, cmd.Db, cmd.ReloadInterval, load, onReloadError)
+	}
+
+	httpServer := &http.Server{
+		Addr:              cmd.Listen,
+		Handler:           server.New(handle, server.WithMaxBatchSize(cmd.MaxBatchSize), server.WithCidrKey(cmd.CidrKey)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cmd.ShutdownDelay)
+		defer cancel()
+		shutdown <- httpServer.Shutdown(shutdownCtx)
+	}()
+
+	ctx.logger.Info("serving the lookups", "db", cmd.Db, "listen", cmd.Listen)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-shutdown
+}
+
+// returns the callback logging the failed reloads of the db file, the server keeps the previous data
+func reloadErrorLogger(logger *slog.Logger, db string) func(err error) {
+	return func(err error) {
+		logger.Error("reloading the db file failed, the previous data is kept", "db", db, "error", err)
+	}
+}
@@ -0,0 +1,16 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadErrorLogger(t *testing.T) {
+	output := strings.Builder{}
+	reloadErrorLogger(newSlogLogger(&output, "json", "info"), "feed.db")(errors.New("invalid file"))
+	assert.Contains(t, output.String(), `"level":"ERROR"`)
+	assert.Contains(t, output.String(), `"db":"feed.db","error":"invalid file"`)
+}
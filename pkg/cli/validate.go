@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ValidateCmd struct {
+	Files      []string `arg:"" type:"existingfile" help:"Input files containing CIDRs in CSV, TSV or JSON format, the duplicates and overlaps are checked across all of them"`
+	ParseFlags `embed:""`
+	Fix        bool `help:"Write a canonicalized copy of each file next to it as <name>.fixed.<ext>: the host bits of the CIDRs are cleared, and the exact duplicates and the records with errors are dropped"`
+}
+
+// where a record starts in the input files
+type location struct {
+	file string
+	line int
+}
+
+func (l location) String() string {
+	return fmt.Sprintf("%s:%d", l.file, l.line)
+}
+
+// a problem found in a record, the errors make resolve fail or misread the record, the warnings are resolved as conflicts
+type diagnostic struct {
+	location
+	isError bool
+	message string
+}
+
+func (d diagnostic) String() string {
+	severity := "warning"
+	if d.isError {
+		severity = "error"
+	}
+	return fmt.Sprintf("%s: %s: %s", d.location, severity, d.message)
+}
+
+// a valid CIDR of the input files, kept to find the overlaps once all the files are read
+type validatedCidr struct {
+	prefix netip.Prefix
+	location
+}
+
+// the first record of a CIDR, with its attributes to find the exact duplicates
+type firstRecord struct {
+	location
+	attributes string
+}
+
+// Run checks each record of the files, and prints the diagnostics sorted by file and line.
+func (cmd *ValidateCmd) Run(ctx *Context) error {
+	diagnostics := []diagnostic{}
+	cidrs := []validatedCidr{}
+	// the first record of each CIDR, to find the duplicates
+	firsts := map[netip.Prefix]firstRecord{}
+	// the order of the files, by name
+	order := map[string]int{}
+
+	for i, file := range cmd.Files {
+		in, parser, err := openParser(file, &cmd.ParseFlags)
+		if err != nil {
+			return err
+		}
+		order[in.name()] = i
+		var fixed *encodedOutput
+		if cmd.Fix {
+			if fixed, err = newFixedFile(in, cmd.CidrKey); err != nil {
+				in.Close()
+				return err
+			}
+		}
+
+		err = parser.ReadRecords(in, in.name(), func(record Record, line int) error {
+			at := location{in.name(), line}
+			report := func(isError bool, format string, args ...any) {
+				diagnostics = append(diagnostics, diagnostic{location: at, isError: isError, message: fmt.Sprintf(format, args...)})
+			}
+
+			hasError := !cmd.validatePriorities(record, report)
+			prefix, err := parsePrefix(record[cmd.CidrKey])
+			if err != nil {
+				report(true, "malformed CIDR %q in %s", record[cmd.CidrKey], cmd.CidrKey)
+				hasError = true
+			} else if masked := prefix.Masked(); masked != prefix {
+				report(false, "CIDR %s has host bits set, it is read as %s", prefix, masked)
+				prefix = masked
+			}
+			if hasError {
+				return ctx.Err()
+			}
+
+			attributes := recordKey(record, cmd.CidrKey)
+			if first, found := firsts[prefix]; found {
+				if first.attributes == attributes {
+					report(false, "duplicate of %s", first.location)
+					// the duplicate has no effect, it is neither checked for overlaps nor fixed
+					return ctx.Err()
+				}
+				report(false, "%s is also at %s with different attributes", prefix, first.location)
+			} else {
+				firsts[prefix] = firstRecord{location: at, attributes: attributes}
+			}
+			cidrs = append(cidrs, validatedCidr{prefix: prefix, location: at})
+
+			if fixed != nil {
+				record[cmd.CidrKey] = prefix.String()
+				if err := fixed.encoder.Encode(record); err != nil {
+					return err
+				}
+			}
+			return ctx.Err()
+		})
+		// a syntax error of the file is a diagnostic, but the records after it can not be read
+		var syntaxErr *recordError
+		if errors.As(err, &syntaxErr) {
+			diagnostics = append(diagnostics, diagnostic{
+				location: location{in.name(), syntaxErr.line},
+				isError:  true,
+				message:  fmt.Sprintf("%v, the rest of the file is not checked", syntaxErr.err),
+			})
+			err = nil
+		}
+		in.Close()
+		if fixed != nil {
+			if err = fixed.close(err, fixed.encoder.End); err == nil {
+				fmt.Printf("Fixed copy written to %s\n", fixed.path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	diagnostics = append(diagnostics, findOverlaps(cidrs)...)
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i], diagnostics[j]
+		if a.file != b.file {
+			return order[a.file] < order[b.file]
+		}
+		return a.line < b.line
+	})
+
+	errorCount := 0
+	for _, diagnostic := range diagnostics {
+		fmt.Println(diagnostic)
+		if diagnostic.isError {
+			errorCount++
+		}
+	}
+	fmt.Printf("%d errors, %d warnings\n", errorCount, len(diagnostics)-errorCount)
+	if errorCount > 0 {
+		return fmt.Errorf("the input files have %d errors", errorCount)
+	}
+	return nil
+}
+
+// reports the priorities that are not a number between 0 and 255, or not valid for their --priority spec, it returns false if there is any
+func (cmd *ValidateCmd) validatePriorities(record Record, report func(isError bool, format string, args ...any)) bool {
+	valid := true
+	for _, key := range cmd.PriorityKeys {
+		if _, err := keyPriority(key, record[key], cmd.FillEmptyPriority, cmd.FlipRankPriority); err != nil {
+			report(true, "%v", err)
+			valid = false
+		}
+	}
+	for i := range cmd.Priority {
+		if _, err := cmd.Priority[i].priority(record[cmd.Priority[i].Key], cmd.FillEmptyPriority); err != nil {
+			report(true, "%v", err)
+			valid = false
+		}
+	}
+	return valid
+}
+
+// returns the attributes of the record without the CIDR column, in a comparable form
+func recordKey(record Record, cidrKey string) string {
+	keys := make([]string, 0, len(record))
+	for key := range record {
+		if key != cidrKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	builder := strings.Builder{}
+	for _, key := range keys {
+		builder.WriteString(strconv.Quote(key) + "=" + strconv.Quote(record[key]) + ",")
+	}
+	return builder.String()
+}
+
+// reports the CIDRs within another CIDR, with the smallest CIDR containing them
+func findOverlaps(cidrs []validatedCidr) []diagnostic {
+	// the CIDRs containing another one are sorted before it, since they have the same or a lower address, and a shorter mask
+	sort.SliceStable(cidrs, func(i, j int) bool {
+		a, b := cidrs[i].prefix, cidrs[j].prefix
+		if a.Addr() != b.Addr() {
+			return a.Addr().Less(b.Addr())
+		}
+		return a.Bits() < b.Bits()
+	})
+
+	diagnostics := []diagnostic{}
+	// the CIDRs containing the current one, the smallest last
+	containing := []validatedCidr{}
+	for _, cidr := range cidrs {
+		for len(containing) > 0 && !containing[len(containing)-1].prefix.Overlaps(cidr.prefix) {
+			containing = containing[:len(containing)-1]
+		}
+		if len(containing) > 0 {
+			parent := containing[len(containing)-1]
+			if parent.prefix == cidr.prefix {
+				// already reported as a duplicate, the first record stays the containing one
+				continue
+			}
+			diagnostics = append(diagnostics, diagnostic{location: cidr.location, message: fmt.Sprintf("%s overlaps %s at %s", cidr.prefix, parent.prefix, parent.location)})
+		}
+		containing = append(containing, cidr)
+	}
+	return diagnostics
+}
+
+// creates the canonicalized copy of an input file written by --fix, in the format of the input,
+// e.g. feed.fixed.csv.gz for feed.csv.gz, it is not compressed if the input is compressed with bzip2
+func newFixedFile(in *input, cidrKey string) (*encodedOutput, error) {
+	if in.path == "-" {
+		return nil, errors.New("--fix can not be used with stdin, since the copy is written next to the input file")
+	}
+	encoder, err := newEncoder(in.format, cidrKey)
+	if err != nil {
+		return nil, err
+	}
+	base := in.base()
+	path := strings.TrimSuffix(base, filepath.Ext(base)) + ".fixed." + in.format
+	if in.compressed == ".gz" {
+		path += ".gz"
+	}
+	file, err := createOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	fixed := &encodedOutput{outputFile: file, encoder: encoder}
+	if err := encoder.Begin(fixed); err != nil {
+		return nil, fixed.close(err, nil)
+	}
+	return fixed, nil
+}
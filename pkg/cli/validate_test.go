@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the same kinds of problems in each input format, with the duplicates and overlaps across the files
+var validateFiles = map[string]string{
+	"feed.csv": "cidr,name,p\n" +
+		"10.0.0.1/8,a,1\n" +
+		"10.0.0.0/8,a,1\n" +
+		"10.0.0.0/8,b,1\n" +
+		"bad,a,1\n" +
+		"10.1.0.0/16,c,300\n" +
+		"10.2.0.0/16,c,2\n",
+	"feed.tsv": "cidr\tname\tp\n" +
+		"192.168.0.0/16\tx\t1\n" +
+		"192.168.1.1/24\ty\t1\n" +
+		"10.0.0.0/8\ta\t1\n",
+	"feed.json": "[\n" +
+		"  {\"cidr\": \"172.16.0.0/12\", \"name\": \"z\", \"p\": \"1\"},\n" +
+		"  {\"cidr\": \"10.0.0.0/8\", \"name\": \"b\", \"p\": \"1\"},\n" +
+		"  {\n" +
+		"    \"cidr\": \"172.16.1.1/24\",\n" +
+		"    \"name\": \"w\", \"p\": \"x\"\n" +
+		"  },\n" +
+		"  {\"cidr\": \"172.16.2.0/24\", \"name\": \"v\", \"p\": \"2\"}\n" +
+		"]\n",
+}
+
+func TestValidate(t *testing.T) {
+	dir := writeFiles(t, validateFiles)
+	output, err := runCli(t, "validate", filepath.Join(dir, "feed.csv"), filepath.Join(dir, "feed.tsv"), filepath.Join(dir, "feed.json"), "--priority-keys", "p")
+	assert.Error(t, err)
+	assertGolden(t, "validate.txt", trimDir(output, dir))
+}
+
+func TestValidateFix(t *testing.T) {
+	dir := writeFiles(t, validateFiles)
+	output, err := runCli(t, "validate", filepath.Join(dir, "feed.csv"), filepath.Join(dir, "feed.tsv"), filepath.Join(dir, "feed.json"), "--priority-keys", "p", "--fix")
+	assert.Error(t, err)
+	assertGolden(t, "validate_fix.txt", trimDir(output, dir))
+	assertGolden(t, "validate_fix.csv", readFile(t, filepath.Join(dir, "feed.fixed.csv")))
+	assertGolden(t, "validate_fix.tsv", readFile(t, filepath.Join(dir, "feed.fixed.tsv")))
+	assertGolden(t, "validate_fix.json", readFile(t, filepath.Join(dir, "feed.fixed.json")))
+}
+
+func TestValidateSyntaxError(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"feed.csv":  "cidr,name\n10.0.0.0/8,a\n10.1.0.0/16,\"b\n",
+		"feed.json": "[\n  {\"cidr\": \"11.0.0.0/8\"},\n  {\"cidr\": \"12.0.0.0/8\",\n]\n",
+	})
+	output, err := runCli(t, "validate", filepath.Join(dir, "feed.csv"), filepath.Join(dir, "feed.json"))
+	assert.Error(t, err)
+	assertGolden(t, "validate_syntax.txt", trimDir(output, dir))
+}
@@ -1,175 +1,552 @@
 package cli
 
 import (
+	"bufio"
+	"compress/gzip"
+	"container/list"
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/khalid-nowaf/supernet/pkg/supernet"
 )
 
+// size of the output buffer of each file
+const writeBufferSize = 64 * 1024
+
+// number of files a StreamWriter keeps open by default, when the output is split
+const defaultMaxOpenFiles = 64
+
+// number of records written between two checks of the context, and between two progress reports
+const (
+	cancelCheckInterval = 1024
+	progressInterval    = 1_000_000
+)
+
+// Writer writes the resolved CIDRs of a supernet to output, a file path or - for stdout, it stops when ctx is canceled.
 type Writer interface {
-	Write(super *supernet.Supernet, directory string, cidrKey string, dropKeys []string) error
-	IsIpV6(isIPv6 bool) Writer
+	Write(ctx context.Context, super *supernet.Supernet, output string) error
 }
 
-type JsonWriter struct {
-	splitIpVersions bool
-	IPv6            bool
+// RecordEncoder encodes the resolved CIDRs of one output file, as records of attributes.
+// Begin is called before the first record, and End after the last one, even if there is no record.
+//
+// An encoder which buffers the records can implement Flush() error, it is called before its file is closed
+// to limit the number of open files, the file is reopened later and the next records are appended to it.
+type RecordEncoder interface {
+	Extension() string // extension of the output file, e.g. ".csv"
+	Begin(w io.Writer) error
+	Encode(record map[string]string) error
+	End() error
+}
+
+// StreamWriter pulls the resolved CIDRs one at a time from the supernet, and writes them with an encoder per output file,
+// so the memory used does not depend on the number of CIDRs.
+//
+// The output can be split in several files, its path is a template where {family} is replaced by v4 or v6,
+// and {key} by the value of the SplitKey attribute, with the characters other than letters, digits, '.', '-' and '_' replaced by '_'.
+// At most MaxOpenFiles files are open at once, the least recently written file is closed when another one is needed,
+// and reopened to append to it when a record is written to it again.
+type StreamWriter struct {
+	NewEncoder      func() RecordEncoder
+	SplitIpVersions bool              // write a file per IP version
+	SplitKey        string            // write a file per value of this attribute, if set
+	MaxOpenFiles    int               // files kept open at once, defaultMaxOpenFiles if not positive
+	CidrCol         string            // the attribute updated with the resolved CIDR
+	DropKeys        []string          // attributes not written
+	Renames         map[string]string // attributes written under another key
+	Progress        func(written int)
 	Stats           *Stats
 }
 
-func (w *JsonWriter) IsIpV6(isIpV6 bool) Writer {
-	w.IPv6 = isIpV6
-	return w
+// an output file and its encoder
+type encodedOutput struct {
+	*outputFile
+	encoder RecordEncoder
+	element *list.Element // the element of the output in the list of open outputs, while it is not suspended
 }
 
-func (w JsonWriter) Write(super *supernet.Supernet, directory string, cidrCol string, dropKeys []string) error {
-	// prepare the file name and extension
-	filePath := "resolved"
-	if w.splitIpVersions {
-		if w.IPv6 {
-			filePath += "_v6"
-		} else {
-			filePath += "_v4"
+// flushes the records buffered by the encoder, then suspends the file
+func (out *encodedOutput) suspend() error {
+	if flusher, ok := out.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
 		}
 	}
-	filePath += ".json"
+	return out.outputFile.suspend()
+}
 
-	file, err := os.Create(filePath)
-	if err != nil {
+// writes the CIDRs to the files of the output template, all the files are removed if the writing fails
+func (w *StreamWriter) Write(ctx context.Context, super *supernet.Supernet, output string) (err error) {
+	if err := w.checkOutput(output); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	ipvCidrs := [][]*supernet.CidrTrie{}
-	if w.splitIpVersions {
-		ipvCidrs = [][]*supernet.CidrTrie{super.AllCIDRS(w.IPv6)}
-	} else {
-		ipvCidrs = [][]*supernet.CidrTrie{super.AllCIDRS(false), super.AllCIDRS(true)}
+	maxOpen := w.MaxOpenFiles
+	if maxOpen <= 0 {
+		maxOpen = defaultMaxOpenFiles
 	}
+	outputs := map[string]*encodedOutput{}
+	opened := list.New() // the outputs which are not suspended, the most recently written first
+	defer func() {
+		// the open outputs are closed first, so the suspended ones are reopened one at a time to be ended
+		for _, suspended := range []bool{false, true} {
+			for _, out := range outputs {
+				if out.suspended != suspended {
+					continue
+				}
+				if endErr := out.close(err, out.encoder.End); err == nil {
+					err = endErr
+				}
+			}
+		}
+	}()
+	open := func(path string) (*encodedOutput, error) {
+		out, found := outputs[path]
+		if found && !out.suspended {
+			opened.MoveToFront(out.element)
+			return out, nil
+		}
+		if opened.Len() >= maxOpen {
+			if err := opened.Remove(opened.Back()).(*encodedOutput).suspend(); err != nil {
+				return nil, err
+			}
+		}
+		if found {
+			if err := out.resume(); err != nil {
+				return nil, err
+			}
+			out.element = opened.PushFront(out)
+			return out, nil
+		}
 
-	fmt.Println("Starting to write resolved CIDRs...")
-	if _, err = file.Write([]byte("[")); err != nil {
-		return err
+		file, err := createOutput(path)
+		if err != nil {
+			return nil, err
+		}
+		out = &encodedOutput{outputFile: file, encoder: w.NewEncoder()}
+		outputs[path] = out
+		out.element = opened.PushFront(out)
+		return out, out.encoder.Begin(out)
 	}
-	for _, cidrs := range ipvCidrs {
 
-		for i, cidr := range cidrs {
-			// update the the CIDR after resolve
-			updateAttributes(cidr, cidrCol, dropKeys)
+	// the record is reused, so the metadata of the CIDRs, which may be shared, is never modified
+	record := map[string]string{}
+	for _, forV6 := range []bool{false, true} {
+		familyPath := output
+		if w.SplitIpVersions {
+			familyPath = strings.ReplaceAll(output, "{family}", ipFamily(forV6))
+		}
+		if w.SplitKey == "" {
+			// the file is created even if there is no CIDR
+			if _, err = open(familyPath); err != nil {
+				return err
+			}
+		}
 
-			if i > 0 {
-				if _, err = file.Write([]byte(",")); err != nil {
-					return err
-				}
+		for it := super.Cidrs(forV6); it.Next(); {
+			path := familyPath
+			if w.SplitKey != "" {
+				path = strings.ReplaceAll(path, "{key}", fileNamePart(it.Metadata().Attributes[w.SplitKey]))
+			}
+			out, err := open(path)
+			if err != nil {
+				return err
 			}
-			if err = encoder.Encode(cidr.Metadata().Attributes); err != nil {
+			fillRecord(record, it.Metadata().Attributes, w.CidrCol, it.Prefix().String(), w.DropKeys, w.Renames)
+			if err = out.encoder.Encode(record); err != nil {
 				return err
 			}
+
 			w.Stats.Output++
+			if w.Stats.Output%cancelCheckInterval == 0 {
+				if err = ctx.Err(); err != nil {
+					return err
+				}
+			}
+			if w.Progress != nil && w.Stats.Output%progressInterval == 0 {
+				w.Progress(w.Stats.Output)
+			}
 		}
 	}
-	if _, err = file.Write([]byte("]")); err != nil {
-		return err
+	return nil
+}
+
+// checks that the output template has the placeholders of the splits, and only them
+func (w *StreamWriter) checkOutput(output string) error {
+	split := w.SplitIpVersions || w.SplitKey != ""
+	switch {
+	case output == "-" && split:
+		return errors.New("the output can not be split when it is written to stdout")
+	case w.SplitIpVersions != strings.Contains(output, "{family}"):
+		return fmt.Errorf("the output %s must contain {family} if and only if the IP versions are split", output)
+	case (w.SplitKey != "") != strings.Contains(output, "{key}"):
+		return fmt.Errorf("the output %s must contain {key} if and only if the output is split by key", output)
 	}
-	fmt.Println("Writing complete.")
 	return nil
 }
 
-type CsvWriter struct {
-	isTSV           bool
-	splitIpVersions bool
-	IPv6            bool
-	Stats           *Stats
+func ipFamily(isV6 bool) string {
+	if isV6 {
+		return "v6"
+	}
+	return "v4"
 }
 
-func (w *CsvWriter) IsIpV6(isIpV6 bool) Writer {
-	w.IPv6 = isIpV6
-	return w
+// returns the value usable in a file name, an empty value is replaced by _
+func fileNamePart(value string) string {
+	if value == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, value)
 }
 
-// writeResults writes the results of CIDR resolution to a CSV file.
-func (w CsvWriter) Write(super *supernet.Supernet, directory string, cidrCol string, dropKeys []string) error {
+// outputFile is a created file, or stdout for the path -, compressed with gzip if its name ends in .gz.
+type outputFile struct {
+	path      string
+	file      *os.File // nil for stdout, and while the file is suspended
+	gzip      *gzip.Writer
+	buffer    *bufio.Writer
+	suspended bool
+}
 
-	// prepare the file name and extension
-	filePath := "resolved"
-	separator := ','
-	if w.splitIpVersions {
-		if w.IPv6 {
-			filePath += "_v6"
-		} else {
-			filePath += "_v4"
+func createOutput(path string) (*outputFile, error) {
+	out := &outputFile{path: path}
+	if err := out.open(os.O_CREATE | os.O_TRUNC); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// opens the file for writing with the flag, e.g. os.O_APPEND
+func (f *outputFile) open(flag int) error {
+	var w io.Writer = os.Stdout
+	if f.path != "-" {
+		file, err := os.OpenFile(f.path, os.O_WRONLY|flag, 0o666)
+		if err != nil {
+			return err
 		}
+		f.file, w = file, file
 	}
-	if w.isTSV {
-		filePath += ".tsv"
-		separator = '\t'
-	} else {
-		filePath += ".csv"
+	if strings.HasSuffix(f.path, ".gz") {
+		f.gzip = gzip.NewWriter(w)
+		w = f.gzip
 	}
+	f.buffer = bufio.NewWriterSize(w, writeBufferSize)
+	f.suspended = false
+	return nil
+}
 
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
+// flushes and closes the file, and releases its buffers, until resume reopens it to append to it.
+// A compressed file is continued in a new gzip member, which the gzip readers read as a single stream.
+func (f *outputFile) suspend() error {
+	err := f.buffer.Flush()
+	if f.gzip != nil && err == nil {
+		err = f.gzip.Close()
 	}
-	defer file.Close()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file, f.gzip, f.buffer, f.suspended = nil, nil, nil, true
+	return err
+}
 
-	// Create a CSV writer
-	writer := csv.NewWriter(file)
-	writer.Comma = separator
-	defer writer.Flush()
+func (f *outputFile) resume() error {
+	return f.open(os.O_APPEND)
+}
+
+func (f *outputFile) Write(p []byte) (int, error) {
+	return f.buffer.Write(p)
+}
 
-	ipvCidrs := [][]*supernet.CidrTrie{}
-	if w.splitIpVersions {
-		ipvCidrs = [][]*supernet.CidrTrie{super.AllCIDRS(w.IPv6)}
-	} else {
-		ipvCidrs = [][]*supernet.CidrTrie{super.AllCIDRS(false), super.AllCIDRS(true)}
+// calls end and flushes the output if err is nil, then closes it, the file is removed if err, or the closing, failed
+func (f *outputFile) close(err error, end func() error) error {
+	if f.suspended && err == nil {
+		// the file is reopened to be ended
+		err = f.resume()
 	}
+	if f.suspended {
+		os.Remove(f.path)
+		return err
+	}
+	if err == nil && end != nil {
+		err = end()
+	}
+	if err == nil {
+		err = f.buffer.Flush()
+	}
+	if f.gzip != nil && err == nil {
+		err = f.gzip.Close()
+	}
+	if f.file == nil {
+		return err
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.path)
+	}
+	return err
+}
+
+// returns the format of the flag, or if it is auto, of the extension of the output without .gz, e.g. csv for resolved.csv.gz
+func outputFormat(flag string, output string) string {
+	if flag != "auto" {
+		return flag
+	}
+	return strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(output, ".gz")), ".")
+}
 
-	fmt.Println("Starting to write resolved CIDRs...")
+// replaces the content of record with the attributes and the CIDR in the cidrCol key,
+// without the dropKeys, and with the keys in renames replaced by their new name
+func fillRecord(record map[string]string, attributes map[string]string, cidrCol string, cidr string, dropKeys []string, renames map[string]string) {
+	clear(record)
+	copyAttributes(record, attributes, dropKeys, renames)
+	if !contains(dropKeys, cidrCol) {
+		record[renamed(cidrCol, renames)] = cidr
+	}
+}
 
-	// Optional: Write headers to the CSV file
-	headers := []string{}
-	for key := range ipvCidrs[0][0].Metadata().Attributes {
+// copies the attributes to record, without the dropKeys, and with the keys in renames replaced by their new name
+func copyAttributes(record map[string]string, attributes map[string]string, dropKeys []string, renames map[string]string) {
+	for key, value := range attributes {
 		if !contains(dropKeys, key) {
-			headers = append(headers, key)
+			record[renamed(key, renames)] = value
 		}
 	}
-	if err := writer.Write(headers); err != nil {
-		return err
+}
+
+func renamed(key string, renames map[string]string) string {
+	if newKey, found := renames[key]; found {
+		return newKey
 	}
+	return key
+}
 
-	// Write data to the CSV file
-	for _, cidrs := range ipvCidrs {
+// JsonEncoder writes the records as a JSON array of objects.
+type JsonEncoder struct {
+	w       io.Writer
+	encoder *json.Encoder
+	count   int
+}
 
-		for _, cidr := range cidrs {
-			// update the the CIDR after resolve
-			updateAttributes(cidr, cidrCol, dropKeys)
-			record := make([]string, 0, len(cidr.Metadata().Attributes))
-			// Ensure the fields are written in the same order as headers
-			for _, header := range headers {
-				record = append(record, cidr.Metadata().Attributes[header])
-			}
-			if err := writer.Write(record); err != nil {
-				return err
-			}
-			w.Stats.Output++
+func (e *JsonEncoder) Extension() string {
+	return ".json"
+}
+
+func (e *JsonEncoder) Begin(w io.Writer) error {
+	e.w, e.encoder, e.count = w, json.NewEncoder(w), 0
+	_, err := io.WriteString(w, "[")
+	return err
+}
+
+func (e *JsonEncoder) Encode(record map[string]string) error {
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
 		}
 	}
+	e.count++
+	return e.encoder.Encode(record)
+}
+
+func (e *JsonEncoder) End() error {
+	_, err := io.WriteString(e.w, "]")
+	return err
+}
+
+// CsvEncoder writes the records as CSV or TSV rows, the header is the sorted keys of the first record.
+type CsvEncoder struct {
+	isTSV   bool
+	writer  *csv.Writer
+	headers []string
+	row     []string
+}
+
+func (e *CsvEncoder) Extension() string {
+	if e.isTSV {
+		return ".tsv"
+	}
+	return ".csv"
+}
 
-	fmt.Println("Writing complete.")
+func (e *CsvEncoder) Begin(w io.Writer) error {
+	e.writer, e.headers = csv.NewWriter(w), nil
+	if e.isTSV {
+		e.writer.Comma = '\t'
+	}
 	return nil
 }
 
-func updateAttributes(cidr *supernet.CidrTrie, cidrCol string, dropKeys []string) {
-	// update the the CIDR after resolve
-	cidr.Metadata().Attributes[cidrCol] = supernet.NodeToCidr(cidr)
-	// drop the unwanted keys
-	for _, keyToDrop := range dropKeys {
-		delete(cidr.Metadata().Attributes, keyToDrop)
+func (e *CsvEncoder) Encode(record map[string]string) error {
+	if e.headers == nil {
+		for key := range record {
+			e.headers = append(e.headers, key)
+		}
+		sort.Strings(e.headers)
+		if err := e.writer.Write(e.headers); err != nil {
+			return err
+		}
+	}
+
+	// Ensure the fields are written in the same order as headers
+	e.row = e.row[:0]
+	for _, header := range e.headers {
+		e.row = append(e.row, record[header])
+	}
+	return e.writer.Write(e.row)
+}
+
+// writes the buffered rows
+func (e *CsvEncoder) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func (e *CsvEncoder) End() error {
+	return e.Flush()
+}
+
+// TextEncoder writes the CIDR of each record on its own line, the other attributes are dropped.
+type TextEncoder struct {
+	CidrKey string // the key of the CIDR in the records, after it is renamed
+	w       io.Writer
+}
+
+func (e *TextEncoder) Extension() string {
+	return ".txt"
+}
+
+func (e *TextEncoder) Begin(w io.Writer) error {
+	e.w = w
+	return nil
+}
+
+func (e *TextEncoder) Encode(record map[string]string) error {
+	_, err := io.WriteString(e.w, record[e.CidrKey]+"\n")
+	return err
+}
+
+func (e *TextEncoder) End() error {
+	return nil
+}
+
+// DbWriter saves the resolved supernet in the binary format of supernet.Save,
+// so it can be loaded again without parsing and resolving the input files.
+type DbWriter struct {
+	DropKeys []string
+	Renames  map[string]string
+	Stats    *Stats
+}
+
+// both IP versions are always saved in the same file, and the CIDR column is not updated, since the CIDR is stored in the file.
+//
+// The dropped and renamed keys are applied to a copy of the attributes of each CIDR, while it is saved,
+// so the supernet is not modified and can still be written, or searched, with its original attributes.
+func (w *DbWriter) Write(ctx context.Context, super *supernet.Supernet, output string) (err error) {
+	if strings.ContainsAny(output, "{}") {
+		return fmt.Errorf("the db output %s can not be split, both IP versions are saved in the same file", output)
+	}
+	var mapAttributes func(attributes map[string]string) map[string]string
+	if len(w.DropKeys) > 0 || len(w.Renames) > 0 {
+		mapAttributes = func(attributes map[string]string) map[string]string {
+			mapped := make(map[string]string, len(attributes))
+			copyAttributes(mapped, attributes, w.DropKeys, w.Renames)
+			return mapped
+		}
+	}
+	for _, forV6 := range []bool{false, true} {
+		for it := super.Cidrs(forV6); it.Next(); {
+			w.Stats.Output++
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	out, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	return out.close(super.SaveMapped(out, mapAttributes), nil)
+}
+
+// returns a Writer for the --output-format of the command, and the output template, see StreamWriter
+func newWriter(cmd *ResolveCmd) (Writer, string, error) {
+	format := outputFormat(cmd.OutputFormat, cmd.Output)
+	if format == "" {
+		format = "csv"
+	}
+	output := cmd.Output
+	if output == "" {
+		output = "resolved"
+		if cmd.SplitIpVersions {
+			output += "_{family}"
+		}
+		if cmd.SplitKey != "" {
+			output += "_{key}"
+		}
+		output += "." + format
+	}
+
+	if format == "db" {
+		if cmd.SplitIpVersions || cmd.SplitKey != "" {
+			return nil, "", fmt.Errorf("--split-ip-versions and --split-key are not supported with --output-format db, all the CIDRs are saved in the same file")
+		}
+		return &DbWriter{DropKeys: cmd.DropKeys, Renames: cmd.Rename, Stats: &cmd.Stats}, output, nil
+	}
+	if format == "txt" && contains(cmd.DropKeys, cmd.CidrKey) {
+		return nil, "", fmt.Errorf("the CIDR key %s can not be dropped with --output-format txt, only the CIDRs are written", cmd.CidrKey)
+	}
+	// the records have the renamed keys
+	cidrKey := renamed(cmd.CidrKey, cmd.Rename)
+	if _, err := newEncoder(format, cidrKey); err != nil {
+		return nil, "", err
+	}
+	stream := &StreamWriter{
+		NewEncoder: func() RecordEncoder {
+			encoder, _ := newEncoder(format, cidrKey)
+			return encoder
+		},
+		SplitIpVersions: cmd.SplitIpVersions,
+		SplitKey:        cmd.SplitKey,
+		CidrCol:         cmd.CidrKey,
+		DropKeys:        cmd.DropKeys,
+		Renames:         cmd.Rename,
+		Progress: func(written int) {
+			fmt.Fprintf(cmd.messages, "%d resolved CIDRs written...\n", written)
+		},
+		Stats: &cmd.Stats,
+	}
+	return stream, output, stream.checkOutput(output)
+}
+
+// returns the encoder of an output format, the text format writes only the attribute cidrKey
+func newEncoder(format string, cidrKey string) (RecordEncoder, error) {
+	switch format {
+	case "csv":
+		return &CsvEncoder{}, nil
+	case "tsv":
+		return &CsvEncoder{isTSV: true}, nil
+	case "json":
+		return &JsonEncoder{}, nil
+	case "txt":
+		return &TextEncoder{CidrKey: cidrKey}, nil
+	default:
+		return nil, fmt.Errorf("output format %s is not supported, please uses one of the following: [json,csv,tsv,txt,db]", format)
 	}
 }
 
@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDbWriterKeepsSupernetAttributes(t *testing.T) {
+	store := supernet.NewAttributeStore()
+	super := supernet.WithAttributeStore(store)(supernet.NewSupernet())
+	for _, cidr := range []string{"10.0.0.0/8", "11.0.0.0/8"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		super.InsertCidr(ipnet, &supernet.Metadata{Attributes: map[string]string{"name": "sa", "p": "1"}})
+	}
+	_, shared := store.Intern(map[string]string{"name": "sa", "p": "1"})
+
+	path := filepath.Join(t.TempDir(), "out.db")
+	writer := &DbWriter{DropKeys: []string{"p"}, Renames: map[string]string{"name": "country"}, Stats: &Stats{}}
+	assert.NoError(t, writer.Write(context.Background(), super, path))
+	assert.Equal(t, map[string]string{"name": "sa", "p": "1"}, shared)
+	assert.Equal(t, 2, writer.Stats.Output)
+	// the supernet keeps its attributes, the keys are only dropped and renamed in the file
+	super.ForEachCidr(false, func(cidr netip.Prefix, metadata *supernet.Metadata) bool {
+		assert.Equal(t, map[string]string{"name": "sa", "p": "1"}, metadata.Attributes, cidr.String())
+		return true
+	})
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	loaded, err := supernet.Load(file)
+	assert.NoError(t, err)
+	for _, addr := range []string{"10.0.0.0", "11.0.0.0"} {
+		_, metadata, found := loaded.LookupAddr(netip.MustParseAddr(addr))
+		assert.True(t, found, addr)
+		assert.Equal(t, map[string]string{"country": "sa"}, metadata.Attributes, addr)
+	}
+}
+
+func TestResolveInternedAttributes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"in.csv": "cidr,name,p\n10.0.0.0/8,sa,1\n11.0.0.0/8,sa,1\n10.1.0.0/16,us,2\n"})
+	output := filepath.Join(dir, "out.csv")
+	for _, bulk := range [][]string{{}, {"--bulk"}} {
+		args := []string{"resolve", filepath.Join(dir, "in.csv"), "--priority-keys", "p", "--intern-attributes", "--rename", "name=country", "-o", output}
+		_, err := runCli(t, append(args, bulk...)...)
+		assert.NoError(t, err, bulk)
+		assertGolden(t, "resolve_interned.csv", readFile(t, output))
+	}
+}
+
+var writerFiles = map[string]string{
+	"feed.csv": "cidr,country\n" +
+		"10.0.0.0/16,SA\n" +
+		"10.1.0.0/16,US\n" +
+		"10.2.0.0/16,\n" +
+		"2001:db8::/32,S/A\n",
+}
+
+func TestResolveOutputTemplate(t *testing.T) {
+	dir := writeFiles(t, writerFiles)
+	output := filepath.Join(dir, "out", "{family}-{key}.csv.gz")
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "out"), 0o755))
+	_, err := runCli(t, "resolve", filepath.Join(dir, "feed.csv"), "--split-ip-versions", "--split-key", "country", "-o", output)
+	assert.NoError(t, err)
+
+	written := strings.Builder{}
+	paths, _ := filepath.Glob(filepath.Join(dir, "out", "*"))
+	for _, path := range paths {
+		file, err := os.Open(path)
+		assert.NoError(t, err)
+		reader, err := gzip.NewReader(file)
+		assert.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		file.Close()
+		fmt.Fprintf(&written, "== %s\n%s", filepath.Base(path), content)
+	}
+	assertGolden(t, "resolve_split.txt", written.String())
+}
+
+func TestResolveOutputStdout(t *testing.T) {
+	dir := writeFiles(t, writerFiles)
+	output, err := runCli(t, "resolve", filepath.Join(dir, "feed.csv"), "-o", "-", "--output-format", "json")
+	assert.NoError(t, err)
+	assertGolden(t, "resolve_stdout.json", output)
+
+	output, err = runCli(t, "resolve", filepath.Join(dir, "feed.csv"), "-o", "-", "--output-format", "txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/16\n10.1.0.0/16\n10.2.0.0/16\n2001:db8::/32\n", output)
+
+	// the txt format writes the CIDR under its renamed key, and can not drop it
+	output, err = runCli(t, "resolve", filepath.Join(dir, "feed.csv"), "-o", "-", "--output-format", "txt", "--rename", "cidr=range")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/16\n10.1.0.0/16\n10.2.0.0/16\n2001:db8::/32\n", output)
+	_, err = runCli(t, "resolve", filepath.Join(dir, "feed.csv"), "-o", "-", "--output-format", "txt", "--drop-keys", "cidr")
+	assert.EqualError(t, err, "the CIDR key cidr can not be dropped with --output-format txt, only the CIDRs are written")
+}
+
+func TestResolveOutputErrors(t *testing.T) {
+	dir := writeFiles(t, writerFiles)
+	feed := filepath.Join(dir, "feed.csv")
+	for args, message := range map[[3]string]string{
+		{"-o", "-", "--split-ip-versions"}:                             "can not be split when it is written to stdout",
+		{"-o", filepath.Join(dir, "out.csv"), "--split-ip-versions"}:   "must contain {family}",
+		{"-o", filepath.Join(dir, "{family}.csv"), "--bulk"}:           "must contain {family}",
+		{"-o", filepath.Join(dir, "{key}.csv"), "--split-ip-versions"}: "must contain {family}",
+		{"-o", filepath.Join(dir, "out.db"), "--split-ip-versions"}:    "not supported with --output-format db",
+		{"-o", filepath.Join(dir, "out.xml"), "--bulk"}:                "output format xml is not supported",
+	} {
+		_, err := runCli(t, "resolve", feed, args[0], args[1], args[2])
+		assert.ErrorContains(t, err, message, args)
+	}
+}
+
+func TestStreamWriterRemovesFilesOnError(t *testing.T) {
+	super := supernet.NewSupernet()
+	for i := 0; i < 2*cancelCheckInterval; i++ {
+		super.InsertCidr(&net.IPNet{IP: net.IPv4(10, byte(i>>8), byte(i), 0), Mask: net.CIDRMask(24, 32)}, nil)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	output := filepath.Join(t.TempDir(), "out_{family}.csv.gz")
+	writer := &StreamWriter{NewEncoder: func() RecordEncoder { return &CsvEncoder{} }, SplitIpVersions: true, CidrCol: "cidr", Stats: &Stats{}}
+	assert.ErrorIs(t, writer.Write(ctx, super, output), context.Canceled)
+
+	paths, _ := filepath.Glob(filepath.Join(filepath.Dir(output), "*"))
+	assert.Empty(t, paths)
+}
+
+func TestStreamWriterLimitsOpenFiles(t *testing.T) {
+	const keys, cidrs = 5, 2000
+	super := supernet.NewSupernet()
+	for i := 0; i < cidrs; i++ {
+		// the keys alternate, so each file is closed and reopened many times
+		ipnet := &net.IPNet{IP: net.IPv4(10, byte(i>>8), byte(i), 0), Mask: net.CIDRMask(24, 32)}
+		metadata := supernet.NewMetadata(ipnet)
+		metadata.Attributes["key"] = fmt.Sprint(i % keys)
+		super.InsertCidr(ipnet, metadata)
+	}
+
+	for _, extension := range []string{".csv.gz", ".json"} {
+		dir := t.TempDir()
+		writer := &StreamWriter{SplitKey: "key", MaxOpenFiles: 2, CidrCol: "cidr", Stats: &Stats{}}
+		writer.NewEncoder = func() RecordEncoder {
+			encoder, _ := newEncoder(outputFormat("auto", extension), "cidr")
+			return encoder
+		}
+		assert.NoError(t, writer.Write(context.Background(), super, filepath.Join(dir, "{key}"+extension)))
+
+		for key := 0; key < keys; key++ {
+			path := filepath.Join(dir, fmt.Sprint(key)+extension)
+			if extension == ".json" {
+				records := []map[string]string{}
+				assert.NoError(t, json.Unmarshal([]byte(readFile(t, path)), &records))
+				assert.Len(t, records, cidrs/keys)
+				continue
+			}
+			file, err := os.Open(path)
+			assert.NoError(t, err)
+			// the file has a gzip member each time it was reopened, they are read as one stream
+			reader, err := gzip.NewReader(file)
+			assert.NoError(t, err)
+			rows, err := csv.NewReader(reader).ReadAll()
+			assert.NoError(t, err)
+			file.Close()
+			assert.Len(t, rows, cidrs/keys+1)
+			assert.Equal(t, []string{"cidr", "key"}, rows[0])
+		}
+	}
+}
+
+func TestFileNamePart(t *testing.T) {
+	for value, expected := range map[string]string{"": "_", "SA": "SA", "S/A": "S_A", "a b.c-d_e": "a_b.c-d_e", "مصر": "___"} {
+		assert.Equal(t, expected, fileNamePart(value), value)
+	}
+}
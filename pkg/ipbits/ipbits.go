@@ -0,0 +1,203 @@
+// Package ipbits packs IP addresses into fixed size keys, so their bits can be extracted
+// without converting them to slices of bits.
+//
+// IPv4 and IPv6 addresses share the same 128 bits Key, an IPv4 address is stored in
+// the 32 most significant bits, so the bit i of a key is the bit i of the address in both families.
+//
+// ## Example usage:
+//
+//	path := ipbits.PathFromPrefix(netip.MustParsePrefix("10.0.0.0/8"))
+//	for depth := 0; depth < path.Len; depth++ {
+//		fmt.Print(path.Key.Bit(depth)) // 00001010
+//	}
+package ipbits
+
+import (
+	"math/bits"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// maximum number of bits in an IPv4 and IPv6 address
+const (
+	IPv4Len = 32
+	IPv6Len = 128
+)
+
+// Key is a packed 128 bits IP address, Hi holds the 64 most significant bits.
+type Key struct {
+	Hi uint64
+	Lo uint64
+}
+
+// Path is the first Len bits of a Key, which is how a CIDR is walked in a trie.
+type Path struct {
+	Key Key
+	Len int
+}
+
+// returns the number of bits of an IPv4 or IPv6 address
+func MaxLen(isV6 bool) int {
+	if isV6 {
+		return IPv6Len
+	}
+	return IPv4Len
+}
+
+// FromAddr packs an IP address into a Key, IPv4-mapped IPv6 addresses are packed as IPv6.
+func FromAddr(addr netip.Addr) Key {
+	if addr.Is4() {
+		a4 := addr.As4()
+		return Key{Hi: uint64(a4[0])<<56 | uint64(a4[1])<<48 | uint64(a4[2])<<40 | uint64(a4[3])<<32}
+	}
+	a16 := addr.As16()
+	return Key{Hi: beUint64(a16[:8]), Lo: beUint64(a16[8:])}
+}
+
+// FromIP packs a net.IP into a Key, 4 bytes and IPv4-mapped 16 bytes addresses are packed as IPv4.
+func FromIP(ip net.IP) Key {
+	if ip4 := ip.To4(); ip4 != nil {
+		return Key{Hi: uint64(ip4[0])<<56 | uint64(ip4[1])<<48 | uint64(ip4[2])<<40 | uint64(ip4[3])<<32}
+	}
+	return Key{Hi: beUint64(ip[:8]), Lo: beUint64(ip[8:16])}
+}
+
+// PathFromPrefix returns the path of a CIDR, the host bits are cleared.
+func PathFromPrefix(prefix netip.Prefix) Path {
+	return Path{Key: FromAddr(prefix.Addr()).Masked(prefix.Bits()), Len: prefix.Bits()}
+}
+
+// PathFromIPNet returns the path of a CIDR, the host bits are cleared.
+func PathFromIPNet(ipnet *net.IPNet) Path {
+	ones, _ := ipnet.Mask.Size()
+	return Path{Key: FromIP(ipnet.IP).Masked(ones), Len: ones}
+}
+
+// returns the bit (0 or 1) at position i, 0 is the most significant bit.
+func (k Key) Bit(i int) int {
+	if i < 64 {
+		return int(k.Hi>>(63-i)) & 1
+	}
+	return int(k.Lo>>(127-i)) & 1
+}
+
+// returns the byte i of the key, the byte 0 holds the 8 most significant bits.
+func (k Key) Byte(i int) uint8 {
+	if i < 8 {
+		return uint8(k.Hi >> (56 - 8*i))
+	}
+	return uint8(k.Lo >> (56 - 8*(i-8)))
+}
+
+// returns a copy of the key with the bit at position i set to bit (0 or 1).
+func (k Key) WithBit(i int, bit int) Key {
+	if i < 64 {
+		mask := uint64(1) << (63 - i)
+		k.Hi = k.Hi&^mask | uint64(bit)<<(63-i)
+	} else {
+		mask := uint64(1) << (127 - i)
+		k.Lo = k.Lo&^mask | uint64(bit)<<(127-i)
+	}
+	return k
+}
+
+// returns a copy of the key with only the first n bits kept, the rest are cleared.
+func (k Key) Masked(n int) Key {
+	hi, lo := mask(n)
+	return Key{Hi: k.Hi & hi, Lo: k.Lo & lo}
+}
+
+// returns a copy of the key with the bits after the first n bits set, the last address of the prefix of length n.
+func (k Key) Last(n int) Key {
+	hi, lo := mask(n)
+	return Key{Hi: k.Hi | ^hi, Lo: k.Lo | ^lo}
+}
+
+// returns the number of leading bits shared by both keys.
+func (k Key) CommonPrefixLen(other Key) int {
+	if diff := k.Hi ^ other.Hi; diff != 0 {
+		return bits.LeadingZeros64(diff)
+	}
+	return 64 + bits.LeadingZeros64(k.Lo^other.Lo)
+}
+
+// Compare returns -1, 0 or +1, depending on whether k is less than, equal to or greater than other.
+func (k Key) Compare(other Key) int {
+	switch {
+	case k.Hi < other.Hi:
+		return -1
+	case k.Hi > other.Hi:
+		return 1
+	case k.Lo < other.Lo:
+		return -1
+	case k.Lo > other.Lo:
+		return 1
+	}
+	return 0
+}
+
+// Addr unpacks the key into an IPv4 or IPv6 address.
+func (k Key) Addr(isV6 bool) netip.Addr {
+	if !isV6 {
+		return netip.AddrFrom4([4]byte{byte(k.Hi >> 56), byte(k.Hi >> 48), byte(k.Hi >> 40), byte(k.Hi >> 32)})
+	}
+	var a16 [16]byte
+	putBeUint64(a16[:8], k.Hi)
+	putBeUint64(a16[8:], k.Lo)
+	return netip.AddrFrom16(a16)
+}
+
+// Prefix unpacks the path into an IPv4 or IPv6 CIDR.
+func (p Path) Prefix(isV6 bool) netip.Prefix {
+	return netip.PrefixFrom(p.Key.Addr(isV6), p.Len)
+}
+
+// returns the first bit of the path that is not in the parent path, it panics on empty paths.
+func (p Path) LastBit() int {
+	if p.Len == 0 {
+		panic("[BUG] Path.LastBit: empty path has no bits")
+	}
+	return p.Key.Bit(p.Len - 1)
+}
+
+// Contains reports whether other is equal to, or a sub path of p.
+func (p Path) Contains(other Path) bool {
+	return other.Len >= p.Len && other.Key.Masked(p.Len) == p.Key
+}
+
+// String returns the bits of the path, like "00001010" for 10.0.0.0/8.
+func (p Path) String() string {
+	var sb strings.Builder
+	sb.Grow(p.Len)
+	for depth := 0; depth < p.Len; depth++ {
+		sb.WriteByte('0' + byte(p.Key.Bit(depth)))
+	}
+	return sb.String()
+}
+
+// returns the hi and lo masks of the first n bits
+func mask(n int) (uint64, uint64) {
+	switch {
+	case n <= 0:
+		return 0, 0
+	case n < 64:
+		return ^uint64(0) << (64 - n), 0
+	case n == 64:
+		return ^uint64(0), 0
+	case n < 128:
+		return ^uint64(0), ^uint64(0) << (128 - n)
+	}
+	return ^uint64(0), ^uint64(0)
+}
+
+func beUint64(b []byte) uint64 {
+	return uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 |
+		uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7])
+}
+
+func putBeUint64(b []byte, v uint64) {
+	for i := 0; i < 8; i++ {
+		b[i] = byte(v >> (56 - 8*i))
+	}
+}
@@ -0,0 +1,106 @@
+package ipbits
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBits(t *testing.T) {
+	testCases := []struct {
+		cidr         string
+		expectedBits []int
+	}{
+		{"1.1.1.1/8", []int{0, 0, 0, 0, 0, 0, 0, 1}},
+		{"3.1.1.1/8", []int{0, 0, 0, 0, 0, 0, 1, 1}},
+		{"2001:db8::ff00:42:8329/16", []int{0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{"::1/128", append(make([]int, 127), 1)},
+	}
+
+	for _, tc := range testCases {
+		path := PathFromPrefix(netip.MustParsePrefix(tc.cidr))
+		bits := []int{}
+		for i := 0; i < path.Len; i++ {
+			bits = append(bits, path.Key.Bit(i))
+		}
+		assert.Equal(t, tc.expectedBits, bits, tc.cidr)
+	}
+}
+
+func TestPathRoundTrip(t *testing.T) {
+	testCases := []struct {
+		cidr     string
+		expected string
+		isV6     bool
+	}{
+		{"1.1.1.1/8", "1.0.0.0/8", false},
+		{"192.168.1.0/24", "192.168.1.0/24", false},
+		{"255.255.255.255/32", "255.255.255.255/32", false},
+		{"2001:db8::ff00:42:8329/16", "2001::/16", true},
+		{"2001:db8::ff00:42:8329/96", "2001:db8::ff00:0:0/96", true},
+	}
+
+	for _, tc := range testCases {
+		_, ipnet, _ := net.ParseCIDR(tc.cidr)
+		assert.Equal(t, tc.expected, PathFromIPNet(ipnet).Prefix(tc.isV6).String())
+		assert.Equal(t, tc.expected, PathFromPrefix(netip.MustParsePrefix(tc.cidr)).Prefix(tc.isV6).String())
+	}
+}
+
+func TestWithBit(t *testing.T) {
+	key := Key{}
+	for _, i := range []int{0, 31, 63, 64, 127} {
+		key = key.WithBit(i, 1)
+		assert.Equal(t, 1, key.Bit(i))
+		key = key.WithBit(i, 0)
+		assert.Equal(t, Key{}, key)
+	}
+}
+
+func TestByte(t *testing.T) {
+	key := FromAddr(netip.MustParseAddr("2001:db8::ff00:42:8329"))
+	assert.Equal(t, uint8(0x20), key.Byte(0))
+	assert.Equal(t, uint8(0x0d), key.Byte(2))
+	assert.Equal(t, uint8(0xff), key.Byte(10))
+	assert.Equal(t, uint8(0x29), key.Byte(15))
+
+	key = FromAddr(netip.MustParseAddr("10.1.2.3"))
+	assert.Equal(t, uint8(3), key.Byte(3))
+	assert.Equal(t, uint8(0), key.Byte(4))
+}
+
+func TestMaskedAndLast(t *testing.T) {
+	key := FromAddr(netip.MustParseAddr("2001:db8::ff00:42:8329"))
+	assert.Equal(t, "2001:db8::", key.Masked(32).Addr(true).String())
+	assert.Equal(t, "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", key.Masked(32).Last(32).Addr(true).String())
+	assert.Equal(t, "2001:db8::ff00:0:0", key.Masked(96).Addr(true).String())
+	assert.Equal(t, key, key.Masked(128))
+
+	key = FromAddr(netip.MustParseAddr("10.1.2.3"))
+	assert.Equal(t, "10.0.0.0", key.Masked(8).Addr(false).String())
+	assert.Equal(t, "10.255.255.255", key.Masked(8).Last(8).Addr(false).String())
+}
+
+func TestCommonPrefixLenAndContains(t *testing.T) {
+	a := PathFromPrefix(netip.MustParsePrefix("10.0.0.0/8"))
+	b := PathFromPrefix(netip.MustParsePrefix("10.128.0.0/9"))
+	c := PathFromPrefix(netip.MustParsePrefix("11.0.0.0/8"))
+
+	assert.Equal(t, 8, a.Key.CommonPrefixLen(b.Key))
+	assert.Equal(t, 7, a.Key.CommonPrefixLen(c.Key))
+	assert.Equal(t, 128, a.Key.CommonPrefixLen(a.Key))
+	assert.True(t, a.Contains(b))
+	assert.False(t, b.Contains(a))
+	assert.False(t, a.Contains(c))
+	assert.Equal(t, -1, a.Key.Compare(b.Key))
+	assert.Equal(t, 1, c.Key.Compare(b.Key))
+	assert.Equal(t, 0, a.Key.Compare(a.Key))
+}
+
+func TestPathString(t *testing.T) {
+	assert.Equal(t, "00001010", PathFromPrefix(netip.MustParsePrefix("10.0.0.0/8")).String())
+	assert.Equal(t, "0010000000000001", PathFromPrefix(netip.MustParsePrefix("2001:db8::/16")).String())
+	assert.Equal(t, "", Path{}.String())
+}
@@ -0,0 +1,245 @@
+// Package server serves the lookups of a resolved supernet dataset over HTTP.
+//
+// The endpoints are:
+//   - GET /lookup/{ip}: the resolved CIDR containing ip, and its attributes.
+//   - POST /lookup: a JSON array of IPs, looked up on the same version of the dataset, the results are in the same order.
+//   - GET /prefix/{cidr}: the resolved CIDR containing cidr, or the resolved CIDRs within it, up to the limit query parameter.
+//   - GET /healthz: 200 while the server is running.
+//   - GET /readyz: 200 once a dataset is loaded, 503 otherwise.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+)
+
+const (
+	// DefaultMaxBatchSize is the maximum number of IPs in a POST /lookup request.
+	DefaultMaxBatchSize = 10_000
+	// DefaultPrefixLimit is the number of CIDRs returned by GET /prefix/{cidr} without a limit parameter.
+	DefaultPrefixLimit = 1000
+	// size of an IP in a JSON array, used to bound the body of POST /lookup
+	maxIPJsonSize = 48
+)
+
+// Server is an http.Handler answering the lookups from the dataset of a supernet.Handle,
+// so the dataset can be reloaded while serving.
+type Server struct {
+	handle       *supernet.Handle
+	mux          *http.ServeMux
+	maxBatchSize int
+	prefixLimit  int
+	cidrKey      string // the attribute holding the CIDR of the original record, which is not returned
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithMaxBatchSize sets the maximum number of IPs in a POST /lookup request.
+func WithMaxBatchSize(size int) Option {
+	return func(s *Server) {
+		s.maxBatchSize = size
+	}
+}
+
+// WithPrefixLimit sets the number of CIDRs returned by GET /prefix/{cidr} without a limit parameter.
+func WithPrefixLimit(limit int) Option {
+	return func(s *Server) {
+		s.prefixLimit = limit
+	}
+}
+
+// WithCidrKey removes the attribute key from the results, it holds the CIDR of the input record,
+// which is not the resolved CIDR returned with the attributes if the record was split.
+func WithCidrKey(key string) Option {
+	return func(s *Server) {
+		s.cidrKey = key
+	}
+}
+
+// LookupResult is the JSON result of a lookup, CIDR and Attributes are omitted if no resolved CIDR contains the IP.
+type LookupResult struct {
+	IP         string            `json:"ip"`
+	CIDR       string            `json:"cidr,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"` // the IP is invalid, only set in batch results
+}
+
+// CidrResult is a resolved CIDR of a prefix query.
+type CidrResult struct {
+	CIDR       string            `json:"cidr"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// PrefixResult is the JSON result of a prefix query, Truncated is set if there are more CIDRs than the limit.
+type PrefixResult struct {
+	Prefix    string       `json:"prefix"`
+	CIDRs     []CidrResult `json:"cidrs"`
+	Truncated bool         `json:"truncated"`
+}
+
+// New creates a server answering from the dataset of handle.
+func New(handle *supernet.Handle, options ...Option) *Server {
+	s := &Server{
+		handle:       handle,
+		mux:          http.NewServeMux(),
+		maxBatchSize: DefaultMaxBatchSize,
+		prefixLimit:  DefaultPrefixLimit,
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	s.mux.HandleFunc("GET /lookup/{ip}", s.lookup)
+	s.mux.HandleFunc("POST /lookup", s.lookupBatch)
+	s.mux.HandleFunc("GET /prefix/{cidr...}", s.lookupPrefix)
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	s.mux.HandleFunc("GET /readyz", s.ready)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) {
+	addr, err := netip.ParseAddr(r.PathValue("ip"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.view(w, func(lookuper supernet.Lookuper) {
+		result, found := s.lookupAddr(lookuper, addr)
+		if !found {
+			writeJson(w, http.StatusNotFound, result)
+			return
+		}
+		writeJson(w, http.StatusOK, result)
+	})
+}
+
+func (s *Server) lookupBatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.maxBatchSize)*maxIPJsonSize)
+	ips := []string{}
+	if err := json.NewDecoder(r.Body).Decode(&ips); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the batch must have at most %d IPs", s.maxBatchSize))
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("the body must be a JSON array of IPs: %w", err))
+		return
+	}
+	if len(ips) > s.maxBatchSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the batch must have at most %d IPs", s.maxBatchSize))
+		return
+	}
+
+	s.view(w, func(lookuper supernet.Lookuper) {
+		results := make([]LookupResult, len(ips))
+		for i, ip := range ips {
+			addr, err := netip.ParseAddr(ip)
+			if err != nil {
+				results[i] = LookupResult{IP: ip, Error: err.Error()}
+				continue
+			}
+			results[i], _ = s.lookupAddr(lookuper, addr)
+		}
+		writeJson(w, http.StatusOK, results)
+	})
+}
+
+func (s *Server) lookupPrefix(w http.ResponseWriter, r *http.Request) {
+	prefix, err := netip.ParsePrefix(r.PathValue("cidr"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := s.prefixLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be a positive integer: %q", value))
+			return
+		}
+	}
+
+	s.view(w, func(lookuper supernet.Lookuper) {
+		prefixLookuper, ok := lookuper.(supernet.PrefixLookuper)
+		if !ok {
+			writeError(w, http.StatusNotImplemented, errors.New("the dataset does not support prefix queries"))
+			return
+		}
+		result := PrefixResult{Prefix: prefix.Masked().String(), CIDRs: []CidrResult{}}
+		prefixLookuper.LookupPrefix(prefix, func(cidr netip.Prefix, metadata *supernet.Metadata) bool {
+			if len(result.CIDRs) == limit {
+				result.Truncated = true
+				return false
+			}
+			result.CIDRs = append(result.CIDRs, CidrResult{CIDR: cidr.String(), Attributes: s.attributes(metadata)})
+			return true
+		})
+		writeJson(w, http.StatusOK, result)
+	})
+}
+
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	s.view(w, func(supernet.Lookuper) {
+		writeJson(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+}
+
+// calls f with the current dataset, or writes a 503 error if there is none.
+// The dataset may be released once f returns, so f writes the response, while the metadata found in the dataset is valid.
+func (s *Server) view(w http.ResponseWriter, f func(lookuper supernet.Lookuper)) {
+	loaded := false
+	s.handle.View(func(lookuper supernet.Lookuper) {
+		if lookuper != nil {
+			loaded = true
+			f(lookuper)
+		}
+	})
+	if !loaded {
+		writeError(w, http.StatusServiceUnavailable, errors.New("no dataset is loaded"))
+	}
+}
+
+func (s *Server) lookupAddr(lookuper supernet.Lookuper, addr netip.Addr) (LookupResult, bool) {
+	result := LookupResult{IP: addr.String()}
+	cidr, metadata, found := lookuper.LookupAddr(addr)
+	if found {
+		result.CIDR, result.Attributes = cidr.String(), s.attributes(metadata)
+	}
+	return result, found
+}
+
+// returns the attributes of a resolved CIDR, without the --cidr-key column
+func (s *Server) attributes(metadata *supernet.Metadata) map[string]string {
+	if _, found := metadata.Attributes[s.cidrKey]; s.cidrKey == "" || !found {
+		return metadata.Attributes
+	}
+	attributes := make(map[string]string, len(metadata.Attributes)-1)
+	for key, value := range metadata.Attributes {
+		if key != s.cidrKey {
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{"error": err.Error()})
+}
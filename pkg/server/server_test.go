@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/khalid-nowaf/supernet/pkg/supernet"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer(t *testing.T, options ...Option) (*httptest.Server, *supernet.Handle) {
+	super := supernet.NewSupernet()
+	for i, cidr := range []string{"192.168.0.0/16", "192.168.1.0/24", "2001:db8::/32"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		super.InsertCidr(ipnet, &supernet.Metadata{Priority: []uint8{uint8(i)}, Attributes: map[string]string{"name": cidr}})
+	}
+	handle := supernet.NewHandle(super, nil)
+	server := httptest.NewServer(New(handle, options...))
+	t.Cleanup(server.Close)
+	return server, handle
+}
+
+func get(t *testing.T, url string, body any) int {
+	response, err := http.Get(url)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(body))
+	return response.StatusCode
+}
+
+func TestLookup(t *testing.T) {
+	server, _ := testServer(t)
+
+	result := LookupResult{}
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/lookup/192.168.1.10", &result))
+	assert.Equal(t, LookupResult{IP: "192.168.1.10", CIDR: "192.168.1.0/24", Attributes: map[string]string{"name": "192.168.1.0/24"}}, result)
+
+	result = LookupResult{}
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/lookup/2001:db8::1", &result))
+	assert.Equal(t, "2001:db8::/32", result.CIDR)
+
+	result = LookupResult{}
+	assert.Equal(t, http.StatusNotFound, get(t, server.URL+"/lookup/10.0.0.1", &result))
+	assert.Equal(t, LookupResult{IP: "10.0.0.1"}, result)
+
+	failure := map[string]string{}
+	assert.Equal(t, http.StatusBadRequest, get(t, server.URL+"/lookup/not-an-ip", &failure))
+	assert.NotEmpty(t, failure["error"])
+}
+
+func TestLookupBatch(t *testing.T) {
+	server, _ := testServer(t, WithMaxBatchSize(3))
+
+	response, err := http.Post(server.URL+"/lookup", "application/json", strings.NewReader(`["192.168.2.1", "10.0.0.1", "bad"]`))
+	assert.NoError(t, err)
+	results := []LookupResult{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&results))
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Len(t, results, 3)
+	assert.Equal(t, LookupResult{IP: "192.168.2.1", CIDR: "192.168.2.0/23", Attributes: map[string]string{"name": "192.168.0.0/16"}}, results[0])
+	assert.Equal(t, LookupResult{IP: "10.0.0.1"}, results[1])
+	assert.NotEmpty(t, results[2].Error)
+
+	response, err = http.Post(server.URL+"/lookup", "application/json", strings.NewReader(`["1.1.1.1", "1.1.1.2", "1.1.1.3", "1.1.1.4"]`))
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+
+	response, err = http.Post(server.URL+"/lookup", "application/json", strings.NewReader(`{"ip": "1.1.1.1"}`))
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestLookupPrefix(t *testing.T) {
+	server, _ := testServer(t)
+
+	result := PrefixResult{}
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/prefix/192.168.0.0/23", &result))
+	assert.Equal(t, "192.168.0.0/23", result.Prefix)
+	assert.Equal(t, []CidrResult{
+		{CIDR: "192.168.0.0/24", Attributes: map[string]string{"name": "192.168.0.0/16"}},
+		{CIDR: "192.168.1.0/24", Attributes: map[string]string{"name": "192.168.1.0/24"}},
+	}, result.CIDRs)
+	assert.False(t, result.Truncated)
+
+	// a prefix within a resolved CIDR
+	result = PrefixResult{}
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/prefix/192.168.1.128/25", &result))
+	assert.Equal(t, []CidrResult{{CIDR: "192.168.1.0/24", Attributes: map[string]string{"name": "192.168.1.0/24"}}}, result.CIDRs)
+
+	result = PrefixResult{}
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/prefix/192.168.0.0/16?limit=3", &result))
+	assert.Len(t, result.CIDRs, 3)
+	assert.True(t, result.Truncated)
+
+	result = PrefixResult{}
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/prefix/10.0.0.0/8", &result))
+	assert.Empty(t, result.CIDRs)
+
+	failure := map[string]string{}
+	assert.Equal(t, http.StatusBadRequest, get(t, server.URL+"/prefix/192.168.0.0/16?limit=0", &failure))
+	assert.Equal(t, http.StatusBadRequest, get(t, server.URL+"/prefix/192.168.0.0", &failure))
+}
+
+func TestCidrKey(t *testing.T) {
+	// the name column holds the input CIDR, which is not the resolved CIDR of 192.168.0.0/24
+	server, _ := testServer(t, WithCidrKey("name"))
+
+	result := LookupResult{}
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/lookup/192.168.0.1", &result))
+	assert.Equal(t, LookupResult{IP: "192.168.0.1", CIDR: "192.168.0.0/24"}, result)
+
+	prefix := PrefixResult{}
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/prefix/192.168.0.0/24", &prefix))
+	assert.Equal(t, []CidrResult{{CIDR: "192.168.0.0/24", Attributes: map[string]string{}}}, prefix.CIDRs)
+
+	response, err := http.Post(server.URL+"/lookup", "application/json", strings.NewReader(`["192.168.1.1"]`))
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	results := []LookupResult{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&results))
+	assert.Equal(t, []LookupResult{{IP: "192.168.1.1", CIDR: "192.168.1.0/24"}}, results)
+}
+
+func TestPrefixNotSupported(t *testing.T) {
+	super := supernet.NewSupernet()
+	server := httptest.NewServer(New(supernet.NewHandle(super.Compile(), nil)))
+	defer server.Close()
+
+	failure := map[string]string{}
+	assert.Equal(t, http.StatusNotImplemented, get(t, server.URL+"/prefix/10.0.0.0/8", &failure))
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	server, handle := testServer(t)
+
+	status := map[string]string{}
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/healthz", &status))
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/readyz", &status))
+
+	// the new dataset is used by the next lookups
+	handle.Swap(supernet.NewSupernet(), nil)
+	result := LookupResult{}
+	assert.Equal(t, http.StatusNotFound, get(t, server.URL+"/lookup/192.168.1.1", &result))
+
+	handle.Close()
+	assert.Equal(t, http.StatusOK, get(t, server.URL+"/healthz", &status))
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, server.URL+"/readyz", &status))
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, server.URL+"/lookup/192.168.1.1", &status))
+}
+
+func TestLookupMatchesHandle(t *testing.T) {
+	server, handle := testServer(t)
+	for _, ip := range []string{"192.168.0.1", "192.168.1.255", "2001:db8:ffff::1"} {
+		expected, _, _ := handle.LookupAddr(netip.MustParseAddr(ip))
+		result := LookupResult{}
+		get(t, server.URL+"/lookup/"+ip, &result)
+		assert.Equal(t, expected.String(), result.CIDR, ip)
+	}
+}
+
+func TestLookupsDuringReloads(t *testing.T) {
+	server, handle := testServer(t)
+	// releasing a dataset clears its attributes, like closing a Reader makes its memory unreadable,
+	// so a response encoded after the release has no attributes, and the race detector reports it
+	newDataset := func() (supernet.Lookuper, func()) {
+		attributes := map[string]string{"name": "192.168.0.0/16"}
+		super := supernet.NewSupernet()
+		_, ipnet, _ := net.ParseCIDR("192.168.0.0/16")
+		super.InsertCidr(ipnet, &supernet.Metadata{Attributes: attributes})
+		return super, func() { clear(attributes) }
+	}
+	handle.Swap(newDataset())
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				handle.Swap(newDataset())
+				runtime.Gosched() // let the requests run between the reloads on a single CPU
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	for i := 0; i < 50; i++ {
+		result := LookupResult{}
+		assert.Equal(t, http.StatusOK, get(t, server.URL+"/lookup/192.168.1.1", &result))
+		assert.Equal(t, "192.168.0.0/16", result.Attributes["name"])
+
+		prefix := PrefixResult{}
+		assert.Equal(t, http.StatusOK, get(t, server.URL+"/prefix/192.168.1.0/24", &prefix))
+		assert.Equal(t, "192.168.0.0/16", prefix.CIDRs[0].Attributes["name"])
+
+		response, err := http.Post(server.URL+"/lookup", "application/json", strings.NewReader(`["192.168.1.1"]`))
+		assert.NoError(t, err)
+		results := []LookupResult{}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&results))
+		response.Body.Close()
+		assert.Equal(t, "192.168.0.0/16", results[0].Attributes["name"])
+	}
+}
@@ -1,12 +1,47 @@
 package supernet
 
 import (
+	"fmt"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
 	"github.com/khalid-nowaf/supernet/pkg/trie"
 )
 
 type Action interface {
-	Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie, targetNode *CidrTrie, remainingPath []int) *ActionResult
+	Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie, targetNode *CidrTrie, path ipbits.Path) *ActionResult
 	String() string
+	Kind() ActionKind
+}
+
+// ActionKind is a stable identifier of an Action, used when results are serialized.
+type ActionKind int
+
+const (
+	IgnoreInsertionKind ActionKind = iota
+	InsertNewCIDRKind
+	RemoveExistingCIDRKind
+	SplitInsertedCIDRKind
+	SplitExistingCIDRKind
+)
+
+func (kind ActionKind) String() string {
+	switch kind {
+	case IgnoreInsertionKind:
+		return "ignore_insertion"
+	case InsertNewCIDRKind:
+		return "insert_new_cidr"
+	case RemoveExistingCIDRKind:
+		return "remove_existing_cidr"
+	case SplitInsertedCIDRKind:
+		return "split_inserted_cidr"
+	case SplitExistingCIDRKind:
+		return "split_existing_cidr"
+	}
+	return fmt.Sprintf("action_kind(%d)", int(kind))
+}
+
+func (kind ActionKind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
 }
 
 type (
@@ -17,17 +52,21 @@ type (
 	SplitExistingCIDR  struct{} // split the existing CIDR `on` specific node
 )
 
-func (action IgnoreInsertion) Execute(_ *CidrTrie, _ *CidrTrie, _ *CidrTrie, _ []int) *ActionResult {
+func (action IgnoreInsertion) Execute(_ *CidrTrie, _ *CidrTrie, _ *CidrTrie, _ ipbits.Path) *ActionResult {
 	return &ActionResult{
 		Action: action,
 	}
 }
 
+func (_ IgnoreInsertion) Kind() ActionKind {
+	return IgnoreInsertionKind
+}
+
 func (_ IgnoreInsertion) String() string {
 	return "Ignore Insertion"
 }
 
-func (action InsertNewCIDR) Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie, _ *CidrTrie, remainingPath []int) *ActionResult {
+func (action InsertNewCIDR) Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie, _ *CidrTrie, path ipbits.Path) *ActionResult {
 
 	actionResult := &ActionResult{
 		Action: action,
@@ -41,7 +80,7 @@ func (action InsertNewCIDR) Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie
 		panic("[BUG] Action[InsertNewCIDR].Execute:: conflictedPoint node must be a leaf")
 	}
 
-	lastNode, conflictType, _ := buildPath(conflictedPoint, remainingPath)
+	lastNode, conflictType := buildPath(conflictedPoint, path, nil)
 	if _, noConflict := conflictType.(NoConflict); !noConflict {
 		panic("[BUG] Action[InsertNewCIDR].Execute:: Can not insert CIDR while there is a conflict unresolved")
 	}
@@ -56,18 +95,22 @@ func (action InsertNewCIDR) Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie
 
 }
 
+func (_ InsertNewCIDR) Kind() ActionKind {
+	return InsertNewCIDRKind
+}
+
 func (_ InsertNewCIDR) String() string {
 	return "Insert New CIDR"
 }
 
-func (action RemoveExistingCIDR) Execute(newCidr *CidrTrie, _ *CidrTrie, targetNode *CidrTrie, _ []int) *ActionResult {
+func (action RemoveExistingCIDR) Execute(_ *CidrTrie, _ *CidrTrie, targetNode *CidrTrie, path ipbits.Path) *ActionResult {
 
 	actionResult := &ActionResult{
 		Action: action,
 	}
 
 	actionResult.appendRemovedCidr(targetNode)
-	newCidrDepth, _ := newCidr.Metadata().originCIDR.Mask.Size()
+	newCidrDepth := path.Len
 
 	if newCidrDepth >= targetNode.Depth() {
 		targetNode.UpdateMetadata(nil)
@@ -79,11 +122,15 @@ func (action RemoveExistingCIDR) Execute(newCidr *CidrTrie, _ *CidrTrie, targetN
 
 }
 
+func (_ RemoveExistingCIDR) Kind() ActionKind {
+	return RemoveExistingCIDRKind
+}
+
 func (_ RemoveExistingCIDR) String() string {
 	return "Remove Existing CIDR"
 }
 
-func (action SplitInsertedCIDR) Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie, targetNode *CidrTrie, _ []int) *ActionResult {
+func (action SplitInsertedCIDR) Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie, targetNode *CidrTrie, _ ipbits.Path) *ActionResult {
 
 	actionResult := &ActionResult{
 		Action: action,
@@ -99,11 +146,15 @@ func (action SplitInsertedCIDR) Execute(newCidr *CidrTrie, conflictedPoint *Cidr
 
 }
 
+func (_ SplitInsertedCIDR) Kind() ActionKind {
+	return SplitInsertedCIDRKind
+}
+
 func (_ SplitInsertedCIDR) String() string {
 	return "Split Inserted CIDR"
 }
 
-func (action SplitExistingCIDR) Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie, targetNode *CidrTrie, _ []int) *ActionResult {
+func (action SplitExistingCIDR) Execute(newCidr *CidrTrie, conflictedPoint *CidrTrie, targetNode *CidrTrie, _ ipbits.Path) *ActionResult {
 	// init inserted result
 	actionResult := &ActionResult{
 		Action: action,
@@ -118,13 +169,17 @@ func (action SplitExistingCIDR) Execute(newCidr *CidrTrie, conflictedPoint *Cidr
 
 }
 
+func (_ SplitExistingCIDR) Kind() ActionKind {
+	return SplitExistingCIDRKind
+}
+
 func (_ SplitExistingCIDR) String() string {
 	return "Split Existing CIDR"
 }
 
 // to keep track of all removed CIDRs from resolving a conflict.
 func (ar *ActionResult) appendRemovedCidr(cidr *CidrTrie) {
-	ar.RemoveCidrs = append(ar.RemoveCidrs, *cidr)
+	ar.RemoveCidrs = append(ar.RemoveCidrs, NodeToPrefix(cidr))
 }
 
 // The function traverses from the sub-CIDR node upwards, attempting to insert a sibling node at each step.
@@ -142,12 +197,7 @@ func splitAround(sub *CidrTrie, newCidrMetadata *Metadata, limitDepth int) []*Ci
 	sub.ForEachStepUp(func(current *CidrTrie) {
 
 		// Create a new trie node with the same metadata as the splittedCidrMetadata.
-		newCidr := trie.NewTrieWithMetadata(&Metadata{
-			IsV6:       splittedCidrMetadata.IsV6,
-			originCIDR: splittedCidrMetadata.originCIDR,
-			Priority:   splittedCidrMetadata.Priority,
-			Attributes: splittedCidrMetadata.Attributes,
-		})
+		newCidr := trie.NewTrieWithMetadata(splitMetadata(splittedCidrMetadata))
 
 		added := current.AttachSibling(newCidr)
 
@@ -163,3 +213,14 @@ func splitAround(sub *CidrTrie, newCidrMetadata *Metadata, limitDepth int) []*Ci
 
 	return splittedCidrs
 }
+
+// copies the metadata for a fragment of a split CIDR, the fragments share the origin attributes and priority
+func splitMetadata(metadata *Metadata) *Metadata {
+	return &Metadata{
+		IsV6:       metadata.IsV6,
+		originCIDR: metadata.originCIDR,
+		Priority:   metadata.Priority,
+		Attributes: metadata.Attributes,
+		Source:     metadata.Source,
+	}
+}
@@ -0,0 +1,108 @@
+package supernet
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+	"github.com/khalid-nowaf/supernet/pkg/trie"
+)
+
+// AggregateGroup is the minimal list of CIDRs covering the CIDRs of the records in a group, see Aggregate.
+type AggregateGroup struct {
+	Attributes map[string]string // the values of the grouping keys, shared by the records of the group
+	CIDRs      []netip.Prefix    // sorted, IPv4 first, neither nested nor adjacent
+}
+
+// Aggregate groups the records by the values of the attributes keys, and returns the minimal list of CIDRs covering
+// the CIDRs of each group: the nested CIDRs are dropped, and the adjacent CIDRs are merged,
+// e.g. 10.0.0.0/25, 10.0.0.128/26 and 10.0.0.192/26 into 10.0.0.0/24.
+//
+// The groups are in the order of their first record, a missing key has an empty value, and without keys all the records are in one group.
+// Unlike the insertion, the priorities are ignored, and the CIDRs of different groups may overlap.
+func Aggregate(records []CidrRecord, keys []string) []*AggregateGroup {
+	type group struct {
+		*AggregateGroup
+		roots [2]*coverTrie // IPv4 and IPv6
+	}
+	groups := []*group{}
+	groupsByValues := map[string]*group{}
+
+	for _, record := range records {
+		attributes := map[string]string{}
+		values := strings.Builder{}
+		for _, key := range keys {
+			value := ""
+			if record.Metadata != nil {
+				value = record.Metadata.Attributes[key]
+			}
+			attributes[key] = value
+			values.WriteString(strconv.Quote(value))
+		}
+
+		g, found := groupsByValues[values.String()]
+		if !found {
+			g = &group{AggregateGroup: &AggregateGroup{Attributes: attributes}}
+			g.roots[0], g.roots[1] = trie.NewTrieWithMetadata[struct{}](nil), trie.NewTrieWithMetadata[struct{}](nil)
+			groups = append(groups, g)
+			groupsByValues[values.String()] = g
+		}
+
+		isV6 := record.CIDR.IP.To4() == nil
+		root := g.roots[0]
+		if isV6 {
+			root = g.roots[1]
+		}
+		cover(root, ipbits.PathFromIPNet(record.CIDR))
+	}
+
+	aggregated := make([]*AggregateGroup, 0, len(groups))
+	for _, g := range groups {
+		g.CIDRs = coveredPrefixes(g.roots[0], false, g.CIDRs)
+		g.CIDRs = coveredPrefixes(g.roots[1], true, g.CIDRs)
+		aggregated = append(aggregated, g.AggregateGroup)
+	}
+	return aggregated
+}
+
+// a trie with one node per bit, a node with metadata is a covered CIDR, and has no children
+type coverTrie = trie.BinaryTrie[struct{}]
+
+var covered = &struct{}{}
+
+// adds the path to the covered CIDRs, the CIDRs within it are removed, and two covered siblings are replaced by their parent
+func cover(root *coverTrie, path ipbits.Path) {
+	node := root
+	for i := 0; i < path.Len; i++ {
+		if node.Metadata() != nil {
+			// within a covered CIDR
+			return
+		}
+		child := node.Child(path.Key.Bit(i))
+		if child == nil {
+			child = node.AttachChild(trie.NewTrieWithMetadata[struct{}](nil), path.Key.Bit(i))
+		}
+		node = child
+	}
+
+	for {
+		node.UpdateMetadata(covered)
+		node.ForEachChild(func(child *coverTrie) { child.Detach() })
+		if node.IsRoot() || node.Sibling() == nil || node.Sibling().Metadata() == nil {
+			return
+		}
+		node = node.Parent()
+	}
+}
+
+// appends the covered CIDRs under node to prefixes, in address order
+func coveredPrefixes(node *coverTrie, isV6 bool, prefixes []netip.Prefix) []netip.Prefix {
+	if node.Metadata() != nil {
+		return append(prefixes, node.Path().Prefix(isV6))
+	}
+	node.ForEachChild(func(child *coverTrie) {
+		prefixes = coveredPrefixes(child, isV6, prefixes)
+	})
+	return prefixes
+}
@@ -0,0 +1,90 @@
+package supernet
+
+import (
+	"math/rand"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func aggregateRecords(cidrs []string, names []string) []CidrRecord {
+	records := []CidrRecord{}
+	for i, c := range cidrs {
+		records = append(records, CidrRecord{CIDR: cidr(c), Metadata: &Metadata{Attributes: map[string]string{"cidr": c, "name": names[i]}}})
+	}
+	return records
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	strings := []string{}
+	for _, prefix := range prefixes {
+		strings = append(strings, prefix.String())
+	}
+	return strings
+}
+
+func TestAggregate(t *testing.T) {
+	records := aggregateRecords(
+		[]string{"10.0.0.128/26", "2001:db8::/33", "10.0.0.0/25", "10.0.0.64/26", "1.0.0.0/8", "10.0.0.192/26", "2001:db8:8000::/33", "10.0.1.0/24", "1.1.0.0/16"},
+		[]string{"a", "a", "a", "a", "b", "a", "a", "b", "a"},
+	)
+
+	groups := Aggregate(records, []string{"name"})
+	assert.Len(t, groups, 2)
+	assert.Equal(t, map[string]string{"name": "a"}, groups[0].Attributes)
+	assert.Equal(t, []string{"1.1.0.0/16", "10.0.0.0/24", "2001:db8::/32"}, prefixStrings(groups[0].CIDRs))
+	assert.Equal(t, map[string]string{"name": "b"}, groups[1].Attributes)
+	assert.Equal(t, []string{"1.0.0.0/8", "10.0.1.0/24"}, prefixStrings(groups[1].CIDRs))
+
+	// without keys, the CIDRs of all the records are aggregated
+	groups = Aggregate(records, nil)
+	assert.Len(t, groups, 1)
+	assert.Empty(t, groups[0].Attributes)
+	assert.Equal(t, []string{"1.0.0.0/8", "10.0.0.0/23", "2001:db8::/32"}, prefixStrings(groups[0].CIDRs))
+
+	// a record without the key, or without metadata, has an empty value
+	groups = Aggregate([]CidrRecord{{CIDR: cidr("10.0.0.0/8")}, {CIDR: cidr("11.0.0.0/8"), Metadata: &Metadata{}}}, []string{"name"})
+	assert.Len(t, groups, 1)
+	assert.Equal(t, map[string]string{"name": ""}, groups[0].Attributes)
+	assert.Equal(t, []string{"10.0.0.0/7"}, prefixStrings(groups[0].CIDRs))
+
+	// the two halves of the address space are merged into the root
+	groups = Aggregate([]CidrRecord{{CIDR: cidr("0.0.0.0/1")}, {CIDR: cidr("128.0.0.0/1")}}, nil)
+	assert.Equal(t, []string{"0.0.0.0/0"}, prefixStrings(groups[0].CIDRs))
+}
+
+func TestAggregateCoversTheSameAddresses(t *testing.T) {
+	random := rand.New(rand.NewSource(11))
+	records := []CidrRecord{}
+	for i := 0; i < 500; i++ {
+		maskSize := 18 + random.Intn(15)
+		ip := net.IPv4(10, 0, byte(random.Intn(64)), byte(random.Intn(256))).Mask(net.CIDRMask(maskSize, 32))
+		records = append(records, CidrRecord{CIDR: &net.IPNet{IP: ip, Mask: net.CIDRMask(maskSize, 32)}})
+	}
+	aggregated := Aggregate(records, nil)[0].CIDRs
+
+	for i, prefix := range aggregated {
+		if i > 0 {
+			previous := aggregated[i-1]
+			assert.True(t, previous.Addr().Less(prefix.Addr()))
+			assert.False(t, previous.Overlaps(prefix))
+			siblings := previous.Bits() == prefix.Bits() && isZeroHalf(previous) && isNextPrefix(previous, prefix)
+			assert.False(t, siblings, "%s and %s must be merged", previous, prefix)
+		}
+	}
+
+	for i := 0; i < 20000; i++ {
+		addr := netip.AddrFrom4([4]byte{10, 0, byte(random.Intn(64)), byte(random.Intn(256))})
+		inRecords := false
+		for _, record := range records {
+			inRecords = inRecords || record.CIDR.Contains(addr.AsSlice())
+		}
+		inAggregated := false
+		for _, prefix := range aggregated {
+			inAggregated = inAggregated || prefix.Contains(addr)
+		}
+		assert.Equal(t, inRecords, inAggregated, addr.String())
+	}
+}
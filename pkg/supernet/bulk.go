@@ -0,0 +1,274 @@
+package supernet
+
+import (
+	"net/netip"
+	"slices"
+	"sort"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+	"github.com/khalid-nowaf/supernet/pkg/trie"
+)
+
+// a CIDR being bulk loaded, either a record or an existing leaf
+type bulkItem struct {
+	path     ipbits.Path
+	metadata *Metadata
+	order    int   // insertion order, the existing leafs come first
+	record   int   // index in the records, -1 for existing leafs
+	parent   int   // nearest enclosing item, -1 if none
+	winner   int   // item whose metadata wins on the space of this item
+	owner    int   // nearest item, itself or an ancestor, owning a region of the space, see BulkLoad
+	holes    []int // the nearest owners nested in the region of an owner
+}
+
+// BulkLoad inserts all the records at once, with the same resolved CIDRs as calling InsertCidr for each record in order,
+// but without resolving the conflicts one insertion at a time.
+//
+// The records and the existing CIDRs are sorted by address, then each CIDR keeps the space where it wins over all the
+// CIDRs enclosing it, and the trie is built from the resulting CIDRs in one pass. This requires the comparator to be
+// a consistent ordering, like DefaultComparator.
+//
+// The insertion result of each record is passed to onResult, the logger, and the stats, and it is the same as the
+// result of InsertCidr. The results depend on the CIDRs split by the previous insertions, so they are computed by
+// replaying, on a scratch trie, the insertions of each group of overlapping CIDRs, in their order. A record overlapping
+// no other CIDR is not replayed, it is inserted without conflict.
+//
+// The results are only computed when onResult is not nil. Without it, the records are not passed to the logger,
+// and only the number of insertions is added to the stats, not their conflicts and actions.
+func (super *Supernet) BulkLoad(records []CidrRecord, onResult func(index int, result *InsertionResult)) {
+	families := [2][]*bulkItem{}
+	for _, forV6 := range []bool{false, true} {
+		existing := []bulkItem{}
+		super.ForEachCidr(forV6, func(cidr netip.Prefix, metadata *Metadata) bool {
+			existing = append(existing, bulkItem{path: ipbits.PathFromPrefix(cidr), metadata: metadata, order: len(existing), record: -1})
+			return true
+		})
+		family := make([]*bulkItem, len(existing), len(existing)+len(records))
+		for i := range existing {
+			family[i] = &existing[i]
+		}
+		if forV6 {
+			families[1] = family
+		} else {
+			families[0] = family
+		}
+	}
+	existing := [2]int{len(families[0]), len(families[1])}
+
+	// the items are allocated at once, the trie does not reference them
+	items := make([]bulkItem, len(records))
+	for i, record := range records {
+		metadata := prepareMetadata(record.CIDR, record.Metadata)
+		super.intern(metadata)
+		family := 0
+		if metadata.IsV6 {
+			family = 1
+		}
+		items[i] = bulkItem{path: cidrPath(record.CIDR), metadata: metadata, order: existing[family] + i, record: i}
+		families[family] = append(families[family], &items[i])
+	}
+
+	var results []*InsertionResult
+	if onResult != nil {
+		results = make([]*InsertionResult, len(records))
+	}
+	for family, items := range families {
+		isV6 := family == 1
+		super.bulkResolve(items)
+		if results != nil {
+			super.bulkReplay(items, results)
+		}
+
+		if radix := super.radixTrie(isV6); radix != nil {
+			radix = trie.NewRadixTrie[Metadata]()
+			if isV6 {
+				super.ipv6Radix = radix
+			} else {
+				super.ipv4Radix = radix
+			}
+			bulkEmit(items, isV6, func(path ipbits.Path, metadata *Metadata) { radix.Insert(path, metadata) }, super.journal)
+			continue
+		}
+
+		root := &CidrTrie{}
+		if isV6 {
+			super.ipv6Cidrs = root
+		} else {
+			super.ipv4Cidrs = root
+		}
+		nodes := &nodeSlab{}
+		bulkEmit(items, isV6, func(path ipbits.Path, metadata *Metadata) { nodes.materialize(root, path, metadata) }, super.journal)
+	}
+
+	if results == nil {
+		super.counters.insertions.Add(uint64(len(records)))
+		return
+	}
+	for i, result := range results {
+		super.counters.record(result)
+		super.logger(result)
+		onResult(i, result)
+	}
+}
+
+// sorts the items by address, finds the item enclosing each of them, and which item wins on their space
+func (super *Supernet) bulkResolve(items []*bulkItem) {
+	slices.SortFunc(items, func(a, b *bulkItem) int {
+		if c := a.path.Key.Compare(b.path.Key); c != 0 {
+			return c
+		}
+		if a.path.Len != b.path.Len {
+			return a.path.Len - b.path.Len
+		}
+		return a.order - b.order
+	})
+
+	// the items enclosing the current one, the shortest first
+	stack := []int{}
+	for i, item := range items {
+		for len(stack) > 0 && !items[stack[len(stack)-1]].path.Contains(item.path) {
+			stack = stack[:len(stack)-1]
+		}
+		item.parent, item.winner, item.owner = -1, i, i
+		if len(stack) > 0 {
+			item.parent = stack[len(stack)-1]
+			parent := items[item.parent]
+			if super.bulkWins(items[i], items[parent.winner]) {
+				// the item wins over all the CIDRs enclosing it, so it owns its space, except the holes taken by the items it encloses
+				owner := items[parent.owner]
+				owner.holes = append(owner.holes, i)
+			} else {
+				item.winner, item.owner = parent.winner, parent.owner
+			}
+		}
+		stack = append(stack, i)
+	}
+}
+
+// reports whether the item a wins over the item b, if they have the same priority the last inserted one wins
+func (super *Supernet) bulkWins(a *bulkItem, b *bulkItem) bool {
+	if a.order > b.order {
+		return super.comparator(a.metadata, b.metadata)
+	}
+	return !super.comparator(b.metadata, a.metadata)
+}
+
+// calls insert for each resolved CIDR, which are the regions of the owners, minus their holes, split into CIDRs.
+// Only the CIDRs of the records are journaled, the existing leafs were journaled when they were inserted,
+// and replaying the records splits them again.
+func bulkEmit(items []*bulkItem, isV6 bool, insert func(ipbits.Path, *Metadata), journal *Journal) {
+	for i, item := range items {
+		if item.owner != i {
+			continue
+		}
+		holes := make([]ipbits.Path, len(item.holes))
+		for j, hole := range item.holes {
+			holes[j] = items[hole].path
+		}
+		bulkRegion(item.path, holes, func(path ipbits.Path) {
+			metadata := item.metadata
+			if path != item.path {
+				metadata = splitMetadata(item.metadata)
+			}
+			if item.record != -1 && journal != nil {
+				journal.appendInsert(prefixToIPNet(path.Prefix(isV6)), metadata)
+			}
+			insert(path, metadata)
+		})
+	}
+}
+
+// calls emit with the largest CIDRs covering path without the holes, the holes are sorted by address and do not overlap
+func bulkRegion(path ipbits.Path, holes []ipbits.Path, emit func(ipbits.Path)) {
+	if len(holes) == 0 {
+		emit(path)
+		return
+	}
+	if holes[0].Len == path.Len {
+		// the hole is the whole path
+		return
+	}
+	zero := ipbits.Path{Key: path.Key.WithBit(path.Len, 0), Len: path.Len + 1}
+	one := ipbits.Path{Key: path.Key.WithBit(path.Len, 1), Len: path.Len + 1}
+	split := sort.Search(len(holes), func(i int) bool { return holes[i].Key.Bit(path.Len) == 1 })
+	bulkRegion(zero, holes[:split], emit)
+	bulkRegion(one, holes[split:], emit)
+}
+
+// sets the insertion results of the records in the sorted items, the items enclosed by another one follow it,
+// so each group of overlapping items starts with an item without parent
+func (super *Supernet) bulkReplay(items []*bulkItem, results []*InsertionResult) {
+	for start := 0; start < len(items); {
+		end := start + 1
+		for end < len(items) && items[end].parent != -1 {
+			end++
+		}
+		group := items[start:end]
+		start = end
+
+		if len(group) == 1 {
+			if item := group[0]; item.record != -1 {
+				cidr := ipnetToPrefix(item.metadata.originCIDR)
+				results[item.record] = noConflictResult(cidr, cidr)
+			}
+			continue
+		}
+
+		// the existing leafs do not overlap, then the records are inserted in their order, like InsertCidr would
+		group = append([]*bulkItem{}, group...)
+		sort.Slice(group, func(i, j int) bool { return group[i].order < group[j].order })
+		root := &CidrTrie{}
+		for _, item := range group {
+			if item.record == -1 {
+				materialize(root, item.path, item.metadata)
+			} else {
+				results[item.record] = super.insertLeaf(root, item.path, trie.NewTrieWithMetadata(item.metadata), nil)
+			}
+		}
+	}
+}
+
+// builds the path of a resolved CIDR under root, and returns its leaf
+func materialize(root *CidrTrie, path ipbits.Path, metadata *Metadata) *CidrTrie {
+	var nodes *nodeSlab
+	return nodes.materialize(root, path, metadata)
+}
+
+// number of nodes allocated at once by a nodeSlab
+const nodeSlabSize = 1024
+
+// allocates the nodes of a trie by chunks, instead of one by one. It is used for the tries built in one pass,
+// and for the path nodes and the leafs of the insertions, while the nodes created by the conflict resolutions are
+// allocated one by one. A chunk is kept in memory as long as one of its nodes is in the trie, so the nodes removed
+// from the trie are only freed with the other nodes of their chunk. A nil slab allocates each node.
+type nodeSlab struct {
+	nodes []CidrTrie
+}
+
+func (s *nodeSlab) new() *CidrTrie {
+	if s == nil {
+		return newPathNode()
+	}
+	if len(s.nodes) == 0 {
+		s.nodes = make([]CidrTrie, nodeSlabSize)
+	}
+	node := &s.nodes[0]
+	s.nodes = s.nodes[1:]
+	return node
+}
+
+// builds the path of a resolved CIDR under root with the nodes of the slab, and returns its leaf
+func (s *nodeSlab) materialize(root *CidrTrie, path ipbits.Path, metadata *Metadata) *CidrTrie {
+	node := root
+	for depth := 0; depth < path.Len-1; depth++ {
+		pos := path.Key.Bit(depth)
+		child := node.Child(pos)
+		if child == nil {
+			child = node.AttachChild(s.new(), pos)
+		}
+		node = child
+	}
+	leaf := s.new()
+	leaf.UpdateMetadata(metadata)
+	return node.ReplaceChild(leaf, path.LastBit())
+}
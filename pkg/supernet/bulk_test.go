@@ -0,0 +1,213 @@
+package supernet
+
+import (
+	"math/rand"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkLoadMatchesSequentialInsertion(t *testing.T) {
+	random := rand.New(rand.NewSource(8))
+	records := []CidrRecord{}
+	for i := 0; i < 5000; i++ {
+		maskSize := 4 + random.Intn(21)
+		var ipnet *net.IPNet
+		if i%5 == 0 {
+			ip := make(net.IP, net.IPv6len)
+			ip[0], ip[1], ip[2] = 0x20, 0x01, byte(random.Intn(4))
+			ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(16+maskSize, 128)}
+		} else {
+			ip := net.IPv4(10, byte(random.Intn(16)), byte(random.Intn(256)), 0)
+			ipnet = &net.IPNet{IP: ip.Mask(net.CIDRMask(maskSize, 32)), Mask: net.CIDRMask(maskSize, 32)}
+		}
+		records = append(records, CidrRecord{CIDR: ipnet, Metadata: &Metadata{Priority: []uint8{uint8(random.Intn(3))}, Attributes: map[string]string{"record": ipnet.String()}}})
+	}
+
+	// some CIDRs are inserted before the bulk load
+	sequential := NewSupernet()
+	bulk := NewSupernet()
+	for _, record := range copyRecords(records[:500]) {
+		sequential.InsertCidr(record.CIDR, record.Metadata)
+	}
+	for _, record := range copyRecords(records[:500]) {
+		bulk.InsertCidr(record.CIDR, record.Metadata)
+	}
+
+	expected := []*InsertionResult{}
+	for _, record := range copyRecords(records[500:]) {
+		expected = append(expected, sequential.InsertCidr(record.CIDR, record.Metadata))
+	}
+	results := make([]*InsertionResult, len(records)-500)
+	bulk.BulkLoad(copyRecords(records[500:]), func(index int, result *InsertionResult) {
+		results[index] = result
+	})
+
+	for _, forV6 := range []bool{false, true} {
+		assert.Equal(t, sequential.AllCidrsString(forV6), bulk.AllCidrsString(forV6))
+		sequential.ForEachCidr(forV6, func(cidr netip.Prefix, expected *Metadata) bool {
+			_, actual, _ := bulk.LookupAddr(cidr.Addr())
+			assert.Equal(t, expected.Attributes, actual.Attributes)
+			assert.Equal(t, expected.Priority, actual.Priority)
+			assert.Equal(t, expected.originCIDR.String(), actual.originCIDR.String())
+			return true
+		})
+	}
+
+	for i, result := range results {
+		assertSameResult(t, expected[i], result)
+	}
+	assert.Equal(t, sequential.Stats().Insertions, bulk.Stats().Insertions)
+	assert.Equal(t, sequential.Stats().Conflicts, bulk.Stats().Conflicts)
+	assert.Equal(t, sequential.Stats().Actions, bulk.Stats().Actions)
+}
+
+func TestBulkLoadResults(t *testing.T) {
+	cidrs, priorities := []string{"10.0.0.0/8", "10.1.0.0/16", "10.2.0.0/16", "10.2.0.0/16", "11.0.0.0/8", "10.0.0.0/7"}, []uint8{1, 2, 0, 0, 0, 1}
+	records := []CidrRecord{}
+	for i, s := range cidrs {
+		records = append(records, CidrRecord{CIDR: cidr(s), Metadata: &Metadata{Priority: []uint8{priorities[i]}}})
+	}
+	sequential := NewSupernet()
+	for _, record := range copyRecords(records) {
+		sequential.InsertCidr(record.CIDR, record.Metadata)
+	}
+	super := NewSupernet()
+	results := make([]*InsertionResult, len(records))
+	super.BulkLoad(records, func(index int, result *InsertionResult) {
+		results[index] = result
+	})
+	assert.Equal(t, sequential.AllCidrsString(false), super.AllCidrsString(false))
+
+	assert.Equal(t, NoConflict{}, results[0].ConflictType)
+	assert.Equal(t, []string{"insert_new_cidr +10.0.0.0/8"}, actionSummary(results[0]))
+
+	// 10.1.0.0/16 splits 10.0.0.0/8
+	assert.Equal(t, SubCIDR{}, results[1].ConflictType)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, results[1].ConflictedWith)
+	assert.Equal(t, InsertNewCIDRKind, results[1].Actions[0].Action.Kind())
+	assert.Equal(t, SplitExistingCIDRKind, results[1].Actions[1].Action.Kind())
+
+	// 10.2.0.0/16 loses to the fragment 10.2.0.0/15 of 10.0.0.0/8, and its duplicate too
+	for _, result := range results[2:4] {
+		assert.Equal(t, SubCIDR{}, result.ConflictType)
+		assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.2.0.0/15")}, result.ConflictedWith)
+		assert.Equal(t, []string{"ignore_insertion "}, actionSummary(result))
+	}
+
+	// 11.0.0.0/8 does not overlap the records before it, but 10.0.0.0/7 which comes after it
+	assert.Equal(t, NoConflict{}, results[4].ConflictType)
+	assert.Equal(t, SuperCIDR{}, results[5].ConflictType)
+	assert.Len(t, results[5].ConflictedWith, 10)
+}
+
+// checks that the bulk result is the same as the sequential one, except for the metadata identity
+func assertSameResult(t *testing.T, expected *InsertionResult, actual *InsertionResult) {
+	t.Helper()
+	assert.Equal(t, expected.CIDR, actual.CIDR)
+	assert.Equal(t, expected.ConflictType, actual.ConflictType, expected.CIDR.String())
+	assert.Equal(t, expected.ConflictedWith, actual.ConflictedWith, expected.CIDR.String())
+	assert.Equal(t, len(expected.ConflictedMetadata), len(actual.ConflictedMetadata), expected.CIDR.String())
+	for i := range min(len(expected.ConflictedMetadata), len(actual.ConflictedMetadata)) {
+		assert.Equal(t, expected.ConflictedMetadata[i].Attributes, actual.ConflictedMetadata[i].Attributes, expected.CIDR.String())
+	}
+	assert.Equal(t, expected.Actions, actual.Actions, expected.CIDR.String())
+}
+
+func TestBulkLoadWithoutResults(t *testing.T) {
+	records := overlappingRecords(2000)
+	sequential := NewSupernet()
+	for _, record := range copyRecords(records) {
+		sequential.InsertCidr(record.CIDR, record.Metadata)
+	}
+	logged := 0
+	bulk := NewSupernet(WithCustomLogger(func(*InsertionResult) { logged++ }))
+	bulk.BulkLoad(copyRecords(records), nil)
+
+	assert.Equal(t, sequential.AllCidrsString(false), bulk.AllCidrsString(false))
+	// the results are not computed, so only the insertions are counted
+	assert.Equal(t, uint64(2000), bulk.Stats().Insertions)
+	assert.Zero(t, bulk.Stats().Conflicts[SubCIDRKind])
+	assert.Zero(t, logged)
+}
+
+func TestBulkRegion(t *testing.T) {
+	emitted := []string{}
+	holes := []ipbits.Path{bulkPath("10.1.0.0/16"), bulkPath("10.128.0.0/9")}
+	bulkRegion(bulkPath("10.0.0.0/8"), holes, func(path ipbits.Path) {
+		emitted = append(emitted, path.Prefix(false).String())
+	})
+	assert.Equal(t, []string{"10.0.0.0/16", "10.2.0.0/15", "10.4.0.0/14", "10.8.0.0/13", "10.16.0.0/12", "10.32.0.0/11", "10.64.0.0/10"}, emitted)
+}
+
+// loading random /24 CIDRs, most of them do not overlap, without results, against InsertCidrs and InsertCidr on the same machine,
+// the items and the trie nodes are allocated by chunks:
+// BenchmarkBulkLoad    	 1000000	      2728 ns/op	     482 B/op	       3 allocs/op
+// BenchmarkInsertCidrs 	 1000000	      3457 ns/op	     742 B/op	       4 allocs/op
+// BenchmarkInsertCidr  	 1000000	      3768 ns/op	     651 B/op	       4 allocs/op
+func BenchmarkBulkLoad(b *testing.B) {
+	records := make([]CidrRecord, b.N)
+	for i, cidr := range randomCidrs(b.N, 24) {
+		records[i] = CidrRecord{CIDR: cidr}
+	}
+	root := NewSupernet()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	root.BulkLoad(records, nil)
+}
+
+// loading CIDRs from /12 to /24 within 10.0.0.0/8, so most of them overlap and split each other, the insertion results
+// are as costly to compute as inserting the records one by one, so they are only computed for a callback:
+// BenchmarkBulkLoadOverlaps        	   20000	      1655 ns/op	     186 B/op	       1 allocs/op
+// BenchmarkBulkLoadOverlapsResults 	   20000	    154067 ns/op	   62434 B/op	     880 allocs/op
+// BenchmarkInsertCidrOverlaps      	   20000	    166821 ns/op	   62240 B/op	     878 allocs/op
+func BenchmarkBulkLoadOverlaps(b *testing.B) {
+	root := NewSupernet()
+	records := overlappingRecords(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	root.BulkLoad(records, nil)
+}
+
+// the results are computed by replaying the overlapping records
+func BenchmarkBulkLoadOverlapsResults(b *testing.B) {
+	root := NewSupernet()
+	records := overlappingRecords(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	root.BulkLoad(records, func(index int, result *InsertionResult) {})
+}
+
+func BenchmarkInsertCidrOverlaps(b *testing.B) {
+	root := NewSupernet()
+	records := overlappingRecords(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for _, record := range records {
+		root.InsertCidr(record.CIDR, record.Metadata)
+	}
+}
+
+// returns n records within 10.0.0.0/8, from /12 to /24, with random priorities
+func overlappingRecords(n int) []CidrRecord {
+	random := rand.New(rand.NewSource(1))
+	records := make([]CidrRecord, n)
+	for i := range records {
+		maskSize := 12 + random.Intn(13)
+		ip := net.IPv4(10, byte(random.Intn(256)), byte(random.Intn(256)), 0)
+		ipnet := &net.IPNet{IP: ip.Mask(net.CIDRMask(maskSize, 32)), Mask: net.CIDRMask(maskSize, 32)}
+		records[i] = CidrRecord{CIDR: ipnet, Metadata: &Metadata{Priority: []uint8{uint8(random.Intn(3))}}}
+	}
+	return records
+}
+
+func bulkPath(cidr string) ipbits.Path {
+	return ipbits.PathFromPrefix(netip.MustParsePrefix(cidr))
+}
@@ -0,0 +1,111 @@
+package supernet
+
+import (
+	"net/netip"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+)
+
+// number of bits consumed at each level of a compiled trie
+const compiledStride = 8
+
+// an entry with this bit set points to a child table, otherwise it holds a leaf index plus one, or 0 for no match
+const compiledChildFlag = uint32(1) << 31
+
+// Compiled is a read-only, level-compressed copy of the resolved CIDRs of a Supernet, built with Compile.
+//
+// Each level consumes 8 bits of the address, so a lookup takes at most 4 steps for IPv4 and 16 steps for IPv6,
+// instead of one step per bit. The tables are stored in flat arrays, and a lookup does not allocate.
+//
+// A Compiled is a snapshot: it is not affected by later insertions into the Supernet,
+// and it is safe for concurrent lookups.
+type Compiled struct {
+	ipv4 compiledFamily
+	ipv6 compiledFamily
+}
+
+// the compiled tries of an IP family
+type compiledFamily struct {
+	tables   []uint32 // tables of 256 entries, the first one is the root table
+	prefixes []netip.Prefix
+	metadata []*Metadata
+}
+
+// Compile freezes the resolved CIDRs into a Compiled lookup structure.
+// The metadata is shared with the supernet, so it must not be modified while the Compiled is in use.
+func (super *Supernet) Compile() *Compiled {
+	compiled := &Compiled{}
+	for _, forV6 := range []bool{false, true} {
+		family := &compiled.ipv4
+		if forV6 {
+			family = &compiled.ipv6
+		}
+		family.tables = make([]uint32, 1<<compiledStride)
+		super.ForEachCidr(forV6, func(cidr netip.Prefix, metadata *Metadata) bool {
+			family.add(cidr, metadata)
+			return true
+		})
+	}
+	return compiled
+}
+
+// adds a resolved CIDR, resolved CIDRs are disjoint, so its entries are never used by another CIDR
+func (family *compiledFamily) add(cidr netip.Prefix, metadata *Metadata) {
+	family.prefixes = append(family.prefixes, cidr)
+	family.metadata = append(family.metadata, metadata)
+	leaf := uint32(len(family.prefixes))
+
+	key := ipbits.FromAddr(cidr.Addr())
+	table := uint32(0)
+	for level := 0; ; level++ {
+		entry := table<<compiledStride | uint32(key.Byte(level))
+		levelEnd := (level + 1) * compiledStride
+		if cidr.Bits() <= levelEnd {
+			// the CIDR ends at this level, so it covers a range of entries
+			span := uint32(1) << (levelEnd - cidr.Bits())
+			for i := entry; i < entry+span; i++ {
+				family.tables[i] = leaf
+			}
+			return
+		}
+
+		if family.tables[entry]&compiledChildFlag == 0 {
+			child := uint32(len(family.tables) >> compiledStride)
+			family.tables = append(family.tables, make([]uint32, 1<<compiledStride)...)
+			family.tables[entry] = compiledChildFlag | child
+		}
+		table = family.tables[entry] &^ compiledChildFlag
+	}
+}
+
+// LookupAddr searches for the resolved CIDR containing addr, and returns it with its metadata.
+func (compiled *Compiled) LookupAddr(addr netip.Addr) (netip.Prefix, *Metadata, bool) {
+	addr = addr.Unmap()
+	family := &compiled.ipv4
+	if addr.Is6() {
+		family = &compiled.ipv6
+	}
+	key := ipbits.FromAddr(addr)
+
+	table := uint32(0)
+	for level := 0; level < addr.BitLen()/compiledStride; level++ {
+		entry := family.tables[table<<compiledStride|uint32(key.Byte(level))]
+		if entry&compiledChildFlag != 0 {
+			table = entry &^ compiledChildFlag
+			continue
+		}
+		if entry == 0 {
+			return netip.Prefix{}, nil, false
+		}
+		return family.prefixes[entry-1], family.metadata[entry-1], true
+	}
+	panic("[BUG] Compiled.LookupAddr: a full address must end on a leaf or a miss")
+}
+
+// returns the number of resolved CIDRs in the IPv4 or IPv6 tables
+func (compiled *Compiled) Len(forV6 bool) int {
+	if forV6 {
+		return len(compiled.ipv6.prefixes)
+	}
+	return len(compiled.ipv4.prefixes)
+}
@@ -0,0 +1,92 @@
+package supernet
+
+import (
+	"math/rand"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompiledLookupAddr(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"192.168.1.0/24", "192.168.0.0/16", "10.0.0.0/8", "10.1.1.1/32", "2001:db8::/32", "2001:db8:1::/48"}, []uint8{1, 0, 0, 1, 0, 1})
+	compiled := super.Compile()
+
+	testCases := []struct {
+		addr     string
+		expected string
+	}{
+		{"192.168.1.1", "192.168.1.0/24"},
+		{"192.168.0.255", "192.168.0.0/24"},
+		{"192.168.200.1", "192.168.128.0/17"},
+		{"10.1.1.1", "10.1.1.1/32"},
+		{"10.1.1.2", "10.1.1.2/31"},
+		{"::ffff:10.1.1.1", "10.1.1.1/32"},
+		{"2001:db8:1::1", "2001:db8:1::/48"},
+		{"2001:db8:ffff::1", "2001:db8:8000::/33"},
+		{"11.0.0.1", ""},
+		{"2001:db9::1", ""},
+	}
+
+	for _, tc := range testCases {
+		prefix, metadata, found := compiled.LookupAddr(netip.MustParseAddr(tc.addr))
+		if tc.expected == "" {
+			assert.False(t, found, tc.addr)
+			continue
+		}
+		assert.True(t, found, tc.addr)
+		assert.Equal(t, tc.expected, prefix.String(), tc.addr)
+		expectedPrefix, expectedMetadata, _ := super.LookupAddr(netip.MustParseAddr(tc.addr))
+		assert.Equal(t, expectedPrefix, prefix, tc.addr)
+		assert.Same(t, expectedMetadata, metadata, tc.addr)
+	}
+}
+
+func TestCompiledMatchesSupernet(t *testing.T) {
+	random := rand.New(rand.NewSource(5))
+	super := NewSupernet()
+	for i := 0; i < 2000; i++ {
+		maskSize := 8 + random.Intn(25)
+		ip := net.IPv4(byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256)))
+		super.InsertCidr(&net.IPNet{IP: ip.Mask(net.CIDRMask(maskSize, 32)), Mask: net.CIDRMask(maskSize, 32)}, &Metadata{Priority: []uint8{uint8(random.Intn(3))}})
+	}
+	compiled := super.Compile()
+	assert.Equal(t, len(super.AllCidrsString(false)), compiled.Len(false))
+
+	for i := 0; i < 10000; i++ {
+		addr := netip.AddrFrom4([4]byte{byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256))})
+		expectedPrefix, expectedMetadata, expectedFound := super.LookupAddr(addr)
+		prefix, metadata, found := compiled.LookupAddr(addr)
+		assert.Equal(t, expectedFound, found, addr.String())
+		assert.Equal(t, expectedPrefix, prefix, addr.String())
+		assert.Same(t, expectedMetadata, metadata, addr.String())
+	}
+}
+
+func TestCompiledLookupAddrDoesNotAllocate(t *testing.T) {
+	super, addrs := benchmarkSupernet(false)
+	compiled := super.Compile()
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, addr := range addrs {
+			compiled.LookupAddr(addr)
+		}
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+// compared to walking the binary trie, on the same random IPs, that mostly miss (see BenchmarkLookupIPHit):
+// BenchmarkCompiledLookupAddr 	159548806	        15.49 ns/op	       0 B/op	       0 allocs/op
+// BenchmarkLookupIP           	11478847	       187.6 ns/op	       0 B/op	       0 allocs/op
+// BenchmarkLookupAddr         	16210684	       141.8 ns/op	       0 B/op	       0 allocs/op
+func BenchmarkCompiledLookupAddr(b *testing.B) {
+	super, addrs := benchmarkSupernet(false)
+	compiled := super.Compile()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		compiled.LookupAddr(addrs[i%len(addrs)])
+	}
+}
@@ -1,7 +1,10 @@
 package supernet
 
+import "fmt"
+
 type ConflictType interface {
 	String() string
+	Kind() ConflictKind
 	Resolve(conflictedCidr *CidrTrie, newCidr *CidrTrie, comparator func(a *Metadata, b *Metadata) bool) *ResolutionPlan
 }
 
@@ -12,12 +15,44 @@ type (
 	SubCIDR    struct{} // the new CIDR is a sub CIDR of an existing super CIDR
 )
 
+// ConflictKind is a stable identifier of a ConflictType, used when results are serialized.
+type ConflictKind int
+
+const (
+	NoConflictKind ConflictKind = iota
+	EqualCIDRKind
+	SuperCIDRKind
+	SubCIDRKind
+)
+
+func (kind ConflictKind) String() string {
+	switch kind {
+	case NoConflictKind:
+		return "no_conflict"
+	case EqualCIDRKind:
+		return "equal_cidr"
+	case SuperCIDRKind:
+		return "super_cidr"
+	case SubCIDRKind:
+		return "sub_cidr"
+	}
+	return fmt.Sprintf("conflict_kind(%d)", int(kind))
+}
+
+func (kind ConflictKind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
+}
+
 func (_ NoConflict) Resolve(at *CidrTrie, newCidr *CidrTrie, comparator func(a *Metadata, b *Metadata) bool) *ResolutionPlan {
 	plan := &ResolutionPlan{}
 	plan.AddAction(InsertNewCIDR{}, at)
 	return plan
 }
 
+func (_ NoConflict) Kind() ConflictKind {
+	return NoConflictKind
+}
+
 func (_ NoConflict) String() string {
 	return "No Conflict"
 }
@@ -36,6 +71,10 @@ func (_ EqualCIDR) Resolve(conflictedCidr *CidrTrie, newCidr *CidrTrie, comparat
 	return plan
 }
 
+func (_ EqualCIDR) Kind() ConflictKind {
+	return EqualCIDRKind
+}
+
 func (_ EqualCIDR) String() string {
 	return "Equal CIDR"
 }
@@ -78,6 +117,10 @@ func (_ SuperCIDR) Resolve(conflictPoint *CidrTrie, newSuperCidr *CidrTrie, comp
 	return plan
 }
 
+func (_ SuperCIDR) Kind() ConflictKind {
+	return SuperCIDRKind
+}
+
 func (_ SuperCIDR) String() string {
 	return "Super CIDR"
 }
@@ -101,6 +144,10 @@ func (_ SubCIDR) Resolve(existingSuperCidr *CidrTrie, newSubCidr *CidrTrie, comp
 	return plan
 }
 
+func (_ SubCIDR) Kind() ConflictKind {
+	return SubCIDRKind
+}
+
 func (_ SubCIDR) String() string {
 	return "Sub CIDR"
 }
@@ -0,0 +1,223 @@
+package supernet
+
+import (
+	"math/big"
+	"net/netip"
+	"sort"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+)
+
+// ChangeKind is how a part of the address space changed between two supernets, see Diff.
+type ChangeKind int
+
+const (
+	Added      ChangeKind = iota // covered by the new supernet only
+	Removed                      // covered by the old supernet only
+	Reassigned                   // covered by both, with different attributes
+)
+
+func (kind ChangeKind) String() string {
+	switch kind {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Reassigned:
+		return "reassigned"
+	}
+	return "unknown"
+}
+
+// Change is a CIDR whose resolved attributes differ between two supernets.
+type Change struct {
+	Kind        ChangeKind
+	CIDR        netip.Prefix
+	Old         *Metadata // nil if added
+	New         *Metadata // nil if removed
+	ChangedKeys []string  // sorted attribute keys with a different value, or missing on one side, only for reassigned CIDRs
+}
+
+// Addresses returns the number of addresses in the CIDR of the change.
+func (change *Change) Addresses() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(change.CIDR.Addr().BitLen()-change.CIDR.Bits()))
+}
+
+// Diff compares the resolved CIDRs of old and new, and calls f with each change in address order, IPv4 first, until f returns false.
+//
+// The address space is compared, not the CIDRs, so a CIDR split into smaller CIDRs with the same attributes is not a change.
+// The changes are the largest CIDRs where the change is the same, e.g. a /8 reassigned except for a /24 is reported
+// as the 16 CIDRs covering the /8 without the /24, and the metadata of a change is the one of its first address.
+// The attributes in ignoredKeys are not compared, e.g. the CIDR column of the input records.
+func Diff(old *Supernet, new *Supernet, ignoredKeys []string, f func(change *Change) bool) {
+	for _, forV6 := range []bool{false, true} {
+		merger := &changeMerger{f: f, ignoredKeys: ignoredKeys}
+		if !diffFamily(old.Cidrs(forV6), new.Cidrs(forV6), forV6, ignoredKeys, merger.add) || !merger.flush() {
+			return
+		}
+	}
+}
+
+// a resolved CIDR, or a part of it, left to compare
+type diffPiece struct {
+	path     ipbits.Path
+	metadata *Metadata
+}
+
+// pulls the pieces of the resolved CIDRs in address order, a piece can be split to be compared with smaller ones
+type diffCursor struct {
+	it      *CidrIterator
+	pending []diffPiece // the next piece last
+}
+
+func (c *diffCursor) peek() (diffPiece, bool) {
+	if len(c.pending) == 0 {
+		if !c.it.Next() {
+			return diffPiece{}, false
+		}
+		c.pending = append(c.pending, diffPiece{path: ipbits.PathFromPrefix(c.it.Prefix()), metadata: c.it.Metadata()})
+	}
+	return c.pending[len(c.pending)-1], true
+}
+
+func (c *diffCursor) pop() {
+	c.pending = c.pending[:len(c.pending)-1]
+}
+
+// replaces the next piece with its two halves
+func (c *diffCursor) split() {
+	piece := c.pending[len(c.pending)-1]
+	c.pop()
+	for _, bit := range []int{1, 0} {
+		half := ipbits.Path{Key: piece.path.Key.WithBit(piece.path.Len, bit), Len: piece.path.Len + 1}
+		c.pending = append(c.pending, diffPiece{path: half, metadata: piece.metadata})
+	}
+}
+
+// compares the pieces of both iterators, it returns false if emit stopped the diff
+func diffFamily(oldCidrs *CidrIterator, newCidrs *CidrIterator, isV6 bool, ignoredKeys []string, emit func(*Change) bool) bool {
+	old, new := &diffCursor{it: oldCidrs}, &diffCursor{it: newCidrs}
+	for {
+		a, hasOld := old.peek()
+		b, hasNew := new.peek()
+		var change *Change
+		switch {
+		case !hasOld && !hasNew:
+			return true
+		case !hasNew || hasOld && !a.path.Contains(b.path) && !b.path.Contains(a.path) && a.path.Key.Compare(b.path.Key) < 0:
+			change = &Change{Kind: Removed, CIDR: a.path.Prefix(isV6), Old: a.metadata}
+			old.pop()
+		case !hasOld || !a.path.Contains(b.path) && !b.path.Contains(a.path):
+			change = &Change{Kind: Added, CIDR: b.path.Prefix(isV6), New: b.metadata}
+			new.pop()
+		case a.path.Len < b.path.Len:
+			old.split()
+		case b.path.Len < a.path.Len:
+			new.split()
+		default:
+			if changedKeys := changedAttributes(a.metadata.Attributes, b.metadata.Attributes, ignoredKeys); len(changedKeys) > 0 {
+				change = &Change{Kind: Reassigned, CIDR: a.path.Prefix(isV6), Old: a.metadata, New: b.metadata, ChangedKeys: changedKeys}
+			}
+			old.pop()
+			new.pop()
+		}
+		if change != nil && !emit(change) {
+			return false
+		}
+	}
+}
+
+// returns the sorted keys with different values in old and new, or missing in one of them
+func changedAttributes(old map[string]string, new map[string]string, ignoredKeys []string) []string {
+	changed := []string{}
+	for key, value := range old {
+		if newValue, found := new[key]; (!found || newValue != value) && !containsKey(ignoredKeys, key) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range new {
+		if _, found := old[key]; !found && !containsKey(ignoredKeys, key) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// merges the sibling changes that are the same into their parent CIDR, before passing them to f
+type changeMerger struct {
+	f           func(*Change) bool
+	ignoredKeys []string
+	pending     []*Change // contiguous changes in address order, which may still be merged with the next ones
+}
+
+func (m *changeMerger) add(change *Change) bool {
+	if len(m.pending) > 0 && !isNextPrefix(m.pending[len(m.pending)-1].CIDR, change.CIDR) {
+		// there is a gap, the pending changes can not be merged anymore
+		if !m.flush() {
+			return false
+		}
+	}
+	m.pending = append(m.pending, change)
+
+	for len(m.pending) >= 2 {
+		zero, one := m.pending[len(m.pending)-2], m.pending[len(m.pending)-1]
+		if zero.CIDR.Bits() != one.CIDR.Bits() || !isZeroHalf(zero.CIDR) || !sameChange(zero, one, m.ignoredKeys) {
+			break
+		}
+		// the changes are contiguous, so two halves of the same length are siblings
+		merged := *zero
+		merged.CIDR, _ = zero.CIDR.Addr().Prefix(zero.CIDR.Bits() - 1)
+		m.pending = append(m.pending[:len(m.pending)-2], &merged)
+	}
+
+	// only a first half can be merged with the changes after it
+	if last := m.pending[len(m.pending)-1]; !isZeroHalf(last.CIDR) {
+		return m.flush()
+	}
+	return true
+}
+
+// emits all the pending changes
+func (m *changeMerger) flush() bool {
+	for _, pending := range m.pending {
+		if !m.f(pending) {
+			return false
+		}
+	}
+	m.pending = m.pending[:0]
+	return true
+}
+
+// reports whether the prefix is the first half of its parent
+func isZeroHalf(prefix netip.Prefix) bool {
+	return prefix.Bits() > 0 && ipbits.PathFromPrefix(prefix).LastBit() == 0
+}
+
+// reports whether next starts right after the last address of prefix
+func isNextPrefix(prefix netip.Prefix, next netip.Prefix) bool {
+	path := ipbits.PathFromPrefix(prefix)
+	last := path.Key.Last(path.Len).Addr(prefix.Addr().Is6())
+	return last.Next() == next.Addr()
+}
+
+// reports whether two changes can be merged, their attributes are compared, since the metadata of split CIDRs are copies
+func sameChange(a *Change, b *Change, ignoredKeys []string) bool {
+	return a.Kind == b.Kind && sameAttributes(a.Old, b.Old, ignoredKeys) && sameAttributes(a.New, b.New, ignoredKeys)
+}
+
+func sameAttributes(a *Metadata, b *Metadata, ignoredKeys []string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return len(changedAttributes(a.Attributes, b.Attributes, ignoredKeys)) == 0
+}
@@ -0,0 +1,130 @@
+package supernet
+
+import (
+	"math/rand"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func diffSummary(old *Supernet, new *Supernet) []string {
+	summary := []string{}
+	Diff(old, new, []string{"cidr"}, func(change *Change) bool {
+		line := change.Kind.String() + " " + change.CIDR.String()
+		for _, key := range change.ChangedKeys {
+			line += " " + key
+		}
+		summary = append(summary, line)
+		return true
+	})
+	return summary
+}
+
+func TestDiff(t *testing.T) {
+	old := NewSupernet()
+	insertAll(old, []string{"10.0.0.0/8", "11.0.0.0/8", "13.0.0.0/8", "2001:db8::/32"}, []uint8{0, 0, 0, 0})
+	new := NewSupernet()
+	insertAll(new, []string{"10.0.0.0/8", "10.1.0.0/16", "12.0.0.0/8", "13.0.0.0/9", "2001:db8::/32"}, []uint8{0, 1, 0, 0, 0})
+	new.InsertCidr(cidr("2001:db8:1::/48"), &Metadata{Priority: []uint8{1}, Attributes: map[string]string{"cidr": "2001:db8:1::/48", "extra": "x"}})
+
+	// the attributes of insertAll are only the cidr, which is ignored, so the CIDRs from different origins have the same attributes
+	assert.Equal(t, []string{
+		"removed 11.0.0.0/8",
+		"added 12.0.0.0/8",
+		"removed 13.128.0.0/9",
+		"reassigned 2001:db8:1::/48 extra",
+	}, diffSummary(old, new))
+
+	assert.Empty(t, diffSummary(new, new))
+	assert.Equal(t, []string{"removed 10.0.0.0/7", "removed 13.0.0.0/8", "removed 2001:db8::/32"}, diffSummary(old, NewSupernet()))
+	assert.Equal(t, []string{"added 10.0.0.0/7", "added 13.0.0.0/8", "added 2001:db8::/32"}, diffSummary(NewSupernet(), old))
+}
+
+func TestDiffMergesChanges(t *testing.T) {
+	old := NewSupernet()
+	old.InsertCidr(cidr("10.0.0.0/8"), &Metadata{Attributes: map[string]string{"name": "a"}})
+	new := NewSupernet()
+	new.InsertCidr(cidr("10.0.0.0/8"), &Metadata{Priority: []uint8{0}, Attributes: map[string]string{"name": "b"}})
+	new.InsertCidr(cidr("10.1.0.0/16"), &Metadata{Priority: []uint8{1}, Attributes: map[string]string{"name": "a"}})
+
+	changes := []*Change{}
+	Diff(old, new, nil, func(change *Change) bool {
+		changes = append(changes, change)
+		return true
+	})
+	// the /8 is reassigned, except the /16, which is covered by the 8 largest CIDRs around it
+	assert.Len(t, changes, 8)
+	addresses := int64(0)
+	for _, change := range changes {
+		assert.Equal(t, Reassigned, change.Kind)
+		assert.Equal(t, []string{"name"}, change.ChangedKeys)
+		assert.Equal(t, "a", change.Old.Attributes["name"])
+		assert.Equal(t, "b", change.New.Attributes["name"])
+		addresses += change.Addresses().Int64()
+	}
+	assert.Equal(t, int64(1<<24-1<<16), addresses)
+
+	// the diff stops when f returns false
+	count := 0
+	Diff(old, new, nil, func(change *Change) bool {
+		count++
+		return false
+	})
+	assert.Equal(t, 1, count)
+}
+
+func TestDiffMatchesLookups(t *testing.T) {
+	random := rand.New(rand.NewSource(9))
+	randomSupernet := func() *Supernet {
+		super := NewSupernet()
+		for i := 0; i < 300; i++ {
+			maskSize := 8 + random.Intn(17)
+			ip := net.IPv4(10, byte(random.Intn(4)), byte(random.Intn(256)), 0).Mask(net.CIDRMask(maskSize, 32))
+			super.InsertCidr(&net.IPNet{IP: ip, Mask: net.CIDRMask(maskSize, 32)}, &Metadata{
+				Priority:   []uint8{uint8(random.Intn(3))},
+				Attributes: map[string]string{"name": string(rune('a' + random.Intn(3)))},
+			})
+		}
+		return super
+	}
+	old, new := randomSupernet(), randomSupernet()
+
+	changes := []*Change{}
+	Diff(old, new, nil, func(change *Change) bool {
+		if len(changes) > 0 {
+			previous := changes[len(changes)-1]
+			assert.True(t, previous.CIDR.Addr().Less(change.CIDR.Addr()))
+			assert.False(t, previous.CIDR.Overlaps(change.CIDR))
+			siblings := previous.CIDR.Bits() == change.CIDR.Bits() && isZeroHalf(previous.CIDR) && isNextPrefix(previous.CIDR, change.CIDR)
+			assert.False(t, siblings && sameChange(previous, change, nil), "changes must be merged: %s %s", previous.CIDR, change.CIDR)
+		}
+		changes = append(changes, change)
+		return true
+	})
+	assert.NotEmpty(t, changes)
+
+	for i := 0; i < 20000; i++ {
+		addr := netip.AddrFrom4([4]byte{10, byte(random.Intn(5)), byte(random.Intn(256)), byte(random.Intn(256))})
+		_, oldMetadata, inOld := old.LookupAddr(addr)
+		_, newMetadata, inNew := new.LookupAddr(addr)
+
+		var found *Change
+		for _, change := range changes {
+			if change.CIDR.Contains(addr) {
+				found = change
+			}
+		}
+		switch {
+		case inOld && inNew && oldMetadata.Attributes["name"] != newMetadata.Attributes["name"]:
+			assert.Equal(t, Reassigned, found.Kind, addr.String())
+		case inOld && !inNew:
+			assert.Equal(t, Removed, found.Kind, addr.String())
+		case !inOld && inNew:
+			assert.Equal(t, Added, found.Kind, addr.String())
+		default:
+			assert.Nil(t, found, addr.String())
+		}
+	}
+}
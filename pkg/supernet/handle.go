@@ -0,0 +1,215 @@
+package supernet
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Lookuper finds the resolved CIDR containing an address, it is implemented by Supernet, Compiled and Reader.
+type Lookuper interface {
+	LookupAddr(addr netip.Addr) (netip.Prefix, *Metadata, bool)
+}
+
+// PrefixLookuper finds the resolved CIDRs overlapping a prefix, it is implemented by Supernet and Reader.
+type PrefixLookuper interface {
+	LookupPrefix(prefix netip.Prefix, f func(cidr netip.Prefix, metadata *Metadata) bool)
+}
+
+var (
+	_ Lookuper       = (*Supernet)(nil)
+	_ Lookuper       = (*Compiled)(nil)
+	_ Lookuper       = (*Reader)(nil)
+	_ PrefixLookuper = (*Supernet)(nil)
+	_ PrefixLookuper = (*Reader)(nil)
+)
+
+// LoadFunc loads a new dataset for a Handle, release is called once the dataset is not used anymore, it may be nil.
+type LoadFunc func() (lookuper Lookuper, release func(), err error)
+
+// Handle holds the current dataset used for lookups, and replaces it atomically with Swap or Reload,
+// so a long-running service can reload its data without downtime.
+//
+// The lookups that started before a swap finish on the previous dataset, which is released
+// once the last of them is done, e.g. a Reader is only unmapped when no lookup uses it anymore.
+type Handle struct {
+	current atomic.Pointer[handleVersion]
+	mu      sync.Mutex // serializes the reloads
+}
+
+// a dataset held by a handle, its references are the in-flight lookups, plus one while it is the current dataset
+type handleVersion struct {
+	lookuper Lookuper
+	refs     atomic.Int64
+	release  sync.Once
+	onFree   func()
+}
+
+// NewHandle creates a handle holding lookuper, release is called once it is replaced and not used anymore, it may be nil.
+func NewHandle(lookuper Lookuper, release func()) *Handle {
+	h := &Handle{}
+	h.current.Store(newHandleVersion(lookuper, release))
+	return h
+}
+
+func newHandleVersion(lookuper Lookuper, release func()) *handleVersion {
+	version := &handleVersion{lookuper: lookuper, onFree: release}
+	version.refs.Store(1)
+	return version
+}
+
+// LookupAddr searches addr in the current dataset, a closed handle finds nothing.
+func (h *Handle) LookupAddr(addr netip.Addr) (netip.Prefix, *Metadata, bool) {
+	version := h.acquire()
+	if version == nil {
+		return netip.Prefix{}, nil, false
+	}
+	defer version.done()
+	return version.lookuper.LookupAddr(addr)
+}
+
+// View calls f with the current dataset, which is not released before f returns,
+// so several lookups can be done on the same version of the data.
+func (h *Handle) View(f func(lookuper Lookuper)) {
+	version := h.acquire()
+	if version == nil {
+		return
+	}
+	defer version.done()
+	f(version.lookuper)
+}
+
+// Swap replaces the current dataset, the previous one is released once its in-flight lookups are done.
+func (h *Handle) Swap(lookuper Lookuper, release func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if previous := h.current.Swap(newHandleVersion(lookuper, release)); previous != nil {
+		previous.done()
+	}
+}
+
+// Reload loads a new dataset and swaps it in, on error the current dataset is kept.
+func (h *Handle) Reload(load LoadFunc) error {
+	lookuper, release, err := load()
+	if err != nil {
+		return err
+	}
+	h.Swap(lookuper, release)
+	return nil
+}
+
+// Close releases the current dataset once its in-flight lookups are done, the lookups after Close find nothing.
+func (h *Handle) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if previous := h.current.Swap(nil); previous != nil {
+		previous.done()
+	}
+}
+
+// returns the current version with a reference taken, or nil if the handle is closed
+func (h *Handle) acquire() *handleVersion {
+	for {
+		version := h.current.Load()
+		if version == nil {
+			return nil
+		}
+		if version.tryAcquire() {
+			return version
+		}
+		// the version was swapped and released meanwhile, the next load returns its replacement
+	}
+}
+
+// takes a reference, unless the last one was already dropped, then the dataset may be released and must not be used.
+// The count never goes back from 0 to 1, so another lookup can not take the dataset while it is released.
+func (v *handleVersion) tryAcquire() bool {
+	for {
+		refs := v.refs.Load()
+		if refs == 0 {
+			return false
+		}
+		if v.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// drops a reference, the last one releases the dataset
+func (v *handleVersion) done() {
+	if v.refs.Add(-1) == 0 && v.onFree != nil {
+		v.release.Do(v.onFree)
+	}
+}
+
+// ReloadOnSignal reloads the handle each time one of the signals is received (e.g. syscall.SIGHUP), until ctx is done.
+// Reload errors are passed to onError, and the current dataset is kept.
+func (h *Handle) ReloadOnSignal(ctx context.Context, load LoadFunc, onError func(error), signals ...os.Signal) {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	defer signal.Stop(received)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-received:
+			if err := h.Reload(load); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// ReloadOnChange checks the modification time and size of the file at path every interval,
+// and reloads the handle when they change, until ctx is done.
+// Reload errors are passed to onError, and the current dataset is kept.
+//
+// The file should be replaced atomically (written to a temporary file, then renamed), so a partially written file is never loaded.
+func (h *Handle) ReloadOnChange(ctx context.Context, path string, interval time.Duration, load LoadFunc, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastModTime time.Time
+	var lastSize int64
+	if info, err := os.Stat(path); err == nil {
+		lastModTime, lastSize = info.ModTime(), info.Size()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				if onError != nil {
+					onError(err)
+				}
+				continue
+			}
+			if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+				continue
+			}
+			lastModTime, lastSize = info.ModTime(), info.Size()
+			if err = h.Reload(load); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// ReaderLoader returns a LoadFunc opening the file at path with OpenReader, the reader is closed when released.
+func ReaderLoader(path string) LoadFunc {
+	return func() (Lookuper, func(), error) {
+		reader, err := OpenReader(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, func() { reader.Close() }, nil
+	}
+}
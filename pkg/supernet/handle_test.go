@@ -0,0 +1,200 @@
+package supernet
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a lookuper blocking its lookups until unblock is closed
+type blockingLookuper struct {
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (l *blockingLookuper) LookupAddr(addr netip.Addr) (netip.Prefix, *Metadata, bool) {
+	close(l.started)
+	<-l.unblock
+	return netip.MustParsePrefix("10.0.0.0/8"), nil, true
+}
+
+func TestHandleSwap(t *testing.T) {
+	old := NewSupernet()
+	insertAll(old, []string{"10.0.0.0/8"}, []uint8{0})
+	released := false
+	handle := NewHandle(old, func() { released = true })
+
+	prefix, _, found := handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+	assert.True(t, found)
+	assert.Equal(t, "10.0.0.0/8", prefix.String())
+
+	updated := NewSupernet()
+	insertAll(updated, []string{"10.1.0.0/16"}, []uint8{0})
+	handle.Swap(updated, nil)
+	assert.True(t, released)
+
+	prefix, _, _ = handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+	assert.Equal(t, "10.1.0.0/16", prefix.String())
+
+	handle.Close()
+	_, _, found = handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+	assert.False(t, found)
+}
+
+func TestHandleInFlightLookupsFinishOnOldVersion(t *testing.T) {
+	blocking := &blockingLookuper{started: make(chan struct{}), unblock: make(chan struct{})}
+	var released atomic.Bool
+	handle := NewHandle(blocking, func() { released.Store(true) })
+
+	done := make(chan netip.Prefix)
+	go func() {
+		prefix, _, _ := handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+		done <- prefix
+	}()
+	<-blocking.started
+
+	handle.Swap(NewSupernet(), nil)
+	assert.False(t, released.Load(), "the old version must not be released during a lookup")
+	_, _, found := handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+	assert.False(t, found, "new lookups use the new version")
+
+	close(blocking.unblock)
+	assert.Equal(t, "10.0.0.0/8", (<-done).String())
+	assert.True(t, released.Load())
+}
+
+func TestHandleConcurrentSwaps(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"10.0.0.0/8"}, []uint8{0})
+	var releases atomic.Int64
+	handle := NewHandle(super, func() { releases.Add(1) })
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_, _, found := handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+				assert.True(t, found)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		handle.Swap(super, func() { releases.Add(1) })
+	}
+	wg.Wait()
+	handle.Close()
+	assert.Equal(t, int64(101), releases.Load())
+}
+
+// a lookuper finding nothing once it is released
+type releasedLookuper struct {
+	released atomic.Bool
+}
+
+func (l *releasedLookuper) LookupAddr(addr netip.Addr) (netip.Prefix, *Metadata, bool) {
+	if l.released.Load() {
+		return netip.Prefix{}, nil, false
+	}
+	// the dataset must not be released during the lookup either
+	return netip.MustParsePrefix("10.0.0.0/8"), nil, !l.released.Load()
+}
+
+func newReleasedLookuper() (*releasedLookuper, func()) {
+	lookuper := &releasedLookuper{}
+	return lookuper, func() { lookuper.released.Store(true) }
+}
+
+func TestHandleLookupsNeverSeeAReleasedVersion(t *testing.T) {
+	handle := NewHandle(newReleasedLookuper())
+	defer handle.Close()
+
+	// swaps until the lookups are done, so the lookups run while the versions are released
+	ctx, stop := context.WithCancel(context.Background())
+	swapped := make(chan int)
+	go func() {
+		swaps := 0
+		for ; ctx.Err() == nil; swaps++ {
+			handle.Swap(newReleasedLookuper())
+		}
+		swapped <- swaps
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				if _, _, found := handle.LookupAddr(netip.MustParseAddr("10.1.1.1")); !found {
+					t.Error("a lookup used a released version")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	stop()
+	assert.Positive(t, <-swapped)
+}
+
+func TestHandleReload(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"10.0.0.0/8"}, []uint8{0})
+	path := filepath.Join(t.TempDir(), "supernet.db")
+	saveTestFile(t, super, path)
+
+	handle := &Handle{}
+	assert.NoError(t, handle.Reload(ReaderLoader(path)))
+	defer handle.Close()
+
+	// a failed reload keeps the current dataset
+	assert.Error(t, handle.Reload(ReaderLoader(path+".missing")))
+	_, _, found := handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+	assert.True(t, found)
+
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	go handle.ReloadOnChange(watchCtx, path, 10*time.Millisecond, ReaderLoader(path), nil)
+	time.Sleep(20 * time.Millisecond) // let the watcher start
+
+	insertAll(super, []string{"10.1.0.0/16"}, []uint8{1})
+	saveTestFile(t, super, path)
+	assert.Eventually(t, func() bool {
+		prefix, _, _ := handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+		return prefix.String() == "10.1.0.0/16"
+	}, time.Second, 10*time.Millisecond)
+
+	// only the signal can trigger the next reload
+	stopWatching()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handle.ReloadOnSignal(ctx, ReaderLoader(path), nil, syscall.SIGHUP)
+	time.Sleep(20 * time.Millisecond) // let the signal handler start
+
+	insertAll(super, []string{"10.1.1.0/24"}, []uint8{1})
+	saveTestFile(t, super, path)
+	process, _ := os.FindProcess(os.Getpid())
+	assert.NoError(t, process.Signal(syscall.SIGHUP))
+	assert.Eventually(t, func() bool {
+		prefix, _, _ := handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+		return prefix.String() == "10.1.1.0/24"
+	}, time.Second, 10*time.Millisecond)
+}
+
+// saves super to path, by renaming a temporary file so a reload never sees a partial file
+func saveTestFile(t *testing.T, super *Supernet, path string) {
+	file, err := os.Create(path + ".tmp")
+	assert.NoError(t, err)
+	assert.NoError(t, super.Save(file))
+	assert.NoError(t, file.Close())
+	assert.NoError(t, os.Rename(path+".tmp", path))
+}
@@ -0,0 +1,139 @@
+package supernet
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// AttributeStore interns the attributes of the inserted CIDRs: the CIDRs with equal attributes share a single map,
+// and the keys and values are stored once, whatever the number of CIDRs using them.
+//
+// The distinct attribute sets are also kept in columns, one per key, holding the value of each set,
+// which is a compact way to read a single attribute of many sets.
+//
+// An AttributeStore is attached to a Supernet using the WithAttributeStore option, and can be shared by several of them.
+// The interned maps are shared, so they must not be modified, except when all the CIDRs using them should see the change.
+type AttributeStore struct {
+	mu         sync.Mutex
+	strings    map[string]string   // interned keys and values
+	setIndex   map[string]int      // canonical encoding of a set -> set id
+	sets       []map[string]string // interned set by id
+	columns    map[string][]string // key -> value of each set, an empty string if the set does not have the key
+	hasColumns map[string][]bool   // key -> whether each set has the key, to tell empty values from missing ones
+}
+
+// NewAttributeStore creates an empty attribute store.
+func NewAttributeStore() *AttributeStore {
+	return &AttributeStore{
+		strings:    map[string]string{},
+		setIndex:   map[string]int{},
+		columns:    map[string][]string{},
+		hasColumns: map[string][]bool{},
+	}
+}
+
+// Intern returns the id and the shared map of the attribute set equal to attributes, adding it if it is new.
+func (s *AttributeStore) Intern(attributes map[string]string) (int, map[string]string) {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	canonical := strings.Builder{}
+	for _, key := range keys {
+		writeCanonical(&canonical, key)
+		writeCanonical(&canonical, attributes[key])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, found := s.setIndex[canonical.String()]; found {
+		return id, s.sets[id]
+	}
+
+	id := len(s.sets)
+	set := make(map[string]string, len(attributes))
+	for _, key := range keys {
+		set[s.intern(key)] = s.intern(attributes[key])
+	}
+	s.sets = append(s.sets, set)
+	s.setIndex[canonical.String()] = id
+
+	for key := range s.columns {
+		if _, found := set[key]; !found {
+			s.columns[key] = append(s.columns[key], "")
+			s.hasColumns[key] = append(s.hasColumns[key], false)
+		}
+	}
+	for key, value := range set {
+		if _, found := s.columns[key]; !found {
+			// a new key, the previous sets do not have it
+			s.columns[key] = make([]string, id, id+1)
+			s.hasColumns[key] = make([]bool, id, id+1)
+		}
+		s.columns[key] = append(s.columns[key], value)
+		s.hasColumns[key] = append(s.hasColumns[key], true)
+	}
+	return id, set
+}
+
+// returns the interned copy of str, the caller holds the lock
+func (s *AttributeStore) intern(str string) string {
+	if interned, found := s.strings[str]; found {
+		return interned
+	}
+	s.strings[str] = str
+	return str
+}
+
+// writes str prefixed by its length, so the encoding of a set is unambiguous whatever the strings contain
+func writeCanonical(builder *strings.Builder, str string) {
+	builder.Write(binary.AppendUvarint(nil, uint64(len(str))))
+	builder.WriteString(str)
+}
+
+// Value returns the value of key in the attribute set id, reading it from the key column.
+func (s *AttributeStore) Value(id int, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 0 || id >= len(s.hasColumns[key]) || !s.hasColumns[key][id] {
+		return "", false
+	}
+	return s.columns[key][id], true
+}
+
+// Column returns a copy of the values of key for each attribute set, indexed by set id, and an empty string if a set does not have the key.
+func (s *AttributeStore) Column(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.columns[key]...)
+}
+
+// Keys returns the distinct attribute keys, sorted.
+func (s *AttributeStore) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.columns))
+	for key := range s.columns {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Len returns the number of distinct attribute sets.
+func (s *AttributeStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sets)
+}
+
+// Strings returns the number of distinct keys and values.
+func (s *AttributeStore) Strings() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.strings)
+}
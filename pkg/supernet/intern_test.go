@@ -0,0 +1,80 @@
+package supernet
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttributeStoreIntern(t *testing.T) {
+	store := NewAttributeStore()
+	id1, set1 := store.Intern(map[string]string{"country": "SA", "asn": "1"})
+	id2, set2 := store.Intern(map[string]string{"asn": "1", "country": "SA"})
+	id3, set3 := store.Intern(map[string]string{"country": "SA"})
+	// the lengths are part of the encoding, so the values can not be confused with the keys
+	id4, _ := store.Intern(map[string]string{"a": "b\x01c"})
+	id5, _ := store.Intern(map[string]string{"a": "b", "c": ""})
+
+	assert.Equal(t, id1, id2)
+	assert.Equal(t, reflect.ValueOf(set1).Pointer(), reflect.ValueOf(set2).Pointer())
+	assert.NotEqual(t, id1, id3)
+	assert.Equal(t, map[string]string{"country": "SA"}, set3)
+	assert.NotEqual(t, id4, id5)
+	assert.Equal(t, 4, store.Len())
+	assert.Equal(t, 9, store.Strings())
+}
+
+func TestAttributeStoreColumns(t *testing.T) {
+	store := NewAttributeStore()
+	id1, _ := store.Intern(map[string]string{"country": "SA"})
+	id2, _ := store.Intern(map[string]string{"country": "", "asn": "1"})
+	id3, _ := store.Intern(map[string]string{"asn": "2"})
+
+	assert.Equal(t, []string{"asn", "country"}, store.Keys())
+	assert.Equal(t, []string{"SA", "", ""}, store.Column("country"))
+	assert.Equal(t, []string{"", "1", "2"}, store.Column("asn"))
+
+	value, found := store.Value(id2, "country")
+	assert.True(t, found)
+	assert.Equal(t, "", value)
+	_, found = store.Value(id3, "country")
+	assert.False(t, found)
+	_, found = store.Value(id1, "asn")
+	assert.False(t, found)
+	_, found = store.Value(id1, "missing")
+	assert.False(t, found)
+}
+
+func TestWithAttributeStore(t *testing.T) {
+	store := NewAttributeStore()
+	super := NewSupernet(WithAttributeStore(store))
+	for _, s := range []string{"10.0.0.0/8", "11.0.0.0/8", "12.0.0.0/8"} {
+		super.InsertCidr(cidr(s), &Metadata{Attributes: map[string]string{"country": "SA"}})
+	}
+
+	_, first, _ := super.LookupAddr(netip.MustParseAddr("10.0.0.1"))
+	_, second, _ := super.LookupAddr(netip.MustParseAddr("12.0.0.1"))
+	assert.Equal(t, reflect.ValueOf(first.Attributes).Pointer(), reflect.ValueOf(second.Attributes).Pointer())
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestBulkLoadWithAttributeStore(t *testing.T) {
+	store := NewAttributeStore()
+	super := NewSupernet(WithAttributeStore(store))
+	super.InsertCidr(cidr("9.0.0.0/8"), &Metadata{Attributes: map[string]string{"country": "SA"}})
+	records := []CidrRecord{}
+	for _, s := range []string{"10.0.0.0/8", "10.1.0.0/16", "12.0.0.0/8"} {
+		records = append(records, CidrRecord{CIDR: cidr(s), Metadata: &Metadata{Attributes: map[string]string{"country": "SA"}}})
+	}
+	super.BulkLoad(records, nil)
+
+	// the bulk loaded records, and their fragments, share the attributes of the inserted CIDR
+	_, inserted, _ := super.LookupAddr(netip.MustParseAddr("9.0.0.1"))
+	for _, addr := range []string{"10.0.0.1", "10.1.0.1", "10.2.0.1", "12.0.0.1"} {
+		_, loaded, _ := super.LookupAddr(netip.MustParseAddr(addr))
+		assert.Equal(t, reflect.ValueOf(inserted.Attributes).Pointer(), reflect.ValueOf(loaded.Attributes).Pointer(), addr)
+	}
+	assert.Equal(t, 1, store.Len())
+}
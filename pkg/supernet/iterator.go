@@ -0,0 +1,90 @@
+package supernet
+
+import (
+	"net/netip"
+
+	"github.com/khalid-nowaf/supernet/pkg/trie"
+)
+
+// CidrIterator pulls the resolved CIDRs of an IP version one at a time, in address order.
+//
+// It walks the trie in place, so its memory does not grow with the number of CIDRs, and unlike AllCIDRS,
+// no node is built for radix tries. CIDRs must not be inserted or removed while iterating.
+//
+//	for it := super.Cidrs(false); it.Next(); {
+//		fmt.Println(it.Prefix(), it.Metadata().Attributes)
+//	}
+type CidrIterator struct {
+	forV6    bool
+	radix    *trie.RadixIterator[Metadata] // set for radix tries, instead of root and next
+	root     *CidrTrie                     // root of the binary trie
+	next     *CidrTrie                     // next node of the binary trie to visit, nil once done
+	prefix   netip.Prefix
+	metadata *Metadata
+}
+
+// Cidrs returns an iterator over the resolved CIDRs of the IPv4 or IPv6 trie.
+func (super *Supernet) Cidrs(forV6 bool) *CidrIterator {
+	if radix := super.radixTrie(forV6); radix != nil {
+		return &CidrIterator{forV6: forV6, radix: radix.Iterator()}
+	}
+	if forV6 {
+		return newCidrIterator(super.ipv6Cidrs, forV6)
+	}
+	return newCidrIterator(super.ipv4Cidrs, forV6)
+}
+
+// returns an iterator over the resolved CIDRs under root, which may be any node of a binary trie
+func newCidrIterator(root *CidrTrie, forV6 bool) *CidrIterator {
+	return &CidrIterator{forV6: forV6, root: root, next: root}
+}
+
+// Next advances to the next resolved CIDR, it returns false when there is none left.
+func (it *CidrIterator) Next() bool {
+	if it.radix != nil {
+		if !it.radix.Next() {
+			it.metadata = nil
+			return false
+		}
+		it.prefix, it.metadata = it.radix.Path().Prefix(it.forV6), it.radix.Value()
+		return true
+	}
+
+	for it.next != nil {
+		node := it.next
+		it.next = it.successor(node)
+		if node.Metadata() != nil {
+			it.prefix, it.metadata = NodeToPrefix(node), node.Metadata()
+			return true
+		}
+	}
+	it.metadata = nil
+	return false
+}
+
+// returns the current CIDR, Next must have returned true
+func (it *CidrIterator) Prefix() netip.Prefix {
+	return it.prefix
+}
+
+// returns the metadata of the current CIDR, Next must have returned true
+func (it *CidrIterator) Metadata() *Metadata {
+	return it.metadata
+}
+
+// returns the node following node in a pre-order walk of the binary trie, following the parent links instead of keeping a stack
+func (it *CidrIterator) successor(node *CidrTrie) *CidrTrie {
+	for _, pos := range []int{trie.ZERO, trie.ONE} {
+		if child := node.Child(pos); child != nil {
+			return child
+		}
+	}
+	for ; node != it.root; node = node.Parent() {
+		if node.Pos() == trie.ZERO {
+			if sibling := node.Sibling(); sibling != nil {
+				return sibling
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package supernet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCidrIterator(t *testing.T) {
+	super := NewSupernet()
+	assert.False(t, super.Cidrs(false).Next())
+
+	for _, cidr := range randomCidrs(1000, 20) {
+		super.InsertCidr(cidr, &Metadata{Attributes: makeCidrAtrr(cidr.String())})
+	}
+	insertAll(super, []string{"2001:db8::/32", "2001:db8:1::/48"}, []uint8{0, 1})
+
+	for _, forV6 := range []bool{false, true} {
+		iterated := []string{}
+		for it := super.Cidrs(forV6); it.Next(); {
+			_, metadata, _ := super.LookupAddr(it.Prefix().Addr())
+			assert.Same(t, metadata, it.Metadata())
+			iterated = append(iterated, it.Prefix().String())
+		}
+
+		expected := []string{}
+		for _, leaf := range super.AllCIDRS(forV6) {
+			expected = append(expected, NodeToCidr(leaf))
+		}
+		assert.Equal(t, expected, iterated)
+	}
+}
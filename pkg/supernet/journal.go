@@ -0,0 +1,297 @@
+package supernet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+)
+
+// operations recorded in the journal
+const (
+	journalInsert = "insert"
+	journalRemove = "remove"
+)
+
+// a single line in the journal file
+type journalEntry struct {
+	Op         string            `json:"op"`
+	Cidr       string            `json:"cidr"`
+	Origin     string            `json:"origin,omitempty"` // only set when the CIDR is a fragment of a split CIDR
+	Priority   []int             `json:"priority,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Journal is an append-only, write-ahead log of the mutations applied to a Supernet.
+// Each InsertCidr and RemoveCidr call is written as a JSON line before it is applied,
+// so the Supernet can be rebuilt with Replay after a crash or a restart.
+// The Source of the CIDRs is not journaled, so the replayed CIDRs have none.
+//
+// A Journal is attached to a Supernet using the WithJournal option.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	file *os.File // nil once the journal could not be reopened after a compaction
+	err  error    // first write error, writes are skipped after it
+}
+
+// opens the compacted journal, it is replaced in the tests to fail the reopening
+var reopenJournal = func(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+}
+
+// OpenJournal opens the journal at path for appending, creating the file if it does not exist.
+// A torn last line (e.g. after a crash) is truncated, so the next entries start on a new line.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := truncateTornLine(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Journal{path: path, file: file}, nil
+}
+
+// truncates the file after its last new line, or to empty if it has none
+func truncateTornLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	chunk := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := max(end-int64(len(chunk)), 0)
+		n, err := file.ReadAt(chunk[:end-start], start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if i := bytes.LastIndexByte(chunk[:n], '\n'); i != -1 {
+			if start+int64(i)+1 == size {
+				return nil
+			}
+			return file.Truncate(start + int64(i) + 1)
+		}
+		end = start
+	}
+	if size == 0 {
+		return nil
+	}
+	return file.Truncate(0)
+}
+
+// Err returns the first error that happened while writing to the journal, if any.
+// InsertCidr and RemoveCidr do not return errors, so callers should check it periodically.
+// The entries appended after the error are not written, until a Compact succeeds.
+func (j *Journal) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Sync commits the journal content to stable storage.
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return j.err
+	}
+	return j.file.Sync()
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// Compact replaces the journal content with a snapshot of the resolved CIDRs in super.
+// Replaying the compacted journal produces the same Supernet as replaying the full history.
+//
+// The snapshot is written to a temporary file first, and renamed over the journal once it is synced,
+// so a crash during compaction leaves the previous journal intact.
+// If the compacted journal can not be reopened, the error is kept and returned by Err and Sync,
+// and the next entries are not written, until another Compact reopens the journal.
+func (j *Journal) Compact(super *Supernet) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmpPath := j.path + ".compact"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(tmp)
+	for _, forV6 := range []bool{false, true} {
+		super.ForEachCidr(forV6, func(cidr netip.Prefix, metadata *Metadata) bool {
+			entry := newJournalEntry(journalInsert, cidr.String(), metadata)
+			if entry.Cidr != metadata.originCIDR.String() {
+				entry.Origin = metadata.originCIDR.String()
+			}
+			err = encoder.Encode(entry)
+			return err == nil
+		})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, j.path); err != nil {
+		return err
+	}
+
+	// the old file handle points to the replaced file, so we reopen the journal
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	file, err := reopenJournal(j.path)
+	if err != nil {
+		// the entries appended to the old handle would be lost
+		j.err = fmt.Errorf("journal: reopening after compaction: %w", err)
+		return j.err
+	}
+	j.file = file
+	j.err = nil
+	return nil
+}
+
+// records an insertion, it is a no-op on a nil journal
+func (j *Journal) appendInsert(ipnet *net.IPNet, metadata *Metadata) {
+	if j == nil {
+		return
+	}
+	entry := newJournalEntry(journalInsert, ipnet.String(), metadata)
+	if metadata.originCIDR != nil && metadata.originCIDR.String() != entry.Cidr {
+		entry.Origin = metadata.originCIDR.String()
+	}
+	j.append(entry)
+}
+
+// records a removal, it is a no-op on a nil journal
+func (j *Journal) appendRemove(ipnet *net.IPNet) {
+	if j == nil {
+		return
+	}
+	j.append(&journalEntry{Op: journalRemove, Cidr: ipnet.String()})
+}
+
+func (j *Journal) append(entry *journalEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		panic("[BUG] Journal.append: journal entry must be serializable: " + err.Error())
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return
+	}
+	// one write per entry, so a crash can only tear the last line
+	_, j.err = j.file.Write(append(line, '\n'))
+}
+
+func newJournalEntry(op string, cidr string, metadata *Metadata) *journalEntry {
+	priority := make([]int, len(metadata.Priority))
+	for i, p := range metadata.Priority {
+		priority[i] = int(p)
+	}
+	return &journalEntry{
+		Op:         op,
+		Cidr:       cidr,
+		Priority:   priority,
+		Attributes: metadata.Attributes,
+	}
+}
+
+// Replay rebuilds a Supernet from the journal at path, by applying its entries in order.
+// The options are applied to the new Supernet before replaying, but the entries are not journaled again,
+// attach the journal with WithJournal after the replay to keep recording.
+//
+// A missing journal results in an empty Supernet, and a torn last line (e.g. after a crash), without its new line, is ignored.
+func Replay(path string, options ...Option) (*Supernet, error) {
+	super := NewSupernet(options...)
+	journal := super.journal
+	super.journal = nil
+	defer func() { super.journal = journal }()
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return super, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// each entry is written with its new line, so a line without it is torn, even if it is a valid entry
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return super, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := &journalEntry{}
+		if err = json.Unmarshal(line, entry); err != nil {
+			return nil, err
+		}
+		if err = super.replayEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// applies a single journal entry to the supernet
+func (super *Supernet) replayEntry(entry *journalEntry) error {
+	_, ipnet, err := net.ParseCIDR(entry.Cidr)
+	if err != nil {
+		return err
+	}
+
+	switch entry.Op {
+	case journalInsert:
+		origin := ipnet
+		if entry.Origin != "" {
+			if _, origin, err = net.ParseCIDR(entry.Origin); err != nil {
+				return err
+			}
+		}
+		metadata := NewMetadata(origin)
+		for _, p := range entry.Priority {
+			metadata.Priority = append(metadata.Priority, uint8(p))
+		}
+		if entry.Attributes != nil {
+			metadata.Attributes = entry.Attributes
+		}
+		super.insert(ipnet, metadata)
+	case journalRemove:
+		super.RemoveCidr(ipnet)
+	default:
+		return errors.New("journal: unknown operation " + entry.Op)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package supernet
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "supernet.journal")
+	journal, err := OpenJournal(path)
+	assert.NoError(t, err)
+
+	super := NewSupernet(WithJournal(journal))
+	insertAll(super, []string{"192.168.1.0/24", "192.168.0.0/16", "10.0.0.0/8", "2001:db8::/32"}, []uint8{1, 0, 0, 0})
+	_, removed, _ := net.ParseCIDR("10.0.0.0/8")
+	assert.Equal(t, 1, super.RemoveCidr(removed))
+	assert.NoError(t, journal.Err())
+	assert.NoError(t, journal.Close())
+
+	replayed, err := Replay(path)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, super.AllCidrsString(false), replayed.AllCidrsString(false))
+	assert.ElementsMatch(t, super.AllCidrsString(true), replayed.AllCidrsString(true))
+
+	_, node, _ := replayed.LookupIP("192.168.200.1")
+	assert.Equal(t, "192.168.0.0/16", node.Metadata().Attributes["cidr"])
+}
+
+func TestJournalReplayIgnoresTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "supernet.journal")
+	journal, _ := OpenJournal(path)
+	insertAll(NewSupernet(WithJournal(journal)), []string{"10.0.0.0/8"}, []uint8{0})
+	journal.Close()
+
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	file.WriteString(`{"op":"insert","cidr":"11.0`)
+	file.Close()
+
+	replayed, err := Replay(path)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"10.0.0.0/8"}, replayed.AllCidrsString(false))
+}
+
+func TestJournalAppendAfterTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "supernet.journal")
+	journal, _ := OpenJournal(path)
+	insertAll(NewSupernet(WithJournal(journal)), []string{"10.0.0.0/8"}, []uint8{0})
+	journal.Close()
+
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	file.WriteString(`{"op":"insert","cidr":"11.0`)
+	file.Close()
+
+	// reopening drops the torn line, so the next entry is not appended to it
+	journal, err := OpenJournal(path)
+	assert.NoError(t, err)
+	insertAll(NewSupernet(WithJournal(journal)), []string{"12.0.0.0/8"}, []uint8{0})
+	assert.NoError(t, journal.Err())
+	journal.Close()
+
+	replayed, err := Replay(path)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"10.0.0.0/8", "12.0.0.0/8"}, replayed.AllCidrsString(false))
+}
+
+func TestJournalCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "supernet.journal")
+	journal, _ := OpenJournal(path)
+
+	super := NewSupernet(WithJournal(journal))
+	insertAll(super, []string{"192.168.1.0/24", "192.168.0.0/16"}, []uint8{1, 0})
+	assert.NoError(t, journal.Compact(super))
+
+	// the split fragments must keep the priority of the CIDR they originated from,
+	// so an equal priority /16 takes them over
+	_, ipnet, _ := net.ParseCIDR("192.168.0.0/16")
+	super.InsertCidr(ipnet, &Metadata{Priority: []uint8{0}, Attributes: makeCidrAtrr("after compaction")})
+	journal.Close()
+
+	replayed, err := Replay(path)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, super.AllCidrsString(false), replayed.AllCidrsString(false))
+
+	_, node, _ := replayed.LookupIP("192.168.200.1")
+	assert.Equal(t, "after compaction", node.Metadata().Attributes["cidr"])
+	_, node, _ = replayed.LookupIP("192.168.1.1")
+	assert.Equal(t, "192.168.1.0/24", node.Metadata().Attributes["cidr"])
+}
+
+func TestJournalCompactReopenFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "supernet.journal")
+	journal, _ := OpenJournal(path)
+	defer journal.Close()
+	super := NewSupernet(WithJournal(journal))
+	insertAll(super, []string{"192.168.1.0/24"}, []uint8{0})
+
+	reopen := reopenJournal
+	reopenJournal = func(string) (*os.File, error) { return nil, os.ErrPermission }
+	assert.ErrorIs(t, journal.Compact(super), os.ErrPermission)
+	reopenJournal = reopen
+
+	// the entries after the failure are not written, and the error is kept
+	insertAll(super, []string{"10.0.0.0/8"}, []uint8{0})
+	assert.ErrorIs(t, journal.Err(), os.ErrPermission)
+	assert.ErrorIs(t, journal.Sync(), os.ErrPermission)
+	replayed, err := Replay(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.0/24"}, replayed.AllCidrsString(false))
+
+	// another compaction reopens the journal, with the CIDRs inserted meanwhile
+	assert.NoError(t, journal.Compact(super))
+	assert.NoError(t, journal.Err())
+	insertAll(super, []string{"172.16.0.0/12"}, []uint8{0})
+	replayed, err = Replay(path)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, super.AllCidrsString(false), replayed.AllCidrsString(false))
+}
+
+func TestJournalBulkLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "supernet.journal")
+	journal, _ := OpenJournal(path)
+
+	super := NewSupernet(WithJournal(journal))
+	insertAll(super, []string{"10.0.0.0/8", "192.168.0.0/16"}, []uint8{0, 1})
+	// 10.1.0.0/16 wins over 10.0.0.0/8, 11.0.0.0/8 does not overlap, and 192.168.1.0/24 loses
+	records := []CidrRecord{}
+	for i, s := range []string{"10.1.0.0/16", "11.0.0.0/8", "192.168.1.0/24"} {
+		records = append(records, CidrRecord{CIDR: cidr(s), Metadata: &Metadata{Priority: []uint8{uint8(1 - i/2)}, Attributes: makeCidrAtrr(s)}})
+	}
+	super.BulkLoad(records, nil)
+	assert.NoError(t, journal.Close())
+
+	// the existing leafs are not journaled again, and their fragments are split again by the replay
+	journaled, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, strings.Count(string(journaled), "\n"))
+
+	replayed, err := Replay(path)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, super.AllCidrsString(false), replayed.AllCidrsString(false))
+	_, node, _ := replayed.LookupIP("10.1.0.1")
+	assert.Equal(t, "10.1.0.0/16", node.Metadata().Attributes["cidr"])
+	_, node, _ = replayed.LookupIP("192.168.1.1")
+	assert.Equal(t, "192.168.0.0/16", node.Metadata().Attributes["cidr"])
+}
+
+func TestReplayMissingJournal(t *testing.T) {
+	replayed, err := Replay(filepath.Join(t.TempDir(), "missing.journal"))
+	assert.NoError(t, err)
+	assert.Empty(t, replayed.AllCidrsString(false))
+}
+
+func insertAll(super *Supernet, cidrs []string, priorities []uint8) {
+	for i, cidr := range cidrs {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		super.InsertCidr(ipnet, &Metadata{Priority: []uint8{priorities[i]}, Attributes: makeCidrAtrr(cidr)})
+	}
+}
@@ -0,0 +1,103 @@
+package supernet
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+)
+
+// SlogLevels sets the level each kind of insertion is logged at by WithSlog.
+type SlogLevels struct {
+	NoConflict slog.Level // the CIDR was inserted without any conflict
+	Conflict   slog.Level // the conflict was resolved without splitting any CIDR
+	Split      slog.Level // the conflict was resolved by splitting the new or the existing CIDRs
+}
+
+// DefaultSlogLevels logs insertions without conflict at DEBUG, and resolved conflicts at INFO.
+func DefaultSlogLevels() SlogLevels {
+	return SlogLevels{
+		NoConflict: slog.LevelDebug,
+		Conflict:   slog.LevelInfo,
+		Split:      slog.LevelInfo,
+	}
+}
+
+// WithSlog logs each insertion result to logger with structured fields, using DefaultSlogLevels.
+func WithSlog(logger *slog.Logger) Option {
+	return WithSlogLevels(logger, DefaultSlogLevels())
+}
+
+// WithSlogLevels logs each insertion result to logger with structured fields, at the given levels.
+//
+// Each record has the fields: cidr, conflict_type, conflicted_with, actions, added and removed,
+// where added and removed are the number of CIDRs added and removed by all the actions.
+func WithSlogLevels(logger *slog.Logger, levels SlogLevels) Option {
+	return WithCustomLogger(func(ir *InsertionResult) {
+		level := levels.of(ir)
+		ctx := context.Background()
+		// skip building the attributes, if the record will be dropped anyway
+		if !logger.Enabled(ctx, level) {
+			return
+		}
+
+		conflictedWith := make([]string, 0, len(ir.ConflictedWith))
+		for _, conflicted := range ir.ConflictedWith {
+			conflictedWith = append(conflictedWith, conflicted.String())
+		}
+		actions := make([]string, 0, len(ir.Actions))
+		added, removed := 0, 0
+		for _, action := range ir.Actions {
+			actions = append(actions, action.Action.Kind().String())
+			added += len(action.AddedCidrs)
+			removed += len(action.RemoveCidrs)
+		}
+
+		logger.LogAttrs(ctx, level, "cidr inserted",
+			slog.String("cidr", ir.CIDR.String()),
+			slog.String("conflict_type", ir.ConflictType.Kind().String()),
+			slog.Any("conflicted_with", conflictedWith),
+			slog.Any("actions", actions),
+			slog.Int("added", added),
+			slog.Int("removed", removed),
+		)
+	})
+}
+
+// returns the level the insertion result should be logged at
+func (levels SlogLevels) of(ir *InsertionResult) slog.Level {
+	if _, noConflict := ir.ConflictType.(NoConflict); noConflict {
+		return levels.NoConflict
+	}
+	for _, action := range ir.Actions {
+		switch action.Action.(type) {
+		case SplitInsertedCIDR, SplitExistingCIDR:
+			return levels.Split
+		}
+	}
+	return levels.Conflict
+}
+
+// JsonLogger writes each insertion result as a JSON line, see WithJsonLogger.
+type JsonLogger struct {
+	encoder *json.Encoder
+	err     error // first write error, the results are skipped after it
+}
+
+// NewJsonLogger returns a JsonLogger writing to w.
+func NewJsonLogger(w io.Writer) *JsonLogger {
+	return &JsonLogger{encoder: json.NewEncoder(w)}
+}
+
+// Log writes the insertion result, unless a previous write failed.
+func (logger *JsonLogger) Log(ir *InsertionResult) {
+	if logger.err == nil {
+		logger.err = logger.encoder.Encode(ir)
+	}
+}
+
+// Err returns the first error that happened while writing the results, if any.
+// The loggers can not return errors, so the results after it are lost.
+func (logger *JsonLogger) Err() error {
+	return logger.err
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package supernet
+
+import (
+	"io"
+	"os"
+)
+
+// memory mapping is only supported on unix systems, so the file is read into memory
+func mapFile(file *os.File) ([]byte, func() error, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
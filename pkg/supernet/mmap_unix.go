@@ -0,0 +1,25 @@
+//go:build unix
+
+package supernet
+
+import (
+	"os"
+	"syscall"
+)
+
+// maps the whole file read-only, the mapping stays valid after the file is closed
+func mapFile(file *os.File) ([]byte, func() error, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() == 0 {
+		// an empty file can not be mapped, and is not a valid supernet file anyway
+		return []byte{}, func() error { return nil }, nil
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
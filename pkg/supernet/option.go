@@ -2,6 +2,8 @@ package supernet
 
 import (
 	"fmt"
+
+	"github.com/khalid-nowaf/supernet/pkg/trie"
 )
 
 type Option func(*Supernet) *Supernet
@@ -14,6 +16,8 @@ func DefaultOptions() *Supernet {
 		ipv6Cidrs:  &CidrTrie{},
 		comparator: DefaultComparator,
 		logger:     func(ir *InsertionResult) {},
+		counters:   &insertionCounters{},
+		nodes:      &nodeSlab{},
 	}
 }
 
@@ -36,3 +40,38 @@ func WithSimpleLogger() Option {
 		fmt.Println(ir.String())
 	})
 }
+
+// WithAttributeStore interns the attributes of the inserted CIDRs in store,
+// so the CIDRs with equal attributes share the same map. See AttributeStore.
+func WithAttributeStore(store *AttributeStore) Option {
+	return func(s *Supernet) *Supernet {
+		s.attributes = store
+		return s
+	}
+}
+
+// WithRadixTrie stores the CIDRs in path-compressed radix tries instead of binary tries.
+// A binary trie has a node for each bit of a CIDR, so a single IPv6 /64 costs 64 nodes, a radix trie only has
+// nodes where CIDRs are stored or branch. The conflicts are resolved the same way, with the same results,
+// but the insertions are slower, and the nodes returned by LookupIP and AllCIDRS are built for each call.
+func WithRadixTrie() Option {
+	return func(s *Supernet) *Supernet {
+		s.ipv4Radix = trie.NewRadixTrie[Metadata]()
+		s.ipv6Radix = trie.NewRadixTrie[Metadata]()
+		return s
+	}
+}
+
+// WithJournal records every insertion and removal in the journal, before it is applied.
+func WithJournal(journal *Journal) Option {
+	return func(s *Supernet) *Supernet {
+		s.journal = journal
+		return s
+	}
+}
+
+// WithJsonLogger writes each insertion result with logger, as a JSON line, for machine processing.
+// The results are not written after a write error, so check logger.Err after the insertions.
+func WithJsonLogger(logger *JsonLogger) Option {
+	return WithCustomLogger(logger.Log)
+}
@@ -0,0 +1,140 @@
+package supernet
+
+import (
+	"net"
+	"sync"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+)
+
+// maximum depth of the subtrees inserted concurrently, 8 bits gives up to 256 subtrees per IP family
+const maxPartitionDepth = 8
+
+// CidrRecord is a CIDR with its metadata, inserted with InsertCidrs.
+type CidrRecord struct {
+	CIDR     *net.IPNet
+	Metadata *Metadata // may be nil, like for InsertCidr
+}
+
+// InsertCidrs inserts the records like calling InsertCidr for each of them in order, using up to workers goroutines,
+// and returns their insertion results in the same order.
+//
+// The IPv4 and IPv6 CIDRs are inserted concurrently, and each family is partitioned by the leading bits of the CIDRs
+// into disjoint subtrees, which are also inserted concurrently. CIDRs in different subtrees never conflict,
+// and the records of a subtree are inserted in their order, so the resolved CIDRs and the insertion results are
+// the same as with sequential insertions. The subtrees are at most 8 bits deep, and shallower than the shortest
+// CIDR in the records or in the supernet, so a /0 or /1 CIDR makes the family inserted sequentially.
+//
+// The logger is called from several goroutines, but never concurrently, and the journal entries of different
+// subtrees may be interleaved, which replays to the same result. With radix tries, only the families are inserted
+// concurrently.
+func (super *Supernet) InsertCidrs(records []CidrRecord, workers int) []*InsertionResult {
+	results := make([]*InsertionResult, len(records))
+	families := [2][]int{} // record indexes by family
+	metadata := make([]*Metadata, len(records))
+	for i, record := range records {
+		metadata[i] = prepareMetadata(record.CIDR, record.Metadata)
+		family := 0
+		if metadata[i].IsV6 {
+			family = 1
+		}
+		families[family] = append(families[family], i)
+	}
+
+	logger := super.logger
+	loggerMu := sync.Mutex{}
+	super.logger = func(ir *InsertionResult) {
+		loggerMu.Lock()
+		defer loggerMu.Unlock()
+		logger(ir)
+	}
+	defer func() { super.logger = logger }()
+
+	// the partitions of both families share the workers
+	type partition struct {
+		root    *CidrTrie
+		records []int
+	}
+	partitions := make(chan partition)
+	wg := sync.WaitGroup{}
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// each worker allocates the nodes from its own slab
+			nodes := &nodeSlab{}
+			for p := range partitions {
+				for _, i := range p.records {
+					results[i] = super.insertUnder(p.root, records[i].CIDR, metadata[i], nodes)
+				}
+			}
+		}()
+	}
+
+	for family, indexes := range families {
+		isV6 := family == 1
+		root := super.ipv4Cidrs
+		if isV6 {
+			root = super.ipv6Cidrs
+		}
+
+		depth := super.partitionDepth(root, isV6, indexes, records)
+		if depth == 0 {
+			partitions <- partition{root: root, records: indexes}
+			continue
+		}
+
+		// the nodes down to the partition roots are shared, so they are created before inserting concurrently
+		roots := map[ipbits.Key]*CidrTrie{}
+		byRoot := map[*CidrTrie][]int{}
+		order := []*CidrTrie{}
+		for _, i := range indexes {
+			key := cidrPath(records[i].CIDR).Key.Masked(depth)
+			partitionRoot, found := roots[key]
+			if !found {
+				partitionRoot = root
+				for d := 0; d < depth; d++ {
+					partitionRoot = pathChild(partitionRoot, key.Bit(d), nil)
+				}
+				roots[key] = partitionRoot
+				order = append(order, partitionRoot)
+			}
+			byRoot[partitionRoot] = append(byRoot[partitionRoot], i)
+		}
+		for _, partitionRoot := range order {
+			partitions <- partition{root: partitionRoot, records: byRoot[partitionRoot]}
+		}
+	}
+	close(partitions)
+	wg.Wait()
+	return results
+}
+
+// returns the depth of the subtrees that can be inserted concurrently, or 0 if the family must be inserted sequentially.
+// Each CIDR must be strictly under its partition root, and the existing leafs must not be above the partition roots.
+func (super *Supernet) partitionDepth(root *CidrTrie, isV6 bool, indexes []int, records []CidrRecord) int {
+	if super.radixTrie(isV6) != nil || len(indexes) == 0 {
+		return 0
+	}
+	depth := maxPartitionDepth
+	for _, i := range indexes {
+		prefixLen, _ := records[i].CIDR.Mask.Size()
+		depth = min(depth, prefixLen-1)
+	}
+	return min(depth, shallowestLeaf(root, depth+1)-1)
+}
+
+// returns the depth of the shallowest leaf with metadata, only looking down to limit, which is returned if there is none
+func shallowestLeaf(node *CidrTrie, limit int) int {
+	if node.Depth() >= limit {
+		return limit
+	}
+	if node.Metadata() != nil {
+		return node.Depth()
+	}
+	shallowest := limit
+	node.ForEachChild(func(child *CidrTrie) {
+		shallowest = min(shallowest, shallowestLeaf(child, limit))
+	})
+	return shallowest
+}
@@ -0,0 +1,93 @@
+package supernet
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsertCidrsMatchesSequentialInsertion(t *testing.T) {
+	for name, minMaskSize := range map[string]int{"partitioned": 8, "sequential": 1} {
+		random := rand.New(rand.NewSource(7))
+		records := []CidrRecord{}
+		for i := 0; i < 5000; i++ {
+			maskSize := minMaskSize + random.Intn(25-minMaskSize)
+			var ipnet *net.IPNet
+			if i%5 == 0 {
+				ip := make(net.IP, net.IPv6len)
+				ip[0], ip[1], ip[2] = 0x20, 0x01, byte(random.Intn(4))
+				ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(16+maskSize, 128)}
+			} else {
+				ip := net.IPv4(byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256)), 0)
+				ipnet = &net.IPNet{IP: ip.Mask(net.CIDRMask(maskSize, 32)), Mask: net.CIDRMask(maskSize, 32)}
+			}
+			records = append(records, CidrRecord{CIDR: ipnet, Metadata: &Metadata{Priority: []uint8{uint8(random.Intn(3))}, Attributes: makeCidrAtrr(ipnet.String())}})
+		}
+
+		// the records are consumed by the insertion, so each supernet gets its own copy
+		sequential := NewSupernet()
+		for _, record := range copyRecords(records[:100]) {
+			sequential.InsertCidr(record.CIDR, record.Metadata)
+		}
+		expected := []*InsertionResult{}
+		for _, record := range copyRecords(records[100:]) {
+			expected = append(expected, sequential.InsertCidr(record.CIDR, record.Metadata))
+		}
+
+		parallel := NewSupernet()
+		parallel.InsertCidrs(copyRecords(records[:100]), 4)
+		actual := parallel.InsertCidrs(copyRecords(records[100:]), 4)
+
+		assert.Equal(t, sequential.AllCidrsString(false), parallel.AllCidrsString(false), name)
+		assert.Equal(t, sequential.AllCidrsString(true), parallel.AllCidrsString(true), name)
+		for i := range expected {
+			assert.Equal(t, expected[i].CIDR, actual[i].CIDR, name)
+			assert.Equal(t, expected[i].ConflictType, actual[i].ConflictType, name)
+			assert.Equal(t, expected[i].ConflictedWith, actual[i].ConflictedWith, name)
+			assert.Equal(t, actionSummary(expected[i]), actionSummary(actual[i]), name)
+		}
+		assert.Equal(t, sequential.Stats().Insertions, parallel.Stats().Insertions, name)
+	}
+}
+
+func TestPartitionDepth(t *testing.T) {
+	super := NewSupernet()
+	records := []CidrRecord{{CIDR: cidr("10.0.0.0/16")}, {CIDR: cidr("11.0.0.0/24")}}
+	assert.Equal(t, 8, super.partitionDepth(super.ipv4Cidrs, false, []int{0, 1}, records))
+
+	records = append(records, CidrRecord{CIDR: cidr("12.0.0.0/6")})
+	assert.Equal(t, 5, super.partitionDepth(super.ipv4Cidrs, false, []int{0, 1, 2}, records))
+
+	// the existing leafs must be under the partition roots
+	insertAll(super, []string{"192.0.0.0/4"}, []uint8{0})
+	assert.Equal(t, 3, super.partitionDepth(super.ipv4Cidrs, false, []int{0, 1}, records))
+}
+
+func copyRecords(records []CidrRecord) []CidrRecord {
+	copied := make([]CidrRecord, len(records))
+	for i, record := range records {
+		metadata := *record.Metadata
+		metadata.Priority = append([]uint8{}, record.Metadata.Priority...)
+		copied[i] = CidrRecord{CIDR: record.CIDR, Metadata: &metadata}
+	}
+	return copied
+}
+
+// on a single CPU, the partitions are not inserted faster, but the paths to the partitions are only walked once:
+// BenchmarkInsertCidrs    	  792436	      1279 ns/op	     837 B/op	      16 allocs/op
+// BenchmarkInsertCidr     	  853186	      1840 ns/op	     746 B/op	      16 allocs/op
+// the allocations after allocating the nodes by chunks, see BenchmarkInsertCidr:
+// BenchmarkInsertCidrs 	 1000000	      3457 ns/op	     742 B/op	       4 allocs/op
+func BenchmarkInsertCidrs(b *testing.B) {
+	records := make([]CidrRecord, b.N)
+	for i, cidr := range randomCidrs(b.N, 24) {
+		records[i] = CidrRecord{CIDR: cidr}
+	}
+	root := NewSupernet()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	root.InsertCidrs(records, 4)
+}
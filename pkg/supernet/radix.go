@@ -0,0 +1,108 @@
+package supernet
+
+import (
+	"net/netip"
+	"unsafe"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+	"github.com/khalid-nowaf/supernet/pkg/trie"
+)
+
+// RadixCidrTrie is the path-compressed alternative to CidrTrie, used with the WithRadixTrie option.
+type RadixCidrTrie = trie.RadixTrie[Metadata]
+
+// returns the radix trie of the IP family, or nil if the supernet uses binary tries
+func (super *Supernet) radixTrie(isV6 bool) *RadixCidrTrie {
+	if isV6 {
+		return super.ipv6Radix
+	}
+	return super.ipv4Radix
+}
+
+// inserts the leaf in the radix trie, with the same conflict resolution as the binary trie.
+//
+// The CIDRs conflicting with the new one are the CIDR containing it, or the CIDRs within it, so they are copied
+// to a scratch binary trie, where insertLeaf resolves the conflicts with the actions, like in a binary trie.
+// Then the region of the conflicting CIDRs is replaced by the resolved CIDRs of the scratch trie.
+func (super *Supernet) insertRadix(radix *RadixCidrTrie, path ipbits.Path, leaf *CidrTrie) *InsertionResult {
+	isV6 := leaf.Metadata().IsV6
+	region := path
+	root := &CidrTrie{}
+	if covering, metadata, found := radix.Covering(path); found {
+		region = covering
+		materialize(root, covering, metadata)
+	} else {
+		radix.Within(path, func(within ipbits.Path, metadata *Metadata) bool {
+			materialize(root, within, metadata)
+			return true
+		})
+	}
+	if root.IsLeaf() {
+		// nothing to resolve
+		radix.Insert(path, leaf.Metadata())
+		cidr := ipnetToPrefix(leaf.Metadata().originCIDR)
+		return noConflictResult(cidr, cidr)
+	}
+
+	result := super.insertLeaf(root, path, leaf, nil)
+	radix.DeleteWithin(region)
+	for it := newCidrIterator(root, isV6); it.Next(); {
+		radix.Insert(ipbits.PathFromPrefix(it.Prefix()), it.Metadata())
+	}
+	return result
+}
+
+// returns the result of inserting cidr where it does not overlap any CIDR, added is the CIDR of its leaf.
+// The result, its action and their slices are allocated at once.
+func noConflictResult(cidr netip.Prefix, added netip.Prefix) *InsertionResult {
+	results := &struct {
+		result  InsertionResult
+		action  ActionResult
+		actions [1]*ActionResult
+		added   [1]netip.Prefix
+	}{}
+	results.added[0] = added
+	results.action = ActionResult{Action: InsertNewCIDR{}, AddedCidrs: results.added[:]}
+	results.actions[0] = &results.action
+	results.result = InsertionResult{CIDR: cidr, ConflictType: NoConflict{}, Actions: results.actions[:]}
+	return &results.result
+}
+
+// removes the CIDRs within path that originated from origin, and returns how many were removed
+func removeRadix(radix *RadixCidrTrie, path ipbits.Path, origin string) int {
+	removedPaths := []ipbits.Path{}
+	radix.Within(path, func(within ipbits.Path, metadata *Metadata) bool {
+		if metadata.originCIDR.String() == origin {
+			removedPaths = append(removedPaths, within)
+		}
+		return true
+	})
+	for _, removedPath := range removedPaths {
+		radix.Delete(removedPath)
+	}
+	return len(removedPaths)
+}
+
+func radixStats(radix *RadixCidrTrie) TrieStats {
+	stats := TrieStats{
+		LeafsByPrefixLen: map[int]int{},
+		NodesByDepth:     map[int]int{},
+		EstimatedMemory:  unsafe.Sizeof(*radix) + radix.NodeSize(),
+	}
+	// split CIDRs share their attributes, so each map is only counted once
+	countedAttributes := map[unsafe.Pointer]bool{}
+
+	radix.ForEachNode(func(path ipbits.Path, metadata *Metadata) {
+		stats.Nodes++
+		stats.NodesByDepth[path.Len]++
+		stats.EstimatedMemory += radix.NodeSize()
+		if metadata == nil {
+			stats.PathNodes++
+			return
+		}
+		stats.LeafNodes++
+		stats.LeafsByPrefixLen[path.Len]++
+		stats.EstimatedMemory += metadataSize(metadata, countedAttributes)
+	})
+	return stats
+}
@@ -0,0 +1,205 @@
+package supernet
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"net/netip"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// random CIDRs within 10.0.0.0/14 and 2001:db8::/44, so they conflict a lot
+func radixTestRecords(seed int64, n int) []CidrRecord {
+	random := rand.New(rand.NewSource(seed))
+	records := []CidrRecord{}
+	for i := 0; i < n; i++ {
+		var ipnet *net.IPNet
+		if i%3 == 0 {
+			maskSize := 32 + random.Intn(33)
+			ip := make(net.IP, net.IPv6len)
+			copy(ip, net.ParseIP("2001:db8::"))
+			ip[5], ip[6], ip[7] = byte(random.Intn(16)), byte(random.Intn(256)), byte(random.Intn(256))
+			ipnet = &net.IPNet{IP: ip.Mask(net.CIDRMask(maskSize, 128)), Mask: net.CIDRMask(maskSize, 128)}
+		} else {
+			maskSize := 8 + random.Intn(17)
+			ip := net.IPv4(10, byte(random.Intn(4)), byte(random.Intn(256)), 0)
+			ipnet = &net.IPNet{IP: ip.Mask(net.CIDRMask(maskSize, 32)), Mask: net.CIDRMask(maskSize, 32)}
+		}
+		records = append(records, CidrRecord{CIDR: ipnet, Metadata: &Metadata{Priority: []uint8{uint8(random.Intn(3))}, Attributes: makeCidrAtrr(ipnet.String())}})
+	}
+	return records
+}
+
+func TestRadixMatchesBinaryTrie(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+	binary := NewSupernet()
+	radix := NewSupernet(WithRadixTrie())
+
+	for _, record := range radixTestRecords(3, 3000) {
+		expected := binary.InsertCidr(record.CIDR, copyRecords([]CidrRecord{record})[0].Metadata)
+		actual := radix.InsertCidr(record.CIDR, copyRecords([]CidrRecord{record})[0].Metadata)
+		assertSameResult(t, expected, actual)
+
+		if random.Intn(50) == 0 {
+			assert.Equal(t, binary.RemoveCidr(record.CIDR), radix.RemoveCidr(record.CIDR), record.CIDR.String())
+		}
+	}
+	assertSameCidrs(t, binary, radix)
+
+	for _, prefix := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.3/32", "2001:db8::/32", "2001:db8:1::/48"} {
+		expected, actual := []netip.Prefix{}, []netip.Prefix{}
+		binary.LookupPrefix(netip.MustParsePrefix(prefix), func(cidr netip.Prefix, _ *Metadata) bool {
+			expected = append(expected, cidr)
+			return true
+		})
+		radix.LookupPrefix(netip.MustParsePrefix(prefix), func(cidr netip.Prefix, _ *Metadata) bool {
+			actual = append(actual, cidr)
+			return true
+		})
+		assert.Equal(t, expected, actual, prefix)
+	}
+
+	binaryStats, radixStats := binary.Stats(), radix.Stats()
+	assert.Equal(t, binaryStats.Conflicts, radixStats.Conflicts)
+	assert.Equal(t, binaryStats.Actions, radixStats.Actions)
+	for _, family := range []struct{ binary, radix TrieStats }{{binaryStats.IPv4, radixStats.IPv4}, {binaryStats.IPv6, radixStats.IPv6}} {
+		assert.Equal(t, family.binary.LeafNodes, family.radix.LeafNodes)
+		assert.Equal(t, family.binary.LeafsByPrefixLen, family.radix.LeafsByPrefixLen)
+		assert.Less(t, family.radix.Nodes, family.binary.Nodes)
+	}
+
+	for _, forV6 := range []bool{false, true} {
+		expected := []string{}
+		for _, leaf := range binary.AllCIDRS(forV6) {
+			expected = append(expected, NodeToCidr(leaf))
+		}
+		actual := []string{}
+		for _, leaf := range radix.AllCIDRS(forV6) {
+			actual = append(actual, NodeToCidr(leaf))
+		}
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestRadixInsertCidrsAndBulkLoad(t *testing.T) {
+	records := radixTestRecords(5, 2000)
+	binary := NewSupernet()
+	expected := []*InsertionResult{}
+	for _, record := range copyRecords(records) {
+		expected = append(expected, binary.InsertCidr(record.CIDR, record.Metadata))
+	}
+
+	parallel := NewSupernet(WithRadixTrie())
+	for i, result := range parallel.InsertCidrs(copyRecords(records), 4) {
+		assertSameResult(t, expected[i], result)
+	}
+	assertSameCidrs(t, binary, parallel)
+
+	bulk := NewSupernet(WithRadixTrie())
+	for _, record := range copyRecords(records[:200]) {
+		bulk.InsertCidr(record.CIDR, record.Metadata)
+	}
+	bulk.BulkLoad(copyRecords(records[200:]), func(i int, result *InsertionResult) {
+		assertSameResult(t, expected[200+i], result)
+	})
+	assertSameCidrs(t, binary, bulk)
+}
+
+func TestRadixSaveAndLoad(t *testing.T) {
+	binary := NewSupernet()
+	for _, record := range radixTestRecords(6, 1000) {
+		binary.InsertCidr(record.CIDR, record.Metadata)
+	}
+	file := &bytes.Buffer{}
+	assert.NoError(t, binary.Save(file))
+
+	radix, err := Load(file, WithRadixTrie())
+	assert.NoError(t, err)
+	assert.NotNil(t, radix.radixTrie(false))
+	assertSameCidrs(t, binary, radix)
+}
+
+func TestRadixLookupIP(t *testing.T) {
+	super := NewSupernet(WithRadixTrie())
+	insertAll(super, []string{"192.168.1.0/24", "192.168.0.0/16", "2001:db8::/32", "2001:db8:1::/48"}, []uint8{1, 0, 0, 1})
+
+	ipnet, node, err := super.LookupIP("192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.0/24", ipnet.String())
+	assert.Equal(t, "192.168.1.0/24", NodeToCidr(node))
+	assert.Equal(t, "192.168.1.0/24", node.Metadata().Attributes["cidr"])
+
+	ipnet, node, err = super.LookupIP("2001:db8:1::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "2001:db8:1::/48", ipnet.String())
+	assert.Equal(t, "2001:db8:1::/48", NodeToCidr(node))
+
+	ipnet, node, err = super.LookupIP("10.0.0.1")
+	assert.NoError(t, err)
+	assert.Nil(t, ipnet)
+	assert.Nil(t, node)
+
+	assert.Equal(t, 8, super.RemoveCidr(cidr("192.168.0.0/16")))
+	assert.Equal(t, []string{"192.168.1.0/24"}, super.AllCidrsString(false))
+}
+
+// checks that both supernets have the same resolved CIDRs and metadata
+func assertSameCidrs(t *testing.T, expected *Supernet, actual *Supernet) {
+	t.Helper()
+	for _, forV6 := range []bool{false, true} {
+		assert.Equal(t, expected.AllCidrsString(forV6), actual.AllCidrsString(forV6))
+		expected.ForEachCidr(forV6, func(cidr netip.Prefix, metadata *Metadata) bool {
+			found, actualMetadata, ok := actual.LookupAddr(cidr.Addr())
+			assert.True(t, ok, cidr.String())
+			assert.Equal(t, cidr, found)
+			if ok {
+				assert.Equal(t, metadata.Attributes, actualMetadata.Attributes, cidr.String())
+				assert.Equal(t, metadata.Priority, actualMetadata.Priority, cidr.String())
+				assert.Equal(t, metadata.originCIDR.String(), actualMetadata.originCIDR.String(), cidr.String())
+			}
+			return true
+		})
+	}
+}
+
+// the heap held by 100K random IPv6 /64 CIDRs, with their metadata:
+// BenchmarkIPv6Memory/binary 	       1	       6442 heap-bytes/cidr
+// BenchmarkIPv6Memory/radix  	       1	        356 heap-bytes/cidr
+func BenchmarkIPv6Memory(b *testing.B) {
+	random := rand.New(rand.NewSource(7))
+	cidrs := make([]*net.IPNet, 100_000)
+	for i := range cidrs {
+		ip := make(net.IP, net.IPv6len)
+		random.Read(ip[:8])
+		cidrs[i] = &net.IPNet{IP: ip, Mask: net.CIDRMask(64, 128)}
+	}
+
+	for _, name := range []string{"binary", "radix"} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				options := []Option{}
+				if name == "radix" {
+					options = append(options, WithRadixTrie())
+				}
+				before := heapAlloc()
+				super := NewSupernet(options...)
+				for _, cidr := range cidrs {
+					super.InsertCidr(cidr, nil)
+				}
+				b.ReportMetric(float64(heapAlloc()-before)/float64(len(cidrs)), "heap-bytes/cidr")
+				runtime.KeepAlive(super)
+			}
+		})
+	}
+}
+
+// returns the bytes of the live heap objects, after a garbage collection
+func heapAlloc() uint64 {
+	runtime.GC()
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
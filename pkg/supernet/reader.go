@@ -0,0 +1,143 @@
+package supernet
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"sort"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+)
+
+// Reader answers lookups directly from a file written by Save, without loading its CIDRs into a trie.
+//
+// On unix systems the file is memory mapped: opening it checks the whole file once, like Load, and indexes the dictionaries,
+// then the leaf records are binary searched in place, and the pages are shared by all the processes mapping the same file.
+// Each lookup decodes the metadata of the found records into copies, which stay valid after Close.
+// On other systems the file is read into memory.
+//
+// A Reader is safe for concurrent lookups, and must not be used after Close.
+type Reader struct {
+	data  []byte
+	unmap func() error
+	file  *fileSections
+}
+
+// OpenReader opens a file written by Save for lookups, after checking its checksums and its leaf records,
+// so a corrupted file is rejected before it is used.
+func OpenReader(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, unmap, err := mapFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := decodeFile(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	return &Reader{data: data, unmap: unmap, file: sections}, nil
+}
+
+// Verify checks the checksums and the records of the whole file again, a mapped file modified in place
+// after it was opened is seen by the lookups.
+func (r *Reader) Verify() error {
+	_, err := decodeFile(r.data)
+	return err
+}
+
+// LookupAddr searches for the resolved CIDR containing addr, and returns it with its metadata.
+// The returned Metadata is decoded from the file for this lookup, and it stays valid after Close.
+//
+// Records that are out of range in a file modified after it was opened are reported as not found, use Verify to detect them.
+func (r *Reader) LookupAddr(addr netip.Addr) (netip.Prefix, *Metadata, bool) {
+	addr = addr.Unmap()
+	isV6 := addr.Is6()
+	key := ipbits.FromAddr(addr)
+
+	// the leafs are disjoint and sorted, so the only candidate is the last leaf starting at, or before addr
+	low, high := 0, r.file.leafCount(isV6)
+	for low < high {
+		middle := int(uint(low+high) >> 1)
+		if r.leafKey(isV6, middle).Compare(key) <= 0 {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	if low == 0 {
+		return netip.Prefix{}, nil, false
+	}
+
+	leaf := r.file.leaf(isV6, low-1)
+	if !r.file.validLeaf(isV6, leaf) || key.Masked(leaf.path.Len) != leaf.path.Key {
+		return netip.Prefix{}, nil, false
+	}
+	return leaf.path.Prefix(isV6), r.metadata(isV6, leaf), true
+}
+
+// LookupPrefix calls f with the resolved CIDR containing prefix, if there is one,
+// otherwise with each resolved CIDR within prefix, in address order, until f returns false.
+func (r *Reader) LookupPrefix(prefix netip.Prefix, f func(cidr netip.Prefix, metadata *Metadata) bool) {
+	prefix = unmapPrefix(prefix)
+	isV6 := prefix.Addr().Is6()
+	path := ipbits.PathFromPrefix(prefix)
+
+	// the first leaf starting at, or after the prefix, the leaf before it is the only one that can contain the prefix
+	first := sort.Search(r.file.leafCount(isV6), func(i int) bool {
+		return r.leafKey(isV6, i).Compare(path.Key) >= 0
+	})
+	for _, i := range []int{first - 1, first} {
+		if i < 0 || i >= r.file.leafCount(isV6) {
+			continue
+		}
+		if leaf := r.file.leaf(isV6, i); r.file.validLeaf(isV6, leaf) && leaf.path.Contains(path) {
+			f(leaf.path.Prefix(isV6), r.metadata(isV6, leaf))
+			return
+		}
+	}
+
+	for i := first; i < r.file.leafCount(isV6); i++ {
+		leaf := r.file.leaf(isV6, i)
+		if !path.Contains(leaf.path) {
+			return
+		}
+		if r.file.validLeaf(isV6, leaf) && !f(leaf.path.Prefix(isV6), r.metadata(isV6, leaf)) {
+			return
+		}
+	}
+}
+
+// decodes the metadata of a valid leaf record, it does not point into the file
+func (r *Reader) metadata(isV6 bool, leaf fileLeaf) *Metadata {
+	return &Metadata{
+		IsV6:       isV6,
+		Priority:   r.file.priority(leaf.priority),
+		Attributes: r.file.attributeMap(leaf.attributes),
+	}
+}
+
+// returns the key of the leaf record i, without decoding the rest of the record
+func (r *Reader) leafKey(isV6 bool, i int) ipbits.Key {
+	records := r.file.leafs[0]
+	if isV6 {
+		records = r.file.leafs[1]
+	}
+	record := records[i*leafRecordSize:]
+	return ipbits.Key{Hi: binary.LittleEndian.Uint64(record[0:]), Lo: binary.LittleEndian.Uint64(record[8:])}
+}
+
+// returns the number of resolved CIDRs in the IPv4 or IPv6 leafs
+func (r *Reader) Len(forV6 bool) int {
+	return r.file.leafCount(forV6)
+}
+
+// Close unmaps the file.
+func (r *Reader) Close() error {
+	return r.unmap()
+}
@@ -0,0 +1,176 @@
+package supernet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaderLookupAddr(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"192.168.1.0/24", "192.168.0.0/16", "10.0.0.0/8", "2001:db8::/32", "2001:db8:1::/48"}, []uint8{1, 0, 0, 0, 1})
+	reader := openTestReader(t, super)
+	assert.NoError(t, reader.Verify())
+	assert.Equal(t, 10, reader.Len(false))
+
+	for _, addr := range []string{"192.168.1.1", "192.168.0.0", "192.168.255.255", "10.1.1.1", "::ffff:10.1.1.1", "2001:db8:1::1", "2001:db8:ffff::1"} {
+		expectedPrefix, expectedMetadata, _ := super.LookupAddr(netip.MustParseAddr(addr))
+		prefix, metadata, found := reader.LookupAddr(netip.MustParseAddr(addr))
+		assert.True(t, found, addr)
+		assert.Equal(t, expectedPrefix, prefix, addr)
+		assert.Equal(t, expectedMetadata.Attributes, metadata.Attributes, addr)
+		assert.Equal(t, expectedMetadata.Priority, metadata.Priority, addr)
+	}
+
+	for _, addr := range []string{"0.0.0.0", "9.255.255.255", "11.0.0.0", "255.255.255.255", "::", "2001:db9::"} {
+		_, _, found := reader.LookupAddr(netip.MustParseAddr(addr))
+		assert.False(t, found, addr)
+	}
+}
+
+func TestReaderMatchesSupernet(t *testing.T) {
+	super, addrs := benchmarkSupernet(false)
+	reader := openTestReader(t, super)
+
+	random := rand.New(rand.NewSource(6))
+	for i := 0; i < 10000; i++ {
+		addr := addrs[i%len(addrs)]
+		if i%2 == 0 {
+			addr = netip.AddrFrom4([4]byte{byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256)), byte(random.Intn(256))})
+		}
+		expectedPrefix, _, expectedFound := super.LookupAddr(addr)
+		prefix, _, found := reader.LookupAddr(addr)
+		assert.Equal(t, expectedFound, found, addr.String())
+		assert.Equal(t, expectedPrefix, prefix, addr.String())
+	}
+}
+
+func TestLookupPrefix(t *testing.T) {
+	super := NewSupernet()
+	cidrs, priorities := []string{"192.168.1.0/24", "192.168.0.0/16", "10.0.0.0/8", "2001:db8::/32", "2001:db8:1::/48"}, []uint8{1, 0, 0, 0, 1}
+	insertAll(super, cidrs, priorities)
+	reader := openTestReader(t, super)
+
+	for prefix, expected := range map[string][]string{
+		"192.168.1.0/24":      {"192.168.1.0/24"},
+		"192.168.1.128/25":    {"192.168.1.0/24"},
+		"::ffff:10.1.0.0/112": {"10.0.0.0/8"},
+		"192.168.0.0/23":      {"192.168.0.0/24", "192.168.1.0/24"},
+		"192.168.0.0/16":      {"192.168.0.0/24", "192.168.1.0/24", "192.168.2.0/23", "192.168.4.0/22", "192.168.8.0/21", "192.168.16.0/20", "192.168.32.0/19", "192.168.64.0/18", "192.168.128.0/17"},
+		"192.0.0.0/8":         {"192.168.0.0/24", "192.168.1.0/24", "192.168.2.0/23", "192.168.4.0/22", "192.168.8.0/21", "192.168.16.0/20", "192.168.32.0/19", "192.168.64.0/18", "192.168.128.0/17"},
+		"2001:db8::/31":       {"2001:db8::/48", "2001:db8:1::/48", "2001:db8:2::/47", "2001:db8:4::/46", "2001:db8:8::/45", "2001:db8:10::/44", "2001:db8:20::/43", "2001:db8:40::/42", "2001:db8:80::/41", "2001:db8:100::/40", "2001:db8:200::/39", "2001:db8:400::/38", "2001:db8:800::/37", "2001:db8:1000::/36", "2001:db8:2000::/35", "2001:db8:4000::/34", "2001:db8:8000::/33"},
+		"2001:db8:1:1::/64":   {"2001:db8:1::/48"},
+		"11.0.0.0/8":          {},
+		"0.0.0.0/0":           {"10.0.0.0/8", "192.168.0.0/24", "192.168.1.0/24", "192.168.2.0/23", "192.168.4.0/22", "192.168.8.0/21", "192.168.16.0/20", "192.168.32.0/19", "192.168.64.0/18", "192.168.128.0/17"},
+	} {
+		for name, lookuper := range map[string]PrefixLookuper{"supernet": super, "reader": reader} {
+			found := []string{}
+			lookuper.LookupPrefix(netip.MustParsePrefix(prefix), func(cidr netip.Prefix, metadata *Metadata) bool {
+				_, expectedMetadata, _ := super.LookupAddr(cidr.Addr())
+				assert.Equal(t, expectedMetadata.Attributes, metadata.Attributes, name)
+				found = append(found, cidr.String())
+				return true
+			})
+			assert.Equal(t, expected, found, name+" "+prefix)
+		}
+	}
+
+	// the lookup stops when f returns false
+	for name, lookuper := range map[string]PrefixLookuper{"supernet": super, "reader": reader} {
+		found := 0
+		lookuper.LookupPrefix(netip.MustParsePrefix("192.168.0.0/16"), func(netip.Prefix, *Metadata) bool {
+			found++
+			return found < 2
+		})
+		assert.Equal(t, 2, found, name)
+	}
+}
+
+func TestReaderVerify(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"192.168.1.0/24", "10.0.0.0/8"}, []uint8{0, 0})
+	path := filepath.Join(t.TempDir(), "supernet.db")
+	file, _ := os.Create(path)
+	assert.NoError(t, super.Save(file))
+	file.Close()
+
+	reader, err := OpenReader(path)
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Verify())
+	reader.Close()
+
+	// corrupt the priority index of the 192.168.1.0/24 record, the leafs are checked when opening the file
+	data, _ := os.ReadFile(path)
+	record := bytes.Index(data, binary.LittleEndian.AppendUint64(nil, ipbits.FromAddr(netip.MustParseAddr("192.168.1.0")).Hi))
+	data[record+leafRecordSize-1] = 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+	_, err = OpenReader(path)
+	assert.ErrorIs(t, err, ErrInvalidFile)
+
+	_, err = OpenReader(filepath.Join(t.TempDir(), "missing.db"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestReaderMetadataOutlivesClose(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"10.0.0.0/8"}, []uint8{3})
+	path := filepath.Join(t.TempDir(), "supernet.db")
+	saveTestFile(t, super, path)
+
+	_, expected, _ := super.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+	reader, err := OpenReader(path)
+	assert.NoError(t, err)
+	_, metadata, found := reader.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+	assert.True(t, found)
+	found = false
+	reader.LookupPrefix(netip.MustParsePrefix("10.0.0.0/8"), func(_ netip.Prefix, prefixMetadata *Metadata) bool {
+		found = prefixMetadata != metadata
+		return true
+	})
+	assert.True(t, found, "each lookup decodes its own metadata")
+	assert.NoError(t, reader.Close())
+	assert.Equal(t, expected.Attributes, metadata.Attributes)
+	assert.Equal(t, expected.Priority, metadata.Priority)
+
+	// a reload releases the previous Reader, the metadata found before stays readable
+	handle := &Handle{}
+	assert.NoError(t, handle.Reload(ReaderLoader(path)))
+	defer handle.Close()
+	_, metadata, _ = handle.LookupAddr(netip.MustParseAddr("10.1.1.1"))
+	assert.NoError(t, handle.Reload(ReaderLoader(path)))
+	assert.Equal(t, "10.0.0.0/8", metadata.Attributes["cidr"])
+}
+
+// a found record decodes its metadata into a new map with copied strings, unlike BenchmarkLookupAddrHit:
+// BenchmarkReaderLookupAddr 	 5587646	       198.4 ns/op	     120 B/op	       3 allocs/op
+func BenchmarkReaderLookupAddr(b *testing.B) {
+	super, addrs := benchmarkSupernet(true)
+	reader := openTestReader(b, super)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		reader.LookupAddr(addrs[i%len(addrs)])
+	}
+}
+
+// saves super in a temporary file, and opens it with a Reader
+func openTestReader(t testing.TB, super *Supernet) *Reader {
+	path := filepath.Join(t.TempDir(), "supernet.db")
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, super.Save(file))
+	assert.NoError(t, file.Close())
+
+	reader, err := OpenReader(path)
+	assert.NoError(t, err)
+	t.Cleanup(func() { reader.Close() })
+	return reader
+}
@@ -1,16 +1,18 @@
 package supernet
 
 import (
+	"encoding/json"
 	"fmt"
-	"net"
+	"net/netip"
 )
 
 // records the outcome of attempting to insert a CIDR for reporting
 type InsertionResult struct {
-	CIDR           *net.IPNet      // CIDR was attempted to be inserted.
-	actions        []*ActionResult // the result of each action is taken
-	ConflictedWith []CidrTrie      // array of conflicting nodes
-	ConflictType                   // the type of the conflict
+	CIDR               netip.Prefix    // CIDR was attempted to be inserted.
+	Actions            []*ActionResult // the result of each action is taken
+	ConflictedWith     []netip.Prefix  // the existing CIDRs that conflicted with the inserted CIDR
+	ConflictedMetadata []*Metadata     // the metadata of the CIDRs in ConflictedWith, in the same order
+	ConflictType                       // the type of the conflict
 }
 
 func (ir *InsertionResult) String() string {
@@ -20,40 +22,72 @@ func (ir *InsertionResult) String() string {
 		str += fmt.Sprintf("Detect %s conflict |", ir.ConflictType)
 		str += fmt.Sprintf("New CIDR %s conflicted with [", ir.CIDR)
 		for _, conflictedCidr := range ir.ConflictedWith {
-			str += fmt.Sprintf("%s ", NodeToCidr(&conflictedCidr))
+			str += fmt.Sprintf("%s ", conflictedCidr)
 		}
 		str += "] | "
 	}
 
-	for _, action := range ir.actions {
+	for _, action := range ir.Actions {
 		str += fmt.Sprintf("%s", action.String())
 	}
 
 	return str
 }
 
+// MarshalJSON encodes the result with the conflict type as its ConflictKind name, e.g.
+//
+//	{"cidr":"10.0.0.0/8","conflict_type":"sub_cidr","conflicted_with":["10.0.0.0/7"],"actions":[...]}
+func (ir *InsertionResult) MarshalJSON() ([]byte, error) {
+	conflictedWith := ir.ConflictedWith
+	if conflictedWith == nil {
+		conflictedWith = []netip.Prefix{}
+	}
+	return json.Marshal(struct {
+		CIDR           netip.Prefix    `json:"cidr"`
+		ConflictType   ConflictKind    `json:"conflict_type"`
+		ConflictedWith []netip.Prefix  `json:"conflicted_with"`
+		Actions        []*ActionResult `json:"actions"`
+	}{
+		CIDR:           ir.CIDR,
+		ConflictType:   ir.ConflictType.Kind(),
+		ConflictedWith: conflictedWith,
+		Actions:        ir.Actions,
+	})
+}
+
 type ActionResult struct {
 	Action      Action
-	AddedCidrs  []CidrTrie
-	RemoveCidrs []CidrTrie
+	AddedCidrs  []netip.Prefix
+	RemoveCidrs []netip.Prefix
 }
 
 func (ar ActionResult) String() string {
-	addedCidrs := []string{}
-	removedCidrs := []string{}
+	return fmt.Sprintf("Action Taken: %s, Added CIDRs: %v, Removed CIDRs: %v", ar.Action, ar.AddedCidrs, ar.RemoveCidrs)
+}
 
-	for _, added := range ar.AddedCidrs {
-		addedCidrs = append(addedCidrs, NodeToCidr(&added))
+// MarshalJSON encodes the action result with the action as its ActionKind name, e.g.
+//
+//	{"action":"split_existing_cidr","added":["10.0.1.0/24"],"removed":[]}
+func (ar ActionResult) MarshalJSON() ([]byte, error) {
+	added, removed := ar.AddedCidrs, ar.RemoveCidrs
+	if added == nil {
+		added = []netip.Prefix{}
 	}
-
-	for _, removed := range ar.RemoveCidrs {
-		removedCidrs = append(removedCidrs, NodeToCidr(&removed))
+	if removed == nil {
+		removed = []netip.Prefix{}
 	}
-
-	return fmt.Sprintf("Action Taken: %s, Added CIDRs: %v, Removed CIDRs: %v", ar.Action, addedCidrs, removedCidrs)
+	return json.Marshal(struct {
+		Action  ActionKind     `json:"action"`
+		Added   []netip.Prefix `json:"added"`
+		Removed []netip.Prefix `json:"removed"`
+	}{
+		Action:  ar.Action.Kind(),
+		Added:   added,
+		Removed: removed,
+	})
 }
 
 // to keep track of all the added CIDRs from resolving a conflict.
 func (ar *ActionResult) appendAddedCidr(cidr *CidrTrie) {
-	ar.AddedCidrs = append(ar.AddedCidrs, *cidr)
+	ar.AddedCidrs = append(ar.AddedCidrs, NodeToPrefix(cidr))
 }
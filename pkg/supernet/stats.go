@@ -0,0 +1,257 @@
+package supernet
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Stats is a snapshot of the shape of a Supernet, and of what happened during the insertions so far.
+type Stats struct {
+	IPv4       TrieStats
+	IPv6       TrieStats
+	Insertions uint64                  // total number of insertions, including the records bulk loaded without results
+	Conflicts  map[ConflictKind]uint64 // number of insertions by conflict type
+	Actions    map[ActionKind]uint64   // number of actions taken by kind
+}
+
+// TrieStats describes the nodes of an IPv4 or IPv6 trie.
+type TrieStats struct {
+	Nodes            int         // all the nodes, excluding the root
+	LeafNodes        int         // nodes holding a resolved CIDR
+	PathNodes        int         // nodes without metadata, used to reach the leafs
+	LeafsByPrefixLen map[int]int // number of resolved CIDRs by their prefix length
+	NodesByDepth     map[int]int // number of nodes (leaf and path) at each depth
+	EstimatedMemory  uintptr     // rough estimation of the memory held by the nodes and their metadata, in bytes
+}
+
+// cumulative counters, updated after each insertion
+type insertionCounters struct {
+	insertions atomic.Uint64
+	conflicts  [SubCIDRKind + 1]atomic.Uint64
+	actions    [SplitExistingCIDRKind + 1]atomic.Uint64
+}
+
+func (c *insertionCounters) record(ir *InsertionResult) {
+	c.insertions.Add(1)
+	c.conflicts[ir.ConflictType.Kind()].Add(1)
+	for _, action := range ir.Actions {
+		c.actions[action.Action.Kind()].Add(1)
+	}
+}
+
+// Stats walks both tries to count their nodes, and collects the insertion counters.
+// It must not be called while CIDRs are being inserted.
+func (super *Supernet) Stats() Stats {
+	stats := Stats{
+		IPv4: super.familyStats(false),
+		IPv6: super.familyStats(true),
+	}
+	super.counters.load(&stats)
+	return stats
+}
+
+// sets the insertion counters of stats, unlike the tries, the counters can be read during the insertions
+func (c *insertionCounters) load(stats *Stats) {
+	stats.Insertions = c.insertions.Load()
+	stats.Conflicts = map[ConflictKind]uint64{}
+	stats.Actions = map[ActionKind]uint64{}
+	for kind := range c.conflicts {
+		stats.Conflicts[ConflictKind(kind)] = c.conflicts[kind].Load()
+	}
+	for kind := range c.actions {
+		stats.Actions[ActionKind(kind)] = c.actions[kind].Load()
+	}
+}
+
+func (super *Supernet) familyStats(forV6 bool) TrieStats {
+	if radix := super.radixTrie(forV6); radix != nil {
+		return radixStats(radix)
+	}
+	if forV6 {
+		return trieStats(super.ipv6Cidrs)
+	}
+	return trieStats(super.ipv4Cidrs)
+}
+
+func trieStats(root *CidrTrie) TrieStats {
+	stats := TrieStats{
+		LeafsByPrefixLen: map[int]int{},
+		NodesByDepth:     map[int]int{},
+		EstimatedMemory:  unsafe.Sizeof(*root),
+	}
+	// split CIDRs share their attributes, so each map is only counted once
+	countedAttributes := map[unsafe.Pointer]bool{}
+
+	root.ForEachStepDown(func(node *CidrTrie) {
+		stats.Nodes++
+		stats.NodesByDepth[node.Depth()]++
+		stats.EstimatedMemory += unsafe.Sizeof(*node)
+
+		metadata := node.Metadata()
+		if metadata == nil {
+			stats.PathNodes++
+			return
+		}
+		stats.LeafNodes++
+		stats.LeafsByPrefixLen[node.Depth()]++
+		stats.EstimatedMemory += metadataSize(metadata, countedAttributes)
+	}, nil)
+	return stats
+}
+
+// estimates the size of the metadata, the attributes are only counted if they are not in countedAttributes yet
+func metadataSize(metadata *Metadata, countedAttributes map[unsafe.Pointer]bool) uintptr {
+	size := unsafe.Sizeof(*metadata) + uintptr(cap(metadata.Priority))
+	if metadata.Attributes != nil {
+		pointer := reflect.ValueOf(metadata.Attributes).UnsafePointer()
+		if !countedAttributes[pointer] {
+			countedAttributes[pointer] = true
+			size += attributesSize(metadata.Attributes)
+		}
+	}
+	return size
+}
+
+// estimates the size of the attributes map, assuming a map entry costs its key and value headers plus their content
+func attributesSize(attributes map[string]string) uintptr {
+	size := uintptr(48) // map header
+	for key, value := range attributes {
+		size += 2*unsafe.Sizeof(key) + uintptr(len(key)+len(value))
+	}
+	return size
+}
+
+// WritePrometheus writes the stats to w in the Prometheus text exposition format.
+func (stats Stats) WritePrometheus(w io.Writer) error {
+	p := &prometheusWriter{w: w}
+
+	p.header("supernet_nodes", "gauge", "Number of trie nodes by family and type.")
+	for _, family := range stats.families() {
+		p.sample("supernet_nodes", family.stats.LeafNodes, "family", family.name, "type", "leaf")
+		p.sample("supernet_nodes", family.stats.PathNodes, "family", family.name, "type", "path")
+	}
+
+	p.header("supernet_leafs_by_prefix_length", "gauge", "Number of resolved CIDRs by family and prefix length.")
+	for _, family := range stats.families() {
+		for _, length := range sortedKeys(family.stats.LeafsByPrefixLen) {
+			p.sample("supernet_leafs_by_prefix_length", family.stats.LeafsByPrefixLen[length], "family", family.name, "prefix_length", fmt.Sprint(length))
+		}
+	}
+
+	p.header("supernet_nodes_by_depth", "gauge", "Number of trie nodes by family and depth.")
+	for _, family := range stats.families() {
+		for _, depth := range sortedKeys(family.stats.NodesByDepth) {
+			p.sample("supernet_nodes_by_depth", family.stats.NodesByDepth[depth], "family", family.name, "depth", fmt.Sprint(depth))
+		}
+	}
+
+	p.header("supernet_estimated_memory_bytes", "gauge", "Estimated memory held by the trie nodes and their metadata.")
+	for _, family := range stats.families() {
+		p.sample("supernet_estimated_memory_bytes", family.stats.EstimatedMemory, "family", family.name)
+	}
+
+	p.header("supernet_insertions_total", "counter", "Number of inserted CIDRs.")
+	p.sample("supernet_insertions_total", stats.Insertions)
+
+	p.header("supernet_conflicts_total", "counter", "Number of inserted CIDRs by conflict type.")
+	for kind := NoConflictKind; kind <= SubCIDRKind; kind++ {
+		p.sample("supernet_conflicts_total", stats.Conflicts[kind], "type", kind.String())
+	}
+
+	p.header("supernet_actions_total", "counter", "Number of actions taken to resolve conflicts by kind.")
+	for kind := IgnoreInsertionKind; kind <= SplitExistingCIDRKind; kind++ {
+		p.sample("supernet_actions_total", stats.Actions[kind], "action", kind.String())
+	}
+
+	return p.err
+}
+
+// StatsVar is an expvar variable with the stats of a Supernet, see PublishExpvar.
+type StatsVar struct {
+	super    *Supernet
+	snapshot atomic.Pointer[Stats]
+}
+
+// PublishExpvar exposes the stats of super as an expvar variable with the given name.
+//
+// The variable can be read at any time (e.g. on each request to /debug/vars): the insertion counters are read
+// when the variable is, but the trie stats are a snapshot, taken now and on each call to StatsVar.Update.
+func PublishExpvar(name string, super *Supernet) *StatsVar {
+	v := &StatsVar{super: super}
+	v.Update()
+	expvar.Publish(name, v)
+	return v
+}
+
+// Update takes a new snapshot of the trie stats, like Stats, it must not be called while CIDRs are being inserted.
+func (v *StatsVar) Update() {
+	stats := v.super.Stats()
+	v.snapshot.Store(&stats)
+}
+
+// String returns the stats as JSON, it implements expvar.Var.
+func (v *StatsVar) String() string {
+	stats := *v.snapshot.Load()
+	v.super.counters.load(&stats)
+	encoded, err := json.Marshal(stats)
+	if err != nil {
+		panic("[BUG] StatsVar.String: stats must be serializable: " + err.Error())
+	}
+	return string(encoded)
+}
+
+type familyStats struct {
+	name  string
+	stats TrieStats
+}
+
+func (stats Stats) families() []familyStats {
+	return []familyStats{{"ipv4", stats.IPv4}, {"ipv6", stats.IPv6}}
+}
+
+// writes Prometheus samples, and keeps the first write error
+type prometheusWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *prometheusWriter) header(name string, metricType string, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// labels are pairs of label name and value
+func (p *prometheusWriter) sample(name string, value any, labels ...string) {
+	line := name
+	if len(labels) > 0 {
+		line += "{"
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				line += ","
+			}
+			line += fmt.Sprintf("%s=%q", labels[i], labels[i+1])
+		}
+		line += "}"
+	}
+	p.printf("%s %v\n", line, value)
+}
+
+func (p *prometheusWriter) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
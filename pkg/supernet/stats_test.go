@@ -0,0 +1,77 @@
+package supernet
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"192.168.1.0/24", "192.168.0.0/16", "10.0.0.0/8", "2001:db8::/32"}, []uint8{1, 0, 0, 0})
+
+	stats := super.Stats()
+
+	// 192.168.0.0/16 is split around 192.168.1.0/24 into 8 CIDRs
+	assert.Equal(t, 10, stats.IPv4.LeafNodes)
+	assert.Equal(t, 1, stats.IPv4.LeafsByPrefixLen[8])
+	assert.Equal(t, 2, stats.IPv4.LeafsByPrefixLen[24])
+	assert.Equal(t, 1, stats.IPv4.LeafsByPrefixLen[17])
+	assert.Equal(t, stats.IPv4.Nodes, stats.IPv4.LeafNodes+stats.IPv4.PathNodes)
+	assert.Equal(t, 2, stats.IPv4.NodesByDepth[1])
+	assert.Equal(t, 32, stats.IPv6.Nodes)
+	assert.Equal(t, 1, stats.IPv6.LeafNodes)
+	assert.Greater(t, stats.IPv6.EstimatedMemory, uintptr(0))
+
+	assert.Equal(t, uint64(4), stats.Insertions)
+	assert.Equal(t, uint64(3), stats.Conflicts[NoConflictKind])
+	assert.Equal(t, uint64(1), stats.Conflicts[SuperCIDRKind])
+	assert.Equal(t, uint64(1), stats.Actions[SplitInsertedCIDRKind])
+	assert.Equal(t, uint64(3), stats.Actions[InsertNewCIDRKind])
+}
+
+func TestStatsPrometheus(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"10.0.0.0/8"}, []uint8{0})
+
+	output := &bytes.Buffer{}
+	assert.NoError(t, super.Stats().WritePrometheus(output))
+
+	assert.Contains(t, output.String(), "# TYPE supernet_nodes gauge\n")
+	assert.Contains(t, output.String(), `supernet_nodes{family="ipv4",type="leaf"} 1`+"\n")
+	assert.Contains(t, output.String(), `supernet_leafs_by_prefix_length{family="ipv4",prefix_length="8"} 1`+"\n")
+	assert.Contains(t, output.String(), `supernet_conflicts_total{type="no_conflict"} 1`+"\n")
+	assert.Contains(t, output.String(), `supernet_actions_total{action="insert_new_cidr"} 1`+"\n")
+}
+
+func TestStatsExpvar(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"10.0.0.0/8"}, []uint8{0})
+	statsVar := PublishExpvar("supernet_test", super)
+
+	stats := map[string]any{}
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get("supernet_test").String()), &stats))
+	assert.Equal(t, float64(1), stats["Insertions"])
+	assert.Equal(t, float64(1), stats["Conflicts"].(map[string]any)["no_conflict"])
+
+	// the variable is read while CIDRs are inserted, the counters are current, the trie stats are the last snapshot
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		insertAll(super, []string{"11.0.0.0/8", "12.0.0.0/8"}, []uint8{0, 0})
+	}()
+	for i := 0; i < 10; i++ {
+		_ = expvar.Get("supernet_test").String()
+	}
+	<-done
+
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get("supernet_test").String()), &stats))
+	assert.Equal(t, float64(3), stats["Insertions"])
+	assert.Equal(t, float64(1), stats["IPv4"].(map[string]any)["LeafNodes"])
+	statsVar.Update()
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get("supernet_test").String()), &stats))
+	assert.Equal(t, float64(3), stats["IPv4"].(map[string]any)["LeafNodes"])
+}
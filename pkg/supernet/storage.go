@@ -0,0 +1,515 @@
+package supernet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+)
+
+// The file format used by Save and Load, all the integers are little-endian.
+//
+//	header:  magic "SPNT" | version uint16 | reserved uint16 | section count uint32 | reserved uint32
+//	section: id uint32 | crc32 (IEEE) of the payload uint32 | payload length uint64 | payload | zero padding to 8 bytes
+//
+// The sections are:
+//   - strings: count uint32, then each string as length uint32 and bytes.
+//     It holds the attributes keys and values, and the origin CIDRs.
+//   - attributes: count uint32, then each attribute set as pairs count uint32 and (key, value) string indexes.
+//   - priorities: count uint32, then each priority as length uint16 and bytes.
+//   - IPv4 and IPv6 leafs: count uint32, padding uint32, then fixed size leaf records sorted by address.
+//
+// A leaf record is 32 bytes, so the leafs can be searched in place, without decoding them:
+//
+//	key hi uint64 | key lo uint64 | prefix length uint8 | padding [3]byte | origin string uint32 | attributes uint32 | priority uint32
+//
+// Unknown sections are skipped, so newer minor additions do not break older readers.
+const (
+	fileMagic      = "SPNT"
+	FileVersion    = 1
+	fileHeaderSize = 16
+
+	sectionHeaderSize = 16
+	sectionStrings    = 1
+	sectionAttributes = 2
+	sectionPriorities = 3
+	sectionIPv4Leafs  = 4
+	sectionIPv6Leafs  = 5
+
+	leafRecordSize = 32
+)
+
+// ErrInvalidFile is returned when loading a file that is not a valid supernet file, or that is corrupted.
+var ErrInvalidFile = errors.New("supernet: invalid file")
+
+// HasFileMagic reports whether data, e.g. the first bytes of a file, starts with the magic written by Save,
+// so a saved file can be found whatever its name. The rest of the file is only validated by Load and OpenReader.
+func HasFileMagic(data []byte) bool {
+	return len(data) >= len(fileMagic) && string(data[:len(fileMagic)]) == fileMagic
+}
+
+// Save writes the resolved CIDRs with their metadata to w, in a compact binary format that can be read back with Load.
+// Attributes and priorities are deduplicated, so the split fragments of a CIDR cost a single leaf record each.
+// The Source of the CIDRs is not saved, so the loaded CIDRs have none.
+func (super *Supernet) Save(w io.Writer) error {
+	return super.SaveMapped(w, nil)
+}
+
+// SaveMapped is Save, with the attributes of each CIDR written as returned by mapAttributes, if it is not nil.
+// The supernet is not modified, so mapAttributes must return a new map instead of changing the given one,
+// which can be shared by several CIDRs.
+func (super *Supernet) SaveMapped(w io.Writer, mapAttributes func(attributes map[string]string) map[string]string) error {
+	encoder := newFileEncoder()
+	for _, forV6 := range []bool{false, true} {
+		super.ForEachCidr(forV6, func(cidr netip.Prefix, metadata *Metadata) bool {
+			attributes := metadata.Attributes
+			if mapAttributes != nil {
+				attributes = mapAttributes(attributes)
+			}
+			encoder.addLeaf(forV6, cidr, metadata, attributes)
+			return true
+		})
+	}
+	return encoder.writeTo(w)
+}
+
+// Load reads a Supernet saved with Save.
+// The options are applied to the new Supernet before loading. The saved CIDRs are already resolved, so they are
+// built in the trie as they are, without being counted in the stats, logged, or journaled.
+//
+// The CIDRs with equal attributes share the same attributes map after loading.
+func Load(r io.Reader, options ...Option) (*Supernet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	file, err := decodeFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	super := NewSupernet(options...)
+	origins := map[uint32]*net.IPNet{}
+	attributes := map[uint32]map[string]string{}
+	priorities := map[uint32][]uint8{}
+	for _, forV6 := range []bool{false, true} {
+		root := super.ipv4Cidrs
+		if forV6 {
+			root = super.ipv6Cidrs
+		}
+		insert := func(path ipbits.Path, metadata *Metadata) { materialize(root, path, metadata) }
+		if radix := super.radixTrie(forV6); radix != nil {
+			insert = func(path ipbits.Path, metadata *Metadata) { radix.Insert(path, metadata) }
+		}
+
+		// the leafs are built without resolving conflicts, decodeFile checked that they are sorted and do not overlap
+		for i := 0; i < file.leafCount(forV6); i++ {
+			leaf := file.leaf(forV6, i)
+			origin, found := origins[leaf.origin]
+			if !found {
+				if _, origin, err = net.ParseCIDR(file.string(leaf.origin)); err != nil {
+					return nil, fmt.Errorf("%w: origin CIDR: %v", ErrInvalidFile, err)
+				}
+				origins[leaf.origin] = origin
+			}
+			if _, found := attributes[leaf.attributes]; !found {
+				attributes[leaf.attributes] = file.attributeMap(leaf.attributes)
+			}
+			if _, found := priorities[leaf.priority]; !found {
+				priorities[leaf.priority] = file.priority(leaf.priority)
+			}
+
+			insert(leaf.path, &Metadata{
+				originCIDR: origin,
+				IsV6:       forV6,
+				Priority:   priorities[leaf.priority],
+				Attributes: attributes[leaf.attributes],
+			})
+		}
+	}
+	return super, nil
+}
+
+// builds the sections of a file, while deduplicating strings, attributes and priorities
+type fileEncoder struct {
+	strings         []string
+	stringIndex     map[string]uint32
+	attributes      [][]uint32 // key and value string indexes
+	attributesIndex map[string]uint32
+	priorities      [][]uint8
+	priorityIndex   map[string]uint32
+	leafs           [2][]byte // IPv4 and IPv6 leaf records
+}
+
+func newFileEncoder() *fileEncoder {
+	return &fileEncoder{
+		stringIndex:     map[string]uint32{},
+		attributesIndex: map[string]uint32{},
+		priorityIndex:   map[string]uint32{},
+	}
+}
+
+func (e *fileEncoder) addLeaf(isV6 bool, cidr netip.Prefix, metadata *Metadata, attributes map[string]string) {
+	path := ipbits.PathFromPrefix(cidr)
+	record := make([]byte, leafRecordSize)
+	binary.LittleEndian.PutUint64(record[0:], path.Key.Hi)
+	binary.LittleEndian.PutUint64(record[8:], path.Key.Lo)
+	record[16] = uint8(path.Len)
+	binary.LittleEndian.PutUint32(record[20:], e.addString(metadata.originCIDR.String()))
+	binary.LittleEndian.PutUint32(record[24:], e.addAttributes(attributes))
+	binary.LittleEndian.PutUint32(record[28:], e.addPriority(metadata.Priority))
+
+	family := 0
+	if isV6 {
+		family = 1
+	}
+	e.leafs[family] = append(e.leafs[family], record...)
+}
+
+func (e *fileEncoder) addString(s string) uint32 {
+	index, found := e.stringIndex[s]
+	if !found {
+		index = uint32(len(e.strings))
+		e.strings = append(e.strings, s)
+		e.stringIndex[s] = index
+	}
+	return index
+}
+
+func (e *fileEncoder) addAttributes(attributes map[string]string) uint32 {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]uint32, 0, 2*len(keys))
+	canonical := make([]byte, 0, 8*len(keys))
+	for _, key := range keys {
+		pair := []uint32{e.addString(key), e.addString(attributes[key])}
+		pairs = append(pairs, pair...)
+		canonical = binary.LittleEndian.AppendUint32(canonical, pair[0])
+		canonical = binary.LittleEndian.AppendUint32(canonical, pair[1])
+	}
+
+	index, found := e.attributesIndex[string(canonical)]
+	if !found {
+		index = uint32(len(e.attributes))
+		e.attributes = append(e.attributes, pairs)
+		e.attributesIndex[string(canonical)] = index
+	}
+	return index
+}
+
+func (e *fileEncoder) addPriority(priority []uint8) uint32 {
+	index, found := e.priorityIndex[string(priority)]
+	if !found {
+		index = uint32(len(e.priorities))
+		e.priorities = append(e.priorities, priority)
+		e.priorityIndex[string(priority)] = index
+	}
+	return index
+}
+
+func (e *fileEncoder) writeTo(w io.Writer) error {
+	sections := map[uint32][]byte{}
+
+	payload := binary.LittleEndian.AppendUint32(nil, uint32(len(e.strings)))
+	for _, s := range e.strings {
+		payload = binary.LittleEndian.AppendUint32(payload, uint32(len(s)))
+		payload = append(payload, s...)
+	}
+	sections[sectionStrings] = payload
+
+	payload = binary.LittleEndian.AppendUint32(nil, uint32(len(e.attributes)))
+	for _, pairs := range e.attributes {
+		payload = binary.LittleEndian.AppendUint32(payload, uint32(len(pairs)/2))
+		for _, index := range pairs {
+			payload = binary.LittleEndian.AppendUint32(payload, index)
+		}
+	}
+	sections[sectionAttributes] = payload
+
+	payload = binary.LittleEndian.AppendUint32(nil, uint32(len(e.priorities)))
+	for _, priority := range e.priorities {
+		payload = binary.LittleEndian.AppendUint16(payload, uint16(len(priority)))
+		payload = append(payload, priority...)
+	}
+	sections[sectionPriorities] = payload
+
+	for family, id := range []uint32{sectionIPv4Leafs, sectionIPv6Leafs} {
+		payload = binary.LittleEndian.AppendUint32(nil, uint32(len(e.leafs[family])/leafRecordSize))
+		payload = append(payload, 0, 0, 0, 0) // the records are aligned to 8 bytes
+		sections[id] = append(payload, e.leafs[family]...)
+	}
+
+	buffered := bufio.NewWriter(w)
+	header := make([]byte, fileHeaderSize)
+	copy(header, fileMagic)
+	binary.LittleEndian.PutUint16(header[4:], FileVersion)
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(sections)))
+	if _, err := buffered.Write(header); err != nil {
+		return err
+	}
+
+	for _, id := range []uint32{sectionStrings, sectionAttributes, sectionPriorities, sectionIPv4Leafs, sectionIPv6Leafs} {
+		payload := sections[id]
+		sectionHeader := make([]byte, sectionHeaderSize)
+		binary.LittleEndian.PutUint32(sectionHeader[0:], id)
+		binary.LittleEndian.PutUint32(sectionHeader[4:], crc32.ChecksumIEEE(payload))
+		binary.LittleEndian.PutUint64(sectionHeader[8:], uint64(len(payload)))
+		if _, err := buffered.Write(sectionHeader); err != nil {
+			return err
+		}
+		if _, err := buffered.Write(payload); err != nil {
+			return err
+		}
+		if _, err := buffered.Write(make([]byte, padding(len(payload)))); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+// returns the number of zero bytes needed to align length to 8 bytes
+func padding(length int) int {
+	return (8 - length%8) % 8
+}
+
+// the sections of a file, the dictionaries are indexed and their entries are decoded on demand,
+// the leaf records are kept as is
+type fileSections struct {
+	strings    fileDictionary
+	attributes fileDictionary
+	priorities fileDictionary
+	leafs      [2][]byte // IPv4 and IPv6 leaf records
+}
+
+// the entries of a dictionary section, by their offset in the payload
+type fileDictionary struct {
+	payload []byte
+	offsets []uint32
+}
+
+func (d *fileDictionary) len() int {
+	return len(d.offsets)
+}
+
+// returns the payload starting at the entry i
+func (d *fileDictionary) entry(i uint32) []byte {
+	return d.payload[d.offsets[i]:]
+}
+
+// returns a copy of the string i, so it stays valid when the file is unmapped
+func (f *fileSections) string(i uint32) string {
+	entry := f.strings.entry(i)
+	return string(entry[4 : 4+binary.LittleEndian.Uint32(entry)])
+}
+
+// decodes the attribute set i into a new map
+func (f *fileSections) attributeMap(i uint32) map[string]string {
+	entry := f.attributes.entry(i)
+	pairs := binary.LittleEndian.Uint32(entry)
+	attributes := make(map[string]string, pairs)
+	for j := uint32(0); j < pairs; j++ {
+		pair := entry[4+j*8:]
+		attributes[f.string(binary.LittleEndian.Uint32(pair))] = f.string(binary.LittleEndian.Uint32(pair[4:]))
+	}
+	return attributes
+}
+
+// returns a copy of the priority i
+func (f *fileSections) priority(i uint32) []uint8 {
+	entry := f.priorities.entry(i)
+	return append([]uint8{}, entry[2:2+binary.LittleEndian.Uint16(entry)]...)
+}
+
+// a decoded leaf record
+type fileLeaf struct {
+	path       ipbits.Path
+	origin     uint32
+	attributes uint32
+	priority   uint32
+}
+
+func (f *fileSections) leafCount(isV6 bool) int {
+	if isV6 {
+		return len(f.leafs[1]) / leafRecordSize
+	}
+	return len(f.leafs[0]) / leafRecordSize
+}
+
+func (f *fileSections) leaf(isV6 bool, i int) fileLeaf {
+	records := f.leafs[0]
+	if isV6 {
+		records = f.leafs[1]
+	}
+	record := records[i*leafRecordSize : (i+1)*leafRecordSize]
+	return fileLeaf{
+		path: ipbits.Path{
+			Key: ipbits.Key{Hi: binary.LittleEndian.Uint64(record[0:]), Lo: binary.LittleEndian.Uint64(record[8:])},
+			Len: int(record[16]),
+		},
+		origin:     binary.LittleEndian.Uint32(record[20:]),
+		attributes: binary.LittleEndian.Uint32(record[24:]),
+		priority:   binary.LittleEndian.Uint32(record[28:]),
+	}
+}
+
+// checks the header, the checksums and the leaf records of a file, and indexes its sections.
+// The dictionaries and the leaf records are not copied, they point to data,
+// and the dictionary entries are copied out of data when they are decoded.
+func decodeFile(data []byte) (*fileSections, error) {
+	if len(data) < fileHeaderSize || string(data[:4]) != fileMagic {
+		return nil, fmt.Errorf("%w: not a supernet file", ErrInvalidFile)
+	}
+	if version := binary.LittleEndian.Uint16(data[4:]); version != FileVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d, expected %d", ErrInvalidFile, version, FileVersion)
+	}
+
+	payloads := map[uint32][]byte{}
+	count := binary.LittleEndian.Uint32(data[8:])
+	offset := fileHeaderSize
+	for i := uint32(0); i < count; i++ {
+		if len(data)-offset < sectionHeaderSize {
+			return nil, fmt.Errorf("%w: truncated section header", ErrInvalidFile)
+		}
+		id := binary.LittleEndian.Uint32(data[offset:])
+		checksum := binary.LittleEndian.Uint32(data[offset+4:])
+		length := binary.LittleEndian.Uint64(data[offset+8:])
+		offset += sectionHeaderSize
+		if length > uint64(len(data)-offset) {
+			return nil, fmt.Errorf("%w: truncated section %d", ErrInvalidFile, id)
+		}
+		payload := data[offset : offset+int(length)]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			return nil, fmt.Errorf("%w: checksum mismatch in section %d", ErrInvalidFile, id)
+		}
+		payloads[id] = payload
+		offset += int(length) + padding(int(length))
+	}
+
+	file := &fileSections{}
+	for _, id := range []uint32{sectionStrings, sectionAttributes, sectionPriorities, sectionIPv4Leafs, sectionIPv6Leafs} {
+		if _, found := payloads[id]; !found {
+			return nil, fmt.Errorf("%w: missing section %d", ErrInvalidFile, id)
+		}
+	}
+
+	reader := &sectionReader{data: payloads[sectionStrings]}
+	file.strings = fileDictionary{payload: reader.data, offsets: make([]uint32, reader.count(4))}
+	for i := range file.strings.offsets {
+		file.strings.offsets[i] = uint32(reader.offset)
+		reader.bytes(int(reader.uint32()))
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	reader = &sectionReader{data: payloads[sectionAttributes]}
+	file.attributes = fileDictionary{payload: reader.data, offsets: make([]uint32, reader.count(4))}
+	for i := range file.attributes.offsets {
+		file.attributes.offsets[i] = uint32(reader.offset)
+		pairs := reader.count(8)
+		for j := 0; j < pairs; j++ {
+			key, value := reader.uint32(), reader.uint32()
+			if int(key) >= file.strings.len() || int(value) >= file.strings.len() {
+				return nil, fmt.Errorf("%w: attribute string out of range", ErrInvalidFile)
+			}
+		}
+		if reader.err != nil {
+			return nil, reader.err
+		}
+	}
+
+	reader = &sectionReader{data: payloads[sectionPriorities]}
+	file.priorities = fileDictionary{payload: reader.data, offsets: make([]uint32, reader.count(2))}
+	for i := range file.priorities.offsets {
+		file.priorities.offsets[i] = uint32(reader.offset)
+		reader.bytes(int(reader.uint16()))
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	for family, id := range []uint32{sectionIPv4Leafs, sectionIPv6Leafs} {
+		reader = &sectionReader{data: payloads[id]}
+		count := int(reader.uint32())
+		reader.uint32()
+		file.leafs[family] = reader.bytes(count * leafRecordSize)
+		if reader.err != nil {
+			return nil, reader.err
+		}
+		isV6 := family == 1
+		var previous ipbits.Path
+		for i := 0; i < count; i++ {
+			leaf := file.leaf(isV6, i)
+			if !file.validLeaf(isV6, leaf) {
+				return nil, fmt.Errorf("%w: invalid leaf record %d", ErrInvalidFile, i)
+			}
+			// the leafs are searched in place by a Reader, and built as they are by Load
+			if i > 0 && (leaf.path.Key.Compare(previous.Key) < 0 || previous.Contains(leaf.path) || leaf.path.Contains(previous)) {
+				return nil, fmt.Errorf("%w: leaf record %d is not sorted or overlaps the previous one", ErrInvalidFile, i)
+			}
+			previous = leaf.path
+		}
+	}
+	return file, nil
+}
+
+// reports whether the leaf prefix length and indexes are in range, and the prefix has no host bits set
+func (f *fileSections) validLeaf(isV6 bool, leaf fileLeaf) bool {
+	return leaf.path.Len >= 1 && leaf.path.Len <= ipbits.MaxLen(isV6) &&
+		leaf.path.Key.Masked(leaf.path.Len) == leaf.path.Key &&
+		int(leaf.origin) < f.strings.len() &&
+		int(leaf.attributes) < f.attributes.len() &&
+		int(leaf.priority) < f.priorities.len()
+}
+
+// reads the values of a section payload, the first out of bound read sets err
+type sectionReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *sectionReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data)-r.offset {
+		r.err = fmt.Errorf("%w: truncated section", ErrInvalidFile)
+		return nil
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+// reads a count of items, which take at least itemSize bytes each, so a corrupted count does not allocate too much
+func (r *sectionReader) count(itemSize int) int {
+	count := int(r.uint32())
+	if count > (len(r.data)-r.offset)/itemSize {
+		r.err = fmt.Errorf("%w: truncated section", ErrInvalidFile)
+		return 0
+	}
+	return count
+}
+
+func (r *sectionReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *sectionReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
@@ -0,0 +1,143 @@
+package supernet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"192.168.1.0/24", "192.168.0.0/16", "10.0.0.0/8", "2001:db8::/32", "2001:db8:1::/48"}, []uint8{1, 0, 0, 0, 1})
+
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, super.Save(buffer))
+	assert.True(t, HasFileMagic(buffer.Bytes()[:4]))
+	assert.False(t, HasFileMagic([]byte("SPN")))
+	assert.False(t, HasFileMagic([]byte("cidr,name\n")))
+
+	loaded, err := Load(bytes.NewReader(buffer.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, super.AllCidrsString(false), loaded.AllCidrsString(false))
+	assert.Equal(t, super.AllCidrsString(true), loaded.AllCidrsString(true))
+
+	for _, addr := range []string{"192.168.1.1", "192.168.200.1", "10.1.1.1", "2001:db8:1::1", "2001:db8:2::1"} {
+		expectedPrefix, expectedMetadata, _ := super.LookupAddr(netip.MustParseAddr(addr))
+		prefix, metadata, found := loaded.LookupAddr(netip.MustParseAddr(addr))
+		assert.True(t, found, addr)
+		assert.Equal(t, expectedPrefix, prefix, addr)
+		assert.Equal(t, expectedMetadata.Attributes, metadata.Attributes, addr)
+		assert.Equal(t, expectedMetadata.Priority, metadata.Priority, addr)
+		assert.Equal(t, expectedMetadata.originCIDR.String(), metadata.originCIDR.String(), addr)
+	}
+
+	// the origin is kept, so the fragments can still be removed
+	assert.Equal(t, 8, loaded.RemoveCidr(cidr("192.168.0.0/16")))
+}
+
+func TestLoadBuildsTheLeafs(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"192.168.1.0/24", "192.168.0.0/16", "2001:db8::/32"}, []uint8{1, 0, 0})
+	super.InsertCidr(cidr("10.0.0.0/8"), &Metadata{Source: &Source{File: "feed.csv", Line: 2}})
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, super.Save(buffer))
+
+	path := filepath.Join(t.TempDir(), "supernet.journal")
+	journal, err := OpenJournal(path)
+	assert.NoError(t, err)
+	for name, options := range map[string][]Option{"binary": {WithJournal(journal)}, "radix": {WithJournal(journal), WithRadixTrie()}} {
+		loaded, err := Load(bytes.NewReader(buffer.Bytes()), options...)
+		assert.NoError(t, err, name)
+		assert.Equal(t, super.AllCidrsString(false), loaded.AllCidrsString(false), name)
+		assert.Equal(t, super.AllCidrsString(true), loaded.AllCidrsString(true), name)
+
+		// the saved CIDRs are resolved, so loading them is not an insertion
+		assert.Zero(t, loaded.Stats().Insertions, name)
+
+		// the source is not saved
+		_, metadata, _ := loaded.LookupAddr(netip.MustParseAddr("10.0.0.1"))
+		assert.Nil(t, metadata.Source, name)
+	}
+	assert.NoError(t, journal.Close())
+	journaled, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Empty(t, journaled)
+}
+
+func TestSaveMapped(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"192.168.1.0/24", "10.0.0.0/8"}, []uint8{0, 0})
+
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, super.SaveMapped(buffer, func(attributes map[string]string) map[string]string {
+		return map[string]string{"saved": attributes["cidr"]}
+	}))
+	_, metadata, _ := super.LookupAddr(netip.MustParseAddr("10.0.0.1"))
+	assert.Equal(t, map[string]string{"cidr": "10.0.0.0/8"}, metadata.Attributes)
+
+	loaded, err := Load(bytes.NewReader(buffer.Bytes()))
+	assert.NoError(t, err)
+	_, metadata, _ = loaded.LookupAddr(netip.MustParseAddr("10.0.0.1"))
+	assert.Equal(t, map[string]string{"saved": "10.0.0.0/8"}, metadata.Attributes)
+}
+
+func TestSaveDeduplicates(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"192.168.1.0/24", "192.168.0.0/16"}, []uint8{1, 0})
+
+	encoder := newFileEncoder()
+	super.ForEachCidr(false, func(cidr netip.Prefix, metadata *Metadata) bool {
+		encoder.addLeaf(false, cidr, metadata, metadata.Attributes)
+		return true
+	})
+	// 9 leafs, but only 2 attribute sets and priorities
+	assert.Len(t, encoder.leafs[0], 9*leafRecordSize)
+	assert.Len(t, encoder.attributes, 2)
+	assert.Len(t, encoder.priorities, 2)
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	super := NewSupernet()
+	insertAll(super, []string{"192.168.1.0/24", "2001:db8::/32"}, []uint8{0, 0})
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, super.Save(buffer))
+	valid := buffer.Bytes()
+
+	// the leafs are built as they are, so overlapping leafs would break the trie
+	encoder := newFileEncoder()
+	for _, leaf := range []string{"10.0.0.0/8", "10.1.0.0/16"} {
+		encoder.addLeaf(false, netip.MustParsePrefix(leaf), &Metadata{originCIDR: cidr(leaf)}, nil)
+	}
+	overlapping := &bytes.Buffer{}
+	assert.NoError(t, encoder.writeTo(overlapping))
+
+	// a leaf with host bits set is not a node of the trie
+	encoder = newFileEncoder()
+	encoder.addLeaf(false, netip.MustParsePrefix("10.0.0.0/8"), &Metadata{originCIDR: cidr("10.0.0.0/8")}, nil)
+	encoder.leafs[0][0] |= 1
+	hostBits := &bytes.Buffer{}
+	assert.NoError(t, encoder.writeTo(hostBits))
+
+	corrupt := func(f func(data []byte) []byte) []byte {
+		return f(append([]byte{}, valid...))
+	}
+
+	testCases := map[string][]byte{
+		"empty":     {},
+		"magic":     corrupt(func(data []byte) []byte { data[0] = 'X'; return data }),
+		"version":   corrupt(func(data []byte) []byte { binary.LittleEndian.PutUint16(data[4:], FileVersion+1); return data }),
+		"checksum":  corrupt(func(data []byte) []byte { data[len(data)-20] ^= 0xff; return data }),
+		"truncated": corrupt(func(data []byte) []byte { return data[:len(data)-40] }),
+		"overlap":   overlapping.Bytes(),
+		"host bits": hostBits.Bytes(),
+	}
+	for name, data := range testCases {
+		_, err := Load(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrInvalidFile, name)
+	}
+}
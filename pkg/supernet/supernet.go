@@ -3,8 +3,9 @@ package supernet
 import (
 	"fmt"
 	"net"
-	"strings"
+	"net/netip"
 
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
 	"github.com/khalid-nowaf/supernet/pkg/trie"
 )
 
@@ -16,6 +17,17 @@ type Metadata struct {
 	IsV6       bool              // is it IPv6 CIDR
 	Priority   []uint8           // min value 0, max value 255, and all CIDR in the tree must have the same length
 	Attributes map[string]string // generic key value attributes to hold additional information about the CIDR
+	Source     *Source           // where the CIDR was read from, if known, the fragments of a split CIDR keep it
+}
+
+// Source is the location of the record a CIDR was read from.
+type Source struct {
+	File string
+	Line int
+}
+
+func (source Source) String() string {
+	return fmt.Sprintf("%s:%d", source.File, source.Line)
 }
 
 // construct a Metadata for a cidr
@@ -35,8 +47,14 @@ func NewMetadata(ipnet *net.IPNet) *Metadata {
 type Supernet struct {
 	ipv4Cidrs  *CidrTrie
 	ipv6Cidrs  *CidrTrie
+	ipv4Radix  *RadixCidrTrie // set with WithRadixTrie, replaces ipv4Cidrs
+	ipv6Radix  *RadixCidrTrie // set with WithRadixTrie, replaces ipv6Cidrs
 	comparator ComparatorOption
 	logger     LoggerOption
+	journal    *Journal
+	attributes *AttributeStore // set with WithAttributeStore
+	counters   *insertionCounters
+	nodes      *nodeSlab // the nodes of the sequential insertions
 }
 
 // initializes a new supernet instance with separate tries for IPv4 and IPv6 CIDRs.
@@ -51,9 +69,12 @@ func NewSupernet(options ...Option) *Supernet {
 // InsertCidr attempts to insert a new CIDR into the supernet, handling conflicts according to predefined priorities.
 // It traverses through the trie, adding new nodes as needed and resolving conflicts when they occur.
 func (super *Supernet) InsertCidr(ipnet *net.IPNet, metadata *Metadata) *InsertionResult {
+	return super.insert(ipnet, prepareMetadata(ipnet, metadata))
+}
 
-	root := super.ipv4Cidrs
-	path, depth := CidrToBits(ipnet)
+// fills the metadata of a CIDR given to InsertCidr, the CIDR size is added to its priority
+func prepareMetadata(ipnet *net.IPNet, metadata *Metadata) *Metadata {
+	path := cidrPath(ipnet)
 	copyMetadata := metadata
 	if copyMetadata == nil {
 		copyMetadata = NewMetadata(ipnet)
@@ -61,63 +82,210 @@ func (super *Supernet) InsertCidr(ipnet *net.IPNet, metadata *Metadata) *Inserti
 
 	if ipnet.IP.To4() == nil {
 		copyMetadata.IsV6 = true
-		root = super.ipv6Cidrs
 	}
 
-	if copyMetadata.IsV6 {
+	// add size of the subnet as priory
+	copyMetadata.Priority = append(copyMetadata.Priority, uint8(path.Len-1))
+	copyMetadata.originCIDR = ipnet
+	return copyMetadata
+}
+
+// insert places a leaf with its final metadata in the trie, recording it in the journal first.
+// unlike InsertCidr, the metadata is used as is, so it can be used to replay the journal.
+func (super *Supernet) insert(ipnet *net.IPNet, metadata *Metadata) *InsertionResult {
+	root := super.ipv4Cidrs
+	if metadata.IsV6 {
 		root = super.ipv6Cidrs
 	}
+	return super.insertUnder(root, ipnet, metadata, super.nodes)
+}
 
-	// add size of the subnet as priory
-	copyMetadata.Priority = append(copyMetadata.Priority, uint8(depth))
-	copyMetadata.originCIDR = ipnet
-	results := super.insertLeaf(
-		root,
-		path,
-		trie.NewTrieWithMetadata(copyMetadata),
-	)
+// replaces the attributes with the shared ones of the attribute store, if the supernet has one
+func (super *Supernet) intern(metadata *Metadata) {
+	if super.attributes != nil && metadata.Attributes != nil {
+		_, metadata.Attributes = super.attributes.Intern(metadata.Attributes)
+	}
+}
+
+// inserts the CIDR in the subtree of root, which must contain it, root is ignored with radix tries.
+// The new nodes are allocated from nodes, which must not be used concurrently.
+func (super *Supernet) insertUnder(root *CidrTrie, ipnet *net.IPNet, metadata *Metadata, nodes *nodeSlab) *InsertionResult {
+	path := cidrPath(ipnet)
+	super.intern(metadata)
+
+	super.journal.appendInsert(ipnet, metadata)
+	var results *InsertionResult
+	if radix := super.radixTrie(metadata.IsV6); radix != nil {
+		results = super.insertRadix(radix, path, trie.NewTrieWithMetadata(metadata))
+	} else {
+		leaf := nodes.new()
+		leaf.UpdateMetadata(metadata)
+		results = super.insertLeaf(root, path, leaf, nodes)
+	}
+	super.counters.record(results)
 	super.logger(results)
 	return results
 }
 
-// LookupIP searches for the closest matching CIDR for a given IP address within the supernet.
-func (super *Supernet) LookupIP(ip string) (*net.IPNet, *CidrTrie, error) {
-	// Determine if the IP is IPv4 or IPv6 based on the presence of a colon.
-	isV6 := strings.Contains(ip, ":")
-	mask := 32
-	supernet := super.ipv4Cidrs
+// RemoveCidr removes every resolved CIDR that originated from ipnet, including the fragments
+// created when ipnet was split during conflict resolution, and returns how many were removed.
+//
+// Note: address space that ipnet took over from lower priority CIDRs is not given back to them.
+func (super *Supernet) RemoveCidr(ipnet *net.IPNet) int {
+	root := super.ipv4Cidrs
+	if ipnet.IP.To4() == nil {
+		root = super.ipv6Cidrs
+	}
+	path := cidrPath(ipnet)
 
-	if isV6 {
-		mask = 128
-		supernet = super.ipv6Cidrs
+	super.journal.appendRemove(ipnet)
+	if radix := super.radixTrie(ipnet.IP.To4() == nil); radix != nil {
+		return removeRadix(radix, path, ipnet.String())
+	}
+
+	node := root
+	for depth := 0; depth < path.Len; depth++ {
+		node = node.Child(path.Key.Bit(depth))
+		if node == nil || node.Metadata() != nil {
+			break
+		}
+	}
+	if node == nil || node.Depth() < path.Len {
+		// nothing was inserted at, or under this CIDR
+		return 0
 	}
 
-	// Parse the IP address with a full netmask to form a valid CIDR for bit conversion.
-	_, parsedIP, err := net.ParseCIDR(fmt.Sprintf("%s/%d", ip, mask))
+	removed := 0
+	candidates := []*CidrTrie{node}
+	if !node.IsLeaf() {
+		candidates = node.Leafs()
+	}
+	for _, leaf := range candidates {
+		if leaf.Metadata() != nil && leaf.Metadata().originCIDR.String() == ipnet.String() {
+			leaf.DetachBranch(0)
+			removed++
+		}
+	}
+	return removed
+}
+
+// LookupIP searches for the closest matching CIDR for a given IP address within the supernet.
+// The returned *net.IPNet is allocated on each match, use LookupAddr in hot paths.
+// With WithRadixTrie, the returned node is built on each match, with the path nodes of its CIDR.
+func (super *Supernet) LookupIP(ip string) (*net.IPNet, *CidrTrie, error) {
+	addr, err := netip.ParseAddr(ip)
 	if err != nil {
 		return nil, nil, err
 	}
+	var node *CidrTrie
+	if radix := super.radixTrie(addr.Unmap().Is6()); radix != nil {
+		// the node is built in a binary trie, so its prefix can be computed from its parents
+		if prefix, metadata, found := super.LookupAddr(addr); found {
+			node = materialize(&CidrTrie{}, ipbits.PathFromPrefix(prefix), metadata)
+		}
+	} else {
+		node = super.lookupNode(addr)
+	}
+	if node == nil {
+		return nil, nil, nil
+	}
+	return prefixToIPNet(NodeToPrefix(node)), node, nil
+}
 
-	ipBits, _ := CidrToBits(parsedIP)
+// LookupAddr searches for the resolved CIDR containing addr, and returns it with its metadata.
+// Unlike LookupIP, it does not allocate, so it is the one to use for lookups in hot paths.
+func (super *Supernet) LookupAddr(addr netip.Addr) (netip.Prefix, *Metadata, bool) {
+	addr = addr.Unmap()
+	if radix := super.radixTrie(addr.Is6()); radix != nil {
+		path, metadata, found := radix.Covering(ipbits.Path{Key: ipbits.FromAddr(addr), Len: addr.BitLen()})
+		if !found {
+			return netip.Prefix{}, nil, false
+		}
+		return path.Prefix(addr.Is6()), metadata, true
+	}
+	node := super.lookupNode(addr)
+	if node == nil {
+		return netip.Prefix{}, nil, false
+	}
+	return NodeToPrefix(node), node.Metadata(), true
+}
 
-	// Traverse the trie to find the most specific matching CIDR.
-	for i, bit := range ipBits {
-		if supernet == nil {
-			// Return nil if no matching CIDR is found in the trie.
-			return nil, nil, nil
-		} else if supernet.IsLeaf() {
-			return BitsToCidr(ipBits[:i], isV6), supernet, nil
-		} else {
-			supernet = supernet.Child(bit)
+// LookupPrefix calls f with the resolved CIDR containing prefix, if there is one,
+// otherwise with each resolved CIDR within prefix, in address order, until f returns false.
+func (super *Supernet) LookupPrefix(prefix netip.Prefix, f func(cidr netip.Prefix, metadata *Metadata) bool) {
+	prefix = unmapPrefix(prefix)
+	isV6 := prefix.Addr().Is6()
+	path := ipbits.PathFromPrefix(prefix)
+	if radix := super.radixTrie(isV6); radix != nil {
+		if covering, metadata, found := radix.Covering(path); found {
+			f(covering.Prefix(isV6), metadata)
+			return
+		}
+		radix.Within(path, func(within ipbits.Path, metadata *Metadata) bool {
+			return f(within.Prefix(isV6), metadata)
+		})
+		return
+	}
+
+	node := super.ipv4Cidrs
+	if isV6 {
+		node = super.ipv6Cidrs
+	}
+	for depth := 0; depth < path.Len && node.Metadata() == nil; depth++ {
+		if node = node.Child(path.Key.Bit(depth)); node == nil {
+			return
+		}
+	}
+	if node.Metadata() != nil {
+		f(NodeToPrefix(node), node.Metadata())
+		return
+	}
+	for it := newCidrIterator(node, isV6); it.Next(); {
+		if !f(it.Prefix(), it.Metadata()) {
+			return
+		}
+	}
+}
+
+// walks the trie following the bits of addr, until it reaches a leaf, or falls off the trie
+func (super *Supernet) lookupNode(addr netip.Addr) *CidrTrie {
+	addr = addr.Unmap()
+	node := super.ipv4Cidrs
+	if addr.Is6() {
+		node = super.ipv6Cidrs
+	}
+	key := ipbits.FromAddr(addr)
+
+	for depth := 0; depth <= addr.BitLen(); depth++ {
+		if node.IsLeaf() {
+			if node.Metadata() == nil {
+				// empty trie, the root is a leaf without metadata
+				return nil
+			}
+			return node
+		}
+		if depth == addr.BitLen() {
+			break
+		}
+		if node = node.Child(key.Bit(depth)); node == nil {
+			return nil
 		}
 	}
 
-	// The loop should always return before reaching this point.
-	panic("[BUG] LookupIP: reached an unexpected state, the CIDR trie traversal should not get here.")
+	panic("[BUG] LookupAddr: reached an unexpected state, the CIDR trie traversal should not get here.")
 }
 
 // retrieves all CIDRs from the specified IPv4 or IPv6 trie within a supernet.
+// With WithRadixTrie, the nodes are built in a binary trie on each call, ForEachCidr does not build them.
 func (super *Supernet) AllCIDRS(forV6 bool) []*CidrTrie {
+	if radix := super.radixTrie(forV6); radix != nil {
+		root := &CidrTrie{}
+		leafs := []*CidrTrie{}
+		for it := radix.Iterator(); it.Next(); {
+			leafs = append(leafs, materialize(root, it.Path(), it.Value()))
+		}
+		return leafs
+	}
 	supernet := super.ipv4Cidrs
 	if forV6 {
 		supernet = super.ipv6Cidrs
@@ -127,59 +295,81 @@ func (super *Supernet) AllCIDRS(forV6 bool) []*CidrTrie {
 
 // retrieves all CIDRs from the specified IPv4 or IPv6 trie within a supernet.
 func (super *Supernet) AllCidrsString(forV6 bool) []string {
-	supernet := super.ipv4Cidrs
-	if forV6 {
-		supernet = super.ipv6Cidrs
-	}
 	var cidrs []string
-	for _, node := range supernet.Leafs() {
-		cidrs = append(cidrs, BitsToCidr(node.Path(), forV6).String())
-	}
+	super.ForEachCidr(forV6, func(cidr netip.Prefix, _ *Metadata) bool {
+		cidrs = append(cidrs, cidr.String())
+		return true
+	})
 	return cidrs
 }
 
+// ForEachCidr calls f for each resolved CIDR of the IPv4 or IPv6 trie, in address order, until f returns false.
+// Unlike AllCIDRS, it does not build any node or slice of nodes, whichever trie is used, see Cidrs.
+func (super *Supernet) ForEachCidr(forV6 bool, f func(cidr netip.Prefix, metadata *Metadata) bool) {
+	for it := super.Cidrs(forV6); it.Next(); {
+		if !f(it.Prefix(), it.Metadata()) {
+			return
+		}
+	}
+}
+
 // creates a new trie node intended for path utilization without any associated metadata.
 func newPathNode() *CidrTrie {
 	return &CidrTrie{}
 }
 
-// build the CIDR path, and report any conflict
-func buildPath(root *CidrTrie, path []int) (lastNode *CidrTrie, conflict ConflictType, remainingPath []int) {
+// returns the child of node at pos, a new path node is only allocated from nodes if there is no child yet
+func pathChild(node *CidrTrie, pos trie.ChildPos, nodes *nodeSlab) *CidrTrie {
+	if child := node.Child(pos); child != nil {
+		return child
+	}
+	return node.AttachChild(nodes.new(), pos)
+}
+
+// build the CIDR path, starting from the depth of root, with the new path nodes allocated from nodes, and report any conflict
+func buildPath(root *CidrTrie, path ipbits.Path, nodes *nodeSlab) (lastNode *CidrTrie, conflict ConflictType) {
 	currentNode := root
-	for currentDepth, bit := range path {
+	for depth := root.Depth(); depth < path.Len; depth++ {
 		// add a pathNode, if the current node is nil
-		currentNode = currentNode.AttachChild(newPathNode(), bit)
+		currentNode = pathChild(currentNode, path.Key.Bit(depth), nodes)
 
-		conflictType := isThereAConflict(currentNode, len(path))
+		conflictType := isThereAConflict(currentNode, path.Len)
 
-		// if the there is a conflict, return the conflicting point node, and the remaining bits (path)
+		// if the there is a conflict, return the conflicting point node, the remaining path starts after its depth
 		if _, noConflict := conflictType.(NoConflict); !noConflict {
-			return currentNode, conflictType, path[currentDepth+1:]
+			return currentNode, conflictType
 		}
 	}
-	return currentNode, NoConflict{}, []int{} // empty
+	return currentNode, NoConflict{}
 }
 
 // try to build the CIDR path, and handle any conflict if any
-func (super Supernet) insertLeaf(root *CidrTrie, path []int, newCidrNode *CidrTrie) *InsertionResult {
-	insertionResults := &InsertionResult{
-		CIDR: newCidrNode.Metadata().originCIDR,
-	}
+func (super Supernet) insertLeaf(root *CidrTrie, path ipbits.Path, newCidrNode *CidrTrie, nodes *nodeSlab) *InsertionResult {
+	cidr := ipnetToPrefix(newCidrNode.Metadata().originCIDR)
 
 	// buildPath will tell us the strategy to resolve the conflict if there is
 	// any.
-	lastNode, conflictType, remainingPath := buildPath(root, path)
-	insertionResults.ConflictType = conflictType
+	lastNode, conflictType := buildPath(root, path, nodes)
+	if _, noConflict := conflictType.(NoConflict); noConflict {
+		// like the InsertNewCIDR action, without a resolution plan, since most insertions do not conflict
+		lastNode.Parent().ReplaceChild(newCidrNode, lastNode.Pos())
+		return noConflictResult(cidr, NodeToPrefix(newCidrNode))
+	}
+
+	insertionResults := &InsertionResult{CIDR: cidr, ConflictType: conflictType}
 
 	// based on the conflict we will get resolve
 	// and the resolver will return a resolution plan for each conflict
 	plan := conflictType.Resolve(lastNode, newCidrNode, super.comparator)
-	insertionResults.ConflictedWith = append(insertionResults.ConflictedWith, plan.Conflicts...)
+	for _, conflicted := range plan.Conflicts {
+		insertionResults.ConflictedWith = append(insertionResults.ConflictedWith, NodeToPrefix(&conflicted))
+		insertionResults.ConflictedMetadata = append(insertionResults.ConflictedMetadata, conflicted.Metadata())
+	}
 
 	for _, step := range plan.Steps {
 		// each plan has an action has an excitor, and return an action result
-		result := step.Action.Execute(newCidrNode, lastNode, step.TargetNode, remainingPath)
-		insertionResults.actions = append(insertionResults.actions, result)
+		result := step.Action.Execute(newCidrNode, lastNode, step.TargetNode, path)
+		insertionResults.Actions = append(insertionResults.Actions, result)
 	}
 
 	return insertionResults
@@ -215,17 +405,27 @@ func isThereAConflict(currentNode *CidrTrie, targetedDepth int) ConflictType {
 // Note:
 //   - The function assumes that if all priorities of `a` are equal to `b`, then `a` should be greater than `b`.
 //   - The priorities are compared in a lexicographical order, similar to comparing version numbers or tuples.
+//   - If the priorities have different lengths, the missing values of the shorter one are compared as 0.
 func DefaultComparator(a *Metadata, b *Metadata) bool {
 	// Compare priority values lexicographically.
-	for i := range a.Priority {
-		if a.Priority[i] > b.Priority[i] {
+	for i := range max(len(a.Priority), len(b.Priority)) {
+		aPriority, bPriority := priorityAt(a.Priority, i), priorityAt(b.Priority, i)
+		if aPriority > bPriority {
 
 			// If any priority of 'a' is less than 'b', return false immediately.
 			return true
-		} else if a.Priority[i] < b.Priority[i] {
+		} else if aPriority < bPriority {
 			return false
 		}
 	}
 	// they are equal, so a is greater
 	return true
 }
+
+// returns the priority value at index i, or 0 if the priority is shorter
+func priorityAt(priority []uint8, i int) uint8 {
+	if i < len(priority) {
+		return priority[i]
+	}
+	return 0
+}
@@ -2,58 +2,10 @@ package supernet
 
 import (
 	"net"
-)
-
-// BitsToCidr converts a slice of binary bits into a net.IPNet structure that represents a CIDR.
-// This is used to form the IP address and subnet mask from a binary representation.
-//
-// Parameters:
-//   - bits: A slice of integers (0 or 1) representing the binary form of the IP address.
-//   - ipV6: A boolean flag indicating whether the address is IPv6 (true) or IPv4 (false).
-//
-// Returns:
-//   - A pointer to a net.IPNet structure that includes both the IP address and the subnet mask.
-//
-// This function dynamically constructs the IP and mask based on the length of the bits slice and the type of IP (IPv4 or IPv6).
-// It supports a flexible number of bits and automatically adjusts for IPv4 (up to 32 bits) and IPv6 (up to 128 bits).
-//
-// Example:
-//
-//	For a bits slice representing "192.168.1.1" and ipV6 set to false, the function would return an IPNet with the IP "192.168.1.1"
-//	and a full subnet mask "255.255.255.255" if all bits are provided.
-func BitsToCidr(bits []int, ipV6 bool) *net.IPNet {
-	maxBytes := 4
-	if ipV6 {
-		maxBytes = 16 // Set the byte limit to 16 for IPv6
-	}
+	"net/netip"
 
-	ipBytes := make([]byte, 0, maxBytes)
-	maskBytes := make([]byte, 0, maxBytes)
-	currentBit := 0
-	bitsLen := len(bits) - 1
-
-	for iByte := 0; iByte < maxBytes; iByte++ {
-		var ipByte byte
-		var maskByte byte
-		for i := 0; i < 8; i++ {
-			if currentBit <= bitsLen {
-				ipByte = ipByte<<1 | byte(bits[currentBit])
-				maskByte = maskByte<<1 | 1 // Add a bit to the mask for each bit processed
-				currentBit++
-			} else {
-				ipByte = ipByte << 1 // Shift the byte to the left, filling with zeros
-				maskByte = maskByte << 1
-			}
-		}
-		ipBytes = append(ipBytes, ipByte)
-		maskBytes = append(maskBytes, maskByte)
-	}
-
-	return &net.IPNet{
-		IP:   net.IP(ipBytes),
-		Mask: net.IPMask(maskBytes),
-	}
-}
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+)
 
 // NodeToCidr converts a given trie node into a CIDR (Classless Inter-Domain Routing) string representation.
 // This function uses the node's path to generate the CIDR string.
@@ -65,60 +17,49 @@ func NodeToCidr(t *CidrTrie) string {
 	if t.Metadata() == nil {
 		panic("[Bug] NodeToCidr: Cannot convert a trie path node to CIDR, metadata is missing")
 	}
-	// Convert the binary path of the trie node to CIDR format using the bitsToCidr function,
-	// then convert the resulting net.IPNet object to a string.
-	return BitsToCidr(t.Path(), t.Metadata().IsV6).String()
+	// Convert the packed path of the trie node to a netip.Prefix, then to a string.
+	return NodeToPrefix(t).String()
 }
 
-// CidrToBits converts a net.IPNet object into a slice of integers representing the binary bits of the network address.
-// Additionally, it returns the depth of the network mask.
-//
-// The function panics if:
-//   - ipnet is nil, indicating invalid input.
-//   - the network mask is /0, which is technically valid but not supported by this library.
-//
-// Parameters:
-//   - ipnet: Pointer to a net.IPNet object containing the IP address and the network mask.
-//
-// Returns:
-//
-//   - A slice of integers representing the binary format of the IP address up to the length of the network mask.
-//
-//   - An integer representing the number of bits in the network mask minus one.
-//
-//     Example:
-//     For IP address "192.168.1.1/24", this function would return a slice with the first 24 bits of the address in binary form,
-//     and the number 23 as the depth.
-func CidrToBits(ipnet *net.IPNet) ([]int, int) {
-	if ipnet == nil {
-		panic("[BUG] cidrToBits: IPNet is nil: validate the input before calling cidrToBits")
+// NodeToPrefix converts a given trie node into a netip.Prefix, the same way NodeToCidr converts it to a string.
+func NodeToPrefix(t *CidrTrie) netip.Prefix {
+	if t.Metadata() == nil {
+		panic("[Bug] NodeToPrefix: Cannot convert a trie path node to CIDR, metadata is missing")
 	}
+	return t.Path().Prefix(t.Metadata().IsV6)
+}
 
-	maskSize, _ := ipnet.Mask.Size()
-	if maskSize == 0 {
-		panic("[BUG] cidrToBits: network Mask /0 not valid: " + ipnet.String())
+// returns the packed path of a CIDR, it panics if ipnet is nil, or if its mask is /0, which is not supported
+func cidrPath(ipnet *net.IPNet) ipbits.Path {
+	if ipnet == nil {
+		panic("[BUG] cidrPath: IPNet is nil: validate the input before calling cidrPath")
 	}
+	path := ipbits.PathFromIPNet(ipnet)
+	if path.Len == 0 {
+		panic("[BUG] cidrPath: network Mask /0 not valid: " + ipnet.String())
+	}
+	return path
+}
 
-	path := make([]int, maskSize)
-	currentBit := 0
-
-	// Process each byte of the IP address to convert it into bits.
-	for _, byteVal := range ipnet.IP {
-		// Iterate over each bit in the byte.
-		for bitPosition := 0; bitPosition < 8; bitPosition++ {
-			// Shift the byte to the right to place the bit at the most significant position (leftmost),
-			// and mask it with 1 to isolate the bit.
-			bit := (byteVal >> (7 - bitPosition)) & 1
-			path[currentBit] = int(bit)
+// converts a net.IPNet into the equivalent netip.Prefix, IPv4-mapped IPv6 addresses are unmapped.
+func ipnetToPrefix(ipnet *net.IPNet) netip.Prefix {
+	addr, _ := netip.AddrFromSlice(ipnet.IP)
+	ones, _ := ipnet.Mask.Size()
+	return netip.PrefixFrom(addr.Unmap(), ones)
+}
 
-			// If we have processed bits equal to the size of the network mask, return the result.
-			if currentBit == (maskSize - 1) {
-				return path, maskSize - 1
-			}
-			currentBit++
-		}
+// masks prefix, and converts an IPv4-mapped IPv6 prefix covering only IPv4 addresses into the IPv4 prefix.
+func unmapPrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
 	}
+	return prefix.Masked()
+}
 
-	// This line should not be reached; if it is, there is an error in bit calculation.
-	panic("[BUG] cidrToBits: bit calculation error - did not process enough bits for the mask size")
+// converts a netip.Prefix into the equivalent net.IPNet.
+func prefixToIPNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   prefix.Addr().AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
 }
@@ -0,0 +1,282 @@
+package trie
+
+import (
+	"unsafe"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+)
+
+// RadixTrie is a generic path-compressed (Patricia) binary trie keyed by IP paths.
+//
+// Unlike BinaryTrie, which creates one node for each bit, a RadixTrie only creates nodes
+// where a value is stored, or where two paths branch, so a single IPv6 /64 costs one node instead of 64.
+type RadixTrie[T any] struct {
+	root  *radixNode[T]
+	size  int // nodes with a value
+	nodes int // all the nodes, excluding the root
+}
+
+type radixNode[T any] struct {
+	path     ipbits.Path      // full path from the root to this node
+	children [2]*radixNode[T] // child nodes, indexed by the bit following the node path
+	value    *T               // nil for branching nodes
+}
+
+// creates an empty radix trie
+func NewRadixTrie[T any]() *RadixTrie[T] {
+	return &RadixTrie[T]{root: &radixNode[T]{}}
+}
+
+// returns the number of stored values
+func (t *RadixTrie[T]) Len() int {
+	return t.size
+}
+
+// returns the number of nodes, excluding the root, including the branching nodes without value
+func (t *RadixTrie[T]) NodeCount() int {
+	return t.nodes
+}
+
+// returns the size of a node in bytes, excluding its value
+func (t *RadixTrie[T]) NodeSize() uintptr {
+	return unsafe.Sizeof(radixNode[T]{})
+}
+
+// Insert stores the value at path, replacing any existing value at the same path.
+func (t *RadixTrie[T]) Insert(path ipbits.Path, value *T) {
+	node := t.root
+	for {
+		if node.path.Len == path.Len {
+			if node.value == nil {
+				t.size++
+			}
+			node.value = value
+			return
+		}
+
+		bit := path.Key.Bit(node.path.Len)
+		child := node.children[bit]
+		if child == nil {
+			node.children[bit] = &radixNode[T]{path: path, value: value}
+			t.size++
+			t.nodes++
+			return
+		}
+
+		common := min(child.path.Key.CommonPrefixLen(path.Key), child.path.Len, path.Len)
+		if common == child.path.Len {
+			node = child
+			continue
+		}
+
+		// the child path diverges from the inserted path, so a node is added where they branch
+		branch := &radixNode[T]{path: ipbits.Path{Key: path.Key.Masked(common), Len: common}}
+		branch.children[child.path.Key.Bit(common)] = child
+		node.children[bit] = branch
+		t.nodes++
+		if common == path.Len {
+			branch.value = value
+		} else {
+			branch.children[path.Key.Bit(common)] = &radixNode[T]{path: path, value: value}
+			t.nodes++
+		}
+		t.size++
+		return
+	}
+}
+
+// Get returns the value stored exactly at path, or nil.
+func (t *RadixTrie[T]) Get(path ipbits.Path) *T {
+	node := t.root
+	for node != nil && node.path.Len < path.Len {
+		node = node.children[path.Key.Bit(node.path.Len)]
+	}
+	if node == nil || node.path != path {
+		return nil
+	}
+	return node.value
+}
+
+// Delete removes the value stored exactly at path, and the nodes that are no longer needed.
+// It returns false if there was no value at path.
+func (t *RadixTrie[T]) Delete(path ipbits.Path) bool {
+	var grandParent, parent *radixNode[T]
+	node := t.root
+	for node != nil && node.path.Len < path.Len {
+		grandParent, parent = parent, node
+		node = node.children[path.Key.Bit(node.path.Len)]
+	}
+	if node == nil || node.path != path || node.value == nil {
+		return false
+	}
+
+	node.value = nil
+	t.size--
+	if node == t.root {
+		return true
+	}
+
+	switch {
+	case node.children[0] != nil && node.children[1] != nil:
+		// still a branching node
+	case node.children[0] != nil || node.children[1] != nil:
+		t.splice(parent, node)
+	default:
+		parent.children[node.path.Key.Bit(parent.path.Len)] = nil
+		t.nodes--
+		// the parent may be a branching node that is left with one child
+		if parent != t.root && parent.value == nil {
+			t.splice(grandParent, parent)
+		}
+	}
+	return true
+}
+
+// DeleteWithin removes the values stored at path, or under it, and returns how many were removed.
+func (t *RadixTrie[T]) DeleteWithin(path ipbits.Path) int {
+	var grandParent, parent *radixNode[T]
+	node := t.root
+	for node != nil && node.path.Len < path.Len {
+		grandParent, parent = parent, node
+		node = node.children[path.Key.Bit(node.path.Len)]
+	}
+	if node == nil || !path.Contains(node.path) {
+		return 0
+	}
+
+	removed, nodes := 0, 0
+	node.walk(func(n *radixNode[T]) bool {
+		if n.value != nil {
+			removed++
+		}
+		nodes++
+		return true
+	})
+	t.size -= removed
+	if node == t.root {
+		t.root = &radixNode[T]{}
+		t.nodes = 0
+		return removed
+	}
+
+	parent.children[node.path.Key.Bit(parent.path.Len)] = nil
+	t.nodes -= nodes
+	// the parent may be a branching node that is left with one child
+	if parent != t.root && parent.value == nil {
+		t.splice(grandParent, parent)
+	}
+	return removed
+}
+
+// replaces the node by its only child
+func (t *RadixTrie[T]) splice(parent *radixNode[T], node *radixNode[T]) {
+	child := node.children[0]
+	if child == nil {
+		child = node.children[1]
+	}
+	parent.children[node.path.Key.Bit(parent.path.Len)] = child
+	t.nodes--
+}
+
+// Covering returns the longest stored path that is equal to, or contains path, and its value.
+// Lookups of a single address are done with a full length path, e.g. a /32 for IPv4.
+func (t *RadixTrie[T]) Covering(path ipbits.Path) (ipbits.Path, *T, bool) {
+	var found *radixNode[T]
+	node := t.root
+	for node != nil && node.path.Len <= path.Len && node.path.Contains(path) {
+		if node.value != nil {
+			found = node
+		}
+		if node.path.Len == path.Len {
+			break
+		}
+		node = node.children[path.Key.Bit(node.path.Len)]
+	}
+	if found == nil {
+		return ipbits.Path{}, nil, false
+	}
+	return found.path, found.value, true
+}
+
+// Within calls f for each stored path that is equal to, or contained by path, in address order,
+// until f returns false.
+func (t *RadixTrie[T]) Within(path ipbits.Path, f func(path ipbits.Path, value *T) bool) {
+	node := t.root
+	for node != nil && node.path.Len < path.Len {
+		node = node.children[path.Key.Bit(node.path.Len)]
+	}
+	if node == nil || !path.Contains(node.path) {
+		return
+	}
+	node.walk(func(n *radixNode[T]) bool {
+		if n.value == nil {
+			return true
+		}
+		return f(n.path, n.value)
+	})
+}
+
+// ForEachNode calls f for each node, excluding the root, with its value, nil for branching nodes.
+func (t *RadixTrie[T]) ForEachNode(f func(path ipbits.Path, value *T)) {
+	t.root.walk(func(n *radixNode[T]) bool {
+		if n != t.root {
+			f(n.path, n.value)
+		}
+		return true
+	})
+}
+
+// visits the node and its descendants in pre-order, zero child first, until f returns false
+func (n *radixNode[T]) walk(f func(*radixNode[T]) bool) bool {
+	if !f(n) {
+		return false
+	}
+	for _, child := range n.children {
+		if child != nil && !child.walk(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// RadixIterator iterates over the stored paths of a RadixTrie, see Iterator.
+type RadixIterator[T any] struct {
+	stack []*radixNode[T] // nodes left to visit, the next one last
+	node  *radixNode[T]   // current node
+}
+
+// Iterator returns an iterator over the stored paths in address order, like Within, but pulled one at a time.
+// It holds at most one pending node per level, and the trie must not be modified while iterating.
+func (t *RadixTrie[T]) Iterator() *RadixIterator[T] {
+	return &RadixIterator[T]{stack: []*radixNode[T]{t.root}}
+}
+
+// Next advances to the next stored path, it returns false when there is none left.
+func (it *RadixIterator[T]) Next() bool {
+	for len(it.stack) > 0 {
+		node := it.stack[len(it.stack)-1]
+		it.stack = it.stack[:len(it.stack)-1]
+		// the zero child is pushed last, so it is visited first
+		for bit := 1; bit >= 0; bit-- {
+			if node.children[bit] != nil {
+				it.stack = append(it.stack, node.children[bit])
+			}
+		}
+		if node.value != nil {
+			it.node = node
+			return true
+		}
+	}
+	it.node = nil
+	return false
+}
+
+// returns the current path, Next must have returned true
+func (it *RadixIterator[T]) Path() ipbits.Path {
+	return it.node.path
+}
+
+// returns the value of the current path, Next must have returned true
+func (it *RadixIterator[T]) Value() *T {
+	return it.node.value
+}
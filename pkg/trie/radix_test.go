@@ -0,0 +1,165 @@
+package trie
+
+import (
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRadixInsertAndGet(t *testing.T) {
+	root := NewRadixTrie[string]()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.2.0.0/16", "192.168.0.0/16"} {
+		root.Insert(radixPath(cidr), strPtr(cidr))
+	}
+
+	assert.Equal(t, 4, root.Len())
+	// 10.1.0.0/16 and 10.2.0.0/16 branch at 10.0.0.0/14, which is a node without value
+	assert.Equal(t, 5, root.NodeCount())
+	assert.Equal(t, "10.1.0.0/16", *root.Get(radixPath("10.1.0.0/16")))
+	assert.Nil(t, root.Get(radixPath("10.1.0.0/17")))
+	assert.Nil(t, root.Get(radixPath("10.0.0.0/7")))
+
+	root.Insert(radixPath("10.1.0.0/16"), strPtr("replaced"))
+	assert.Equal(t, 4, root.Len())
+	assert.Equal(t, "replaced", *root.Get(radixPath("10.1.0.0/16")))
+}
+
+func TestRadixBranchingNode(t *testing.T) {
+	root := NewRadixTrie[string]()
+	root.Insert(radixPath("10.1.0.0/16"), strPtr("a"))
+	root.Insert(radixPath("10.2.0.0/16"), strPtr("b"))
+
+	// the branching node at 10.0.0.0/14 has no value
+	assert.Equal(t, 3, root.NodeCount())
+	assert.Nil(t, root.Get(radixPath("10.0.0.0/14")))
+
+	root.Insert(radixPath("10.0.0.0/14"), strPtr("c"))
+	assert.Equal(t, 3, root.NodeCount())
+	assert.Equal(t, "c", *root.Get(radixPath("10.0.0.0/14")))
+}
+
+func TestRadixDelete(t *testing.T) {
+	root := NewRadixTrie[string]()
+	for _, cidr := range []string{"10.1.0.0/16", "10.2.0.0/16", "10.2.1.0/24"} {
+		root.Insert(radixPath(cidr), strPtr(cidr))
+	}
+
+	assert.False(t, root.Delete(radixPath("10.3.0.0/16")))
+	assert.True(t, root.Delete(radixPath("10.2.0.0/16")))
+	assert.Nil(t, root.Get(radixPath("10.2.0.0/16")))
+	assert.Equal(t, "10.2.1.0/24", *root.Get(radixPath("10.2.1.0/24")))
+
+	assert.True(t, root.Delete(radixPath("10.2.1.0/24")))
+	assert.Equal(t, 1, root.Len())
+	// the branching node is not needed anymore
+	assert.Equal(t, 1, root.NodeCount())
+
+	assert.True(t, root.Delete(radixPath("10.1.0.0/16")))
+	assert.Equal(t, 0, root.Len())
+	assert.Equal(t, 0, root.NodeCount())
+}
+
+func TestRadixDeleteWithin(t *testing.T) {
+	root := NewRadixTrie[string]()
+	for _, cidr := range []string{"10.1.0.0/16", "10.1.1.0/24", "10.1.2.0/24", "10.2.0.0/16", "11.0.0.0/8"} {
+		root.Insert(radixPath(cidr), strPtr(cidr))
+	}
+
+	assert.Equal(t, 0, root.DeleteWithin(radixPath("12.0.0.0/8")))
+	assert.Equal(t, 0, root.DeleteWithin(radixPath("10.1.3.0/24")))
+	assert.Equal(t, 3, root.DeleteWithin(radixPath("10.1.0.0/16")))
+	assert.Equal(t, 2, root.Len())
+	// the branching node of 10.1.0.0/16 and 10.2.0.0/16 is not needed anymore
+	assert.Equal(t, 3, root.NodeCount())
+	assert.Equal(t, "10.2.0.0/16", *root.Get(radixPath("10.2.0.0/16")))
+
+	// the path does not need to be a node
+	assert.Equal(t, 1, root.DeleteWithin(radixPath("10.0.0.0/8")))
+	assert.Equal(t, 1, root.Len())
+	assert.Equal(t, 1, root.NodeCount())
+
+	assert.Equal(t, 1, root.DeleteWithin(ipbits.Path{}))
+	assert.Equal(t, 0, root.Len())
+	assert.Equal(t, 0, root.NodeCount())
+	assert.False(t, root.Iterator().Next())
+}
+
+func TestRadixCoveringAndWithin(t *testing.T) {
+	root := NewRadixTrie[string]()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.1.0/24", "10.2.0.0/16", "11.0.0.0/8"} {
+		root.Insert(radixPath(cidr), strPtr(cidr))
+	}
+
+	path, value, found := root.Covering(radixPath("10.1.1.1/32"))
+	assert.True(t, found)
+	assert.Equal(t, "10.1.1.0/24", *value)
+	assert.Equal(t, radixPath("10.1.1.0/24"), path)
+
+	_, value, _ = root.Covering(radixPath("10.1.2.0/24"))
+	assert.Equal(t, "10.1.0.0/16", *value)
+	_, value, _ = root.Covering(radixPath("10.1.0.0/16"))
+	assert.Equal(t, "10.1.0.0/16", *value)
+	_, _, found = root.Covering(radixPath("12.0.0.0/8"))
+	assert.False(t, found)
+
+	within := []string{}
+	root.Within(radixPath("10.0.0.0/8"), func(path ipbits.Path, value *string) bool {
+		within = append(within, *value)
+		return true
+	})
+	assert.Equal(t, []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.1.0/24", "10.2.0.0/16"}, within)
+
+	within = []string{}
+	root.Within(radixPath("10.0.0.0/9"), func(path ipbits.Path, value *string) bool {
+		within = append(within, *value)
+		return false
+	})
+	assert.Equal(t, []string{"10.1.0.0/16"}, within)
+}
+
+func TestRadixIterator(t *testing.T) {
+	root := NewRadixTrie[string]()
+	assert.False(t, root.Iterator().Next())
+
+	for _, cidr := range []string{"11.0.0.0/8", "10.1.1.0/24", "10.0.0.0/8", "10.2.0.0/16", "10.1.0.0/16"} {
+		root.Insert(radixPath(cidr), strPtr(cidr))
+	}
+	iterated := []string{}
+	for it := root.Iterator(); it.Next(); {
+		assert.Equal(t, radixPath(*it.Value()), it.Path())
+		iterated = append(iterated, *it.Value())
+	}
+	assert.Equal(t, []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.1.0/24", "10.2.0.0/16", "11.0.0.0/8"}, iterated)
+}
+
+func TestRadixRandomPaths(t *testing.T) {
+	root := NewRadixTrie[string]()
+	inserted := map[ipbits.Path]bool{}
+	for _, bits := range generateRandomPaths(2000, 1, 32) {
+		path := ipbits.Path{Len: len(bits)}
+		for i, bit := range bits {
+			path.Key = path.Key.WithBit(i, bit)
+		}
+		root.Insert(path, strPtr(""))
+		inserted[path] = true
+	}
+	assert.Equal(t, len(inserted), root.Len())
+
+	for path := range inserted {
+		if rand.Intn(2) == 0 {
+			assert.True(t, root.Delete(path))
+			delete(inserted, path)
+		}
+	}
+	assert.Equal(t, len(inserted), root.Len())
+	for path := range inserted {
+		assert.NotNil(t, root.Get(path))
+	}
+}
+
+func radixPath(cidr string) ipbits.Path {
+	return ipbits.PathFromPrefix(netip.MustParsePrefix(cidr))
+}
@@ -1,6 +1,10 @@
 package trie
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
+)
 
 // is an alias for int used to define child positions in a trie node.
 type ChildPos = int
@@ -193,18 +197,14 @@ func (t *BinaryTrie[T]) ForEachStepUp(f func(*BinaryTrie[T]), while func(*Binary
 	return t
 }
 
-// return the path from the root node
-// the path is an array of 0's and 1's
-// reverse it if you need the path form the child to the root
-func (t *BinaryTrie[T]) Path() []int {
-	path := []int{}
-
-	t.ForEachStepUp(func(tr *BinaryTrie[T]) {
-		path = append([]int{tr.Pos()}, path...)
-	}, nil)
-
+// return the path from the root node, packed one bit per depth, so it does not allocate
+// the trie must not be deeper than the 128 bits of a key
+func (t *BinaryTrie[T]) Path() ipbits.Path {
+	path := ipbits.Path{Len: t.Depth()}
+	for node := t; !node.IsRoot(); node = node.Parent() {
+		path.Key = path.Key.WithBit(node.Depth()-1, node.Pos())
+	}
 	return path
-
 }
 
 // return all the leafs on the tree
@@ -220,9 +220,8 @@ func (t *BinaryTrie[T]) Leafs() []*BinaryTrie[T] {
 
 // Generate an array of leafs paths which is uniq by definition
 // the path is from the root to leaf
-// reverse it if you need the path from leaf to root
-func (root *BinaryTrie[T]) LeafsPaths() [][]int {
-	paths := [][]int{}
+func (root *BinaryTrie[T]) LeafsPaths() []ipbits.Path {
+	paths := []ipbits.Path{}
 	root.ForEachStepDown(func(t *BinaryTrie[T]) {
 		if t.IsLeaf() {
 			paths = append(paths, t.Path())
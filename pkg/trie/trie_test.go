@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/khalid-nowaf/supernet/pkg/ipbits"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -97,8 +98,8 @@ func TestGetPath(t *testing.T) {
 	grandchild := child.AttachChild(NewTrie(), ZERO)
 
 	path := grandchild.Path()
-	expectedPath := []int{1, 0}
-	assert.Equal(t, expectedPath, path, "Path should correctly represent the bits from root to grandchild")
+	assert.Equal(t, 2, path.Len)
+	assert.Equal(t, "10", path.String(), "Path should correctly represent the bits from root to grandchild")
 }
 
 // TestGetUniquePaths verifies that unique paths in a trie are correctly identified and returned.
@@ -108,12 +109,8 @@ func TestGetUniquePaths(t *testing.T) {
 
 	generateTrieAs(paths, root)
 
-	expectedPaths := [][]int{
-		{0, 0, 1, 0},
-		{1, 0, 1, 0, 1, 0},
-		{1, 1, 1, 1},
-	}
-	actualPaths := root.LeafsPaths()
+	expectedPaths := []string{"0010", "101010", "1111"}
+	actualPaths := pathStrings(root.LeafsPaths())
 	assert.ElementsMatch(t, expectedPaths, actualPaths, "Unique paths should match the expected paths")
 }
 
@@ -159,23 +156,20 @@ func TestDetach(t *testing.T) {
 	paths := []string{"0010", "0011"}
 	root := NewTrie()
 	generateTrieAs(paths, root)
-	assert.ElementsMatch(t, [][]int{
-		{0, 0, 1, 0},
-		{0, 0, 1, 1},
-	}, root.LeafsPaths())
+	assert.ElementsMatch(t, []string{"0010", "0011"}, pathStrings(root.LeafsPaths()))
 	leafs := root.Leafs()
 
 	leafs[0].Detach()
 
 	newLeafs := root.Leafs()
 	assert.Equal(t, 1, len(newLeafs))
-	assert.Equal(t, []int{0, 0, 1, 1}, newLeafs[0].Path())
+	assert.Equal(t, "0011", newLeafs[0].Path().String())
 
 	leafs[1].Detach()
 
 	newLeafs = root.Leafs()
 	assert.Equal(t, 1, len(newLeafs))
-	assert.Equal(t, []int{0, 0, 1}, newLeafs[0].Path())
+	assert.Equal(t, "001", newLeafs[0].Path().String())
 
 }
 func TestDetachBranch(t *testing.T) {
@@ -188,12 +182,10 @@ func TestDetachBranch(t *testing.T) {
 
 	generateTrieAs(paths, root)
 
-	expectedPaths := [][]int{
-		{0, 0, 1, 0},
-	}
+	expectedPaths := []string{"0010"}
 	lastLeaf := root.Leafs()
 	lastLeaf[1].DetachBranch(0)
-	actualPaths := root.LeafsPaths()
+	actualPaths := pathStrings(root.LeafsPaths())
 	assert.ElementsMatch(t, expectedPaths, actualPaths, "Unique paths should match the expected paths")
 
 	// case where the bench is the first
@@ -204,12 +196,10 @@ func TestDetachBranch(t *testing.T) {
 
 	generateTrieAs(paths, root)
 
-	expectedPaths = [][]int{
-		{0, 1},
-	}
+	expectedPaths = []string{"01"}
 	lastLeaf = root.Leafs()
 	lastLeaf[1].DetachBranch(0)
-	actualPaths = root.LeafsPaths()
+	actualPaths = pathStrings(root.LeafsPaths())
 	assert.ElementsMatch(t, expectedPaths, actualPaths, "Unique paths should match the expected paths")
 }
 
@@ -250,15 +240,16 @@ func BenchmarkRead32BitPaths(b *testing.B) {
 		}
 	}
 
-	paths = root.LeafsPaths()
-	maxPaths := len(paths)
+	leafPaths := root.LeafsPaths()
+	maxPaths := len(leafPaths)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		node := root
 		pr := root
-		randomPath := paths[rand.Intn(maxPaths)]
-		for _, pos := range randomPath {
+		randomPath := leafPaths[rand.Intn(maxPaths)]
+		for depth := 0; depth < randomPath.Len; depth++ {
+			pos := randomPath.Key.Bit(depth)
 			if node == nil {
 				fmt.Printf("Node is nil \npr node path: %v\n random path is:%v\n", pr.Path(), randomPath)
 				panic("node is nil")
@@ -284,6 +275,15 @@ func BenchmarkGetLeafPaths(b *testing.B) {
 
 }
 
+// returns the bits of each path, like "0010"
+func pathStrings(paths []ipbits.Path) []string {
+	strs := []string{}
+	for _, path := range paths {
+		strs = append(strs, path.String())
+	}
+	return strs
+}
+
 // generateTrieAs constructs a trie based on provided paths and updates it to contain metadata indicating its creation path.
 func generateTrieAs(paths []string, trie *BinaryTrie[string]) {
 	for _, path := range paths {